@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
@@ -12,7 +13,10 @@ import (
 
 	"github.com/Rassimdou/Real-time-Analytics/internal/aggregation"
 	"github.com/Rassimdou/Real-time-Analytics/internal/config"
+	"github.com/Rassimdou/Real-time-Analytics/internal/dedup"
 	"github.com/Rassimdou/Real-time-Analytics/internal/server"
+	"github.com/Rassimdou/Real-time-Analytics/internal/wal"
+	"github.com/Rassimdou/Real-time-Analytics/storage"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
@@ -46,29 +50,195 @@ func main() {
 	// Create event queue (buffered channel)
 	eventQueue := make(chan server.Event, cfg.Processing.BufferSize)
 
-	// Create aggregator
-	agg := aggregation.NewAggregator(
-		1*time.Minute,  // Window duration: 1 minute
-		10*time.Second, // Flush interval: 10 seconds
-		logger,
-	)
+	rules, err := metricRulesFromConfig(cfg.Aggregation.Rules)
+	if err != nil {
+		logger.Fatal("invalid metric rule", zap.Error(err))
+	}
+
+	// Create aggregator (one window manager per configured window spec)
+	flushInterval := 10 * time.Second // Flush interval: 10 seconds
+	sets := aggregation.SetOptions{
+		Mode:           aggregation.SetMode(cfg.Aggregation.Sets.Mode),
+		Precision:      cfg.Aggregation.Sets.Precision,
+		ExactThreshold: cfg.Aggregation.Sets.ExactThreshold,
+	}
+	agg := aggregation.NewAggregatorWithOptions(aggregation.Options{
+		Windows:         windowSpecsFromConfig(cfg.Window),
+		FlushInterval:   flushInterval,
+		AllowedLateness: cfg.Window.AllowedLateness,
+		Sets:            sets,
+		Rules:           rules,
+		Cardinality: aggregation.CardinalityLimits{
+			Default:  cfg.Aggregation.Cardinality.DefaultLimit,
+			Families: cfg.Aggregation.Cardinality.Limits,
+		},
+		TopKCapacity:   cfg.Aggregation.TopKCapacity,
+		Gauges:         cfg.Aggregation.Gauges,
+		AmountProperty: cfg.Aggregation.AmountProperty,
+		Funnels:        funnelsFromConfig(cfg.Aggregation.Funnels),
+		Retention:      retentionFromConfig(cfg.Aggregation.Retention),
+		Transitions:    transitionsFromConfig(cfg.Aggregation.Transitions),
+		Anomalies:      anomaliesFromConfig(cfg.Aggregation.Anomalies),
+		Rolling:        rollingFromConfig(cfg.Aggregation.Rolling),
+		// One accumulator per worker so concurrent workers rarely share a lock
+		Shards: cfg.Processing.WorkerCount,
+	}, logger)
+
+	// Optional Postgres persistence of the closed windows of one 1-minute tumbling spec
+	var store *storage.PostegresStorage
+	var persisted aggregation.WindowSpec
+	if cfg.Storage.Postgres.Enabled {
+		persisted, err = persistedSpec(agg.GetWindowSpecs(), cfg.Storage.Postgres.Spec)
+		if err != nil {
+			logger.Fatal("invalid postgres spec", zap.Error(err))
+		}
+		store, err = storage.NewPostgresStorage(cfg.GetPostgresConnectionString(), cfg.Storage.Postgres.MaxConnections, logger)
+		if err != nil {
+			logger.Fatal("failed to connect to postgres", zap.Error(err))
+		}
+		defer store.Close()
+	}
+
+	// Hourly and daily rollups of the persisted 1-minute windows
+	var rollup *aggregation.Rollup
+	if store != nil && cfg.Storage.Postgres.Rollups.Enabled {
+		grace := cfg.Storage.Postgres.Rollups.Grace
+		if grace == 0 {
+			// Amended windows are re-emitted up to one flush after the allowed lateness
+			grace = cfg.Window.AllowedLateness + 2*flushInterval
+		}
+		rollup, err = aggregation.NewRollup(time.Minute, aggregation.DefaultRollupLevels(), grace, aggregation.SnapshotOptions{
+			Sets:         sets,
+			TopKCapacity: cfg.Aggregation.TopKCapacity,
+		})
+		if err != nil {
+			logger.Fatal("failed to create rollups", zap.Error(err))
+		}
+		restoreRollups(store, rollup, time.Now(), logger)
+	}
+
+	// Retention cohorts from the stored event history
+	if retention := agg.Retention(); retention != nil && cfg.Aggregation.Retention.Backfill > 0 {
+		if store == nil {
+			logger.Warn("retention backfill requires postgres storage, skipped")
+		} else {
+			backfillRetention(store, retention, time.Now().Add(-cfg.Aggregation.Retention.Backfill), logger)
+		}
+	}
+
+	// Restore global metrics and open windows from the last checkpoint
+	var checkpoints aggregation.CheckpointStore
+	var restored *aggregation.Checkpoint
+	if cfg.Storage.Checkpoint.Enabled {
+		checkpoints = checkpointStoreFromConfig(cfg.Storage.Checkpoint, store)
+		restored = restoreCheckpoint(agg, checkpoints, logger)
+	}
+
+	// Write-ahead log of accepted events, replayed below once the callbacks are set
+	var journal *wal.WAL
+	if cfg.Storage.WAL.Enabled {
+		journal, err = wal.Open(wal.Options{
+			Dir:          cfg.Storage.WAL.Dir,
+			SegmentSize:  cfg.Storage.WAL.SegmentSize,
+			Sync:         wal.SyncPolicy(cfg.Storage.WAL.Sync),
+			SyncInterval: cfg.Storage.WAL.SyncInterval,
+		})
+		if err != nil {
+			logger.Fatal("failed to open write-ahead log", zap.Error(err))
+		}
+		defer journal.Close()
+	}
+
+	// Threshold alerts evaluated on each closed window
+	var alerts *aggregation.Alerts
+	if len(cfg.Alerting.Rules) > 0 {
+		alerts, err = aggregation.NewAlerts(aggregation.AlertOptions{
+			Rules:     alertRulesFromConfig(cfg.Alerting.Rules),
+			Notifiers: notifiersFromConfig(cfg.Alerting.Notifiers, logger),
+			Timeout:   cfg.Alerting.Timeout,
+			QueueSize: cfg.Alerting.QueueSize,
+		}, logger)
+		if err != nil {
+			logger.Fatal("failed to create alerts", zap.Error(err))
+		}
+		warnUnknownAlertSpecs(cfg.Alerting.Rules, agg.GetWindowSpecs(), logger)
+	}
 
 	// Set callback pour fenêtres fermées
 	agg.SetWindowClosedCallback(func(window *aggregation.TimeWindow) {
+		events, _ := window.Metrics.GetMetricValue("events")
 		logger.Info("window closed",
+			zap.String("spec", window.Spec),
+			zap.String("key", window.Key),
+			zap.Int("revision", window.Revision),
 			zap.Time("start", window.StartTime),
 			zap.Time("end", window.EndTime),
-			zap.Int("events", int(window.Metrics.GetAllMetrics()["events"].Count)),
+			zap.Int("events", int(events)),
 		)
-		// TODO: Sauvegarder dans PostgreSQL/Redis
+
+		// metrics_1m holds the windows of the persisted spec (amended revisions replace earlier rows)
+		if store != nil && window.Spec == persisted.Name && window.Key == "" {
+			saveCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := store.SaveMetrics1m(saveCtx, window.StartTime, metricRowsFromWindow(window, rollup != nil)); err != nil {
+				logger.Error("failed to persist window", zap.String("spec", window.Spec), zap.Error(err))
+			}
+			if rollup != nil && !rollup.AddWindow(window) {
+				logger.Warn("window arrived after its hour was rolled up",
+					zap.Time("start", window.StartTime),
+					zap.Int("revision", window.Revision),
+				)
+			}
+		}
+
+		if alerts != nil {
+			alerts.Evaluate(window, agg.WindowStride(window.Spec))
+		}
 	})
 
+	// Side output for events arriving after the allowed lateness
+	agg.SetLateEventCallback(func(late aggregation.LateEvent) {
+		logger.Warn("late event dropped",
+			zap.String("spec", late.Spec),
+			zap.String("event_id", late.Event.ID),
+			zap.Time("event_time", late.Event.Timestamp),
+			zap.Time("watermark", late.Watermark),
+		)
+	})
+
+	// Recently accepted event IDs, so that client retries are not counted twice
+	var seen *dedup.Store
+	if cfg.Processing.Dedup.Enabled {
+		seen = dedup.New(dedup.Options{
+			TTL:      cfg.Processing.Dedup.TTL,
+			Capacity: cfg.Processing.Dedup.Capacity,
+		})
+	}
+
+	// Replay the events accepted but not processed (or not checkpointed) before the restart
+	if journal != nil {
+		replayWAL(agg, journal, restored, seen, logger)
+	}
+
 	// Create context for aggregator and workers
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// Start aggregator
 	go agg.Start(ctx)
 
+	// Start rollups
+	if rollup != nil {
+		go runRollups(ctx, store, rollup, logger)
+	}
+
+	// Start periodic checkpoints
+	if checkpoints != nil {
+		go runCheckpoints(ctx, agg, checkpoints, journal, cfg.Storage.Checkpoint.Interval, logger)
+	} else if journal != nil {
+		// Without checkpoints, processed events no longer need replaying
+		go runWALCommits(ctx, journal, cfg.Storage.WAL.CommitInterval, logger)
+	}
+
 	// Determine Gin mode based on log level
 	ginMode := "release"
 	if cfg.Logging.Level == "debug" {
@@ -77,6 +247,9 @@ func main() {
 
 	// Create HTTP server
 	srv := server.NewServer(cfg.GetServerAddress(), logger, eventQueue, agg, ginMode)
+	srv.SetAlerts(alerts)
+	srv.SetWAL(journal)
+	srv.SetDedup(seen)
 
 	// Start worker pool to process events
 	var wg sync.WaitGroup
@@ -85,10 +258,19 @@ func main() {
 		wg.Add(1)
 		go func(workerID int) {
 			defer wg.Done()
-			processEvents(ctx, workerID, eventQueue, agg, logger)
+			processEvents(ctx, workerID, eventQueue, agg, journal, logger)
 		}(i)
 	}
 
+	// Deliver alert notifications off the window-closed path
+	if alerts != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			alerts.Run(ctx)
+		}()
+	}
+
 	logger.Info("started worker pool",
 		zap.Int("workers", cfg.Processing.WorkerCount),
 		zap.Int("buffer_size", cfg.Processing.BufferSize),
@@ -126,7 +308,7 @@ func main() {
 			logger.Error("server shutdown error", zap.Error(err))
 		}
 
-		// Stop workers
+		// Stop workers (they first process the events still queued)
 		cancel()
 
 		// Wait for workers to finish with timeout
@@ -143,6 +325,15 @@ func main() {
 			logger.Warn("workers did not stop in time")
 		}
 
+		// Final checkpoint once the workers have drained the queue. With the
+		// write-ahead log, it covers only processed events: any event still
+		// queued (workers stopped late) is replayed at the next start.
+		if checkpoints != nil {
+			saveCheckpoint(context.Background(), agg, checkpoints, journal, logger)
+		} else if journal != nil {
+			commitWAL(journal, logger)
+		}
+
 		logger.Info("shutdown complete")
 	}
 }
@@ -172,47 +363,659 @@ func setupLogger(level string, format string) (*zap.Logger, error) {
 	return logger, nil
 }
 
-// processEvents is a worker function that processes events from the queue
-func processEvents(ctx context.Context, workerID int, eventQueue <-chan server.Event, agg *aggregation.Aggregator, logger *zap.Logger) {
-	logger.Info("worker started", zap.Int("worker_id", workerID))
+// windowSpecsFromConfig converts the configured windows into aggregation specs
+func windowSpecsFromConfig(cfg config.WindowConfig) []aggregation.WindowSpec {
+	specs := make([]aggregation.WindowSpec, 0, len(cfg.Tumbling)+len(cfg.Sliding)+len(cfg.Session))
+	for _, w := range cfg.Tumbling {
+		specs = append(specs, aggregation.WindowSpec{
+			Kind:    aggregation.WindowKindTumbling,
+			Size:    w.Size,
+			Metrics: w.Metrics,
+		})
+	}
+	for _, w := range cfg.Sliding {
+		specs = append(specs, aggregation.WindowSpec{
+			Kind:    aggregation.WindowKindSliding,
+			Size:    w.Size,
+			Step:    w.Step,
+			Metrics: w.Metrics,
+		})
+	}
+	for _, w := range cfg.Session {
+		specs = append(specs, aggregation.WindowSpec{
+			Kind: aggregation.WindowKindSession,
+			Gap:  w.Gap,
+		})
+	}
 
-	processed := 0
-	ticker := time.NewTicker(10 * time.Second)
+	// Fall back to the historical 1-minute window when no time window is configured
+	if len(cfg.Tumbling)+len(cfg.Sliding) == 0 {
+		specs = append(specs, aggregation.WindowSpec{
+			Kind: aggregation.WindowKindTumbling,
+			Size: 1 * time.Minute,
+		})
+	}
+	return specs
+}
+
+// metricRulesFromConfig converts and validates the configured metric rules,
+// returning nil (built-in rules) when none are configured
+func metricRulesFromConfig(cfg []config.MetricRuleConfig) ([]aggregation.MetricRule, error) {
+	if len(cfg) == 0 {
+		return nil, nil
+	}
+	rules := make([]aggregation.MetricRule, 0, len(cfg))
+	for _, r := range cfg {
+		rule := aggregation.MetricRule{
+			Name:       r.Name,
+			EventType:  r.EventType,
+			Where:      ruleConditionsFromConfig(r.Where),
+			Property:   r.Property,
+			Type:       aggregation.RuleType(r.Type),
+			Dimensions: r.Dimensions,
+			Scope:      aggregation.RuleScope(r.Scope),
+		}
+		if err := rule.Validate(); err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// ruleConditionsFromConfig converts the configured event conditions
+func ruleConditionsFromConfig(cfg []config.RuleConditionConfig) []aggregation.RuleCondition {
+	where := make([]aggregation.RuleCondition, 0, len(cfg))
+	for _, cond := range cfg {
+		where = append(where, aggregation.RuleCondition{Property: cond.Property, Equals: cond.Equals})
+	}
+	return where
+}
+
+// funnelsFromConfig converts the configured conversion funnels
+func funnelsFromConfig(cfg []config.FunnelConfig) []aggregation.Funnel {
+	funnels := make([]aggregation.Funnel, 0, len(cfg))
+	for _, f := range cfg {
+		steps := make([]aggregation.FunnelStep, 0, len(f.Steps))
+		for _, step := range f.Steps {
+			steps = append(steps, aggregation.FunnelStep{
+				Name:      step.Name,
+				EventType: step.EventType,
+				Where:     ruleConditionsFromConfig(step.Where),
+			})
+		}
+		funnels = append(funnels, aggregation.Funnel{
+			Name:   f.Name,
+			By:     aggregation.FunnelKey(f.By),
+			Window: f.Window,
+			Steps:  steps,
+		})
+	}
+	return funnels
+}
+
+// retentionFromConfig returns the retention options, or nil when disabled
+func retentionFromConfig(cfg config.RetentionConfig) *aggregation.RetentionOptions {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &aggregation.RetentionOptions{Days: cfg.Days, Weeks: cfg.Weeks}
+}
+
+// transitionsFromConfig returns the transition options, or nil when disabled
+func transitionsFromConfig(cfg config.TransitionConfig) *aggregation.TransitionOptions {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &aggregation.TransitionOptions{Gap: cfg.Gap}
+}
+
+// anomaliesFromConfig returns the anomaly detection options, or nil when disabled
+func anomaliesFromConfig(cfg config.AnomalyConfig) *aggregation.AnomalyOptions {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &aggregation.AnomalyOptions{
+		Alpha:        cfg.Alpha,
+		Threshold:    cfg.Threshold,
+		Warmup:       cfg.Warmup,
+		MinDeviation: cfg.MinDeviation,
+		History:      cfg.History,
+	}
+}
+
+// rollingFromConfig returns the rolling aggregate options, or nil when disabled
+func rollingFromConfig(cfg config.RollingConfig) *aggregation.RollingOptions {
+	if !cfg.Enabled {
+		return nil
+	}
+	return &aggregation.RollingOptions{
+		Spec:   cfg.Spec,
+		Ranges: cfg.Ranges,
+	}
+}
+
+// alertRulesFromConfig converts the configured alert rules
+func alertRulesFromConfig(cfgRules []config.AlertRuleConfig) []aggregation.AlertRule {
+	rules := make([]aggregation.AlertRule, 0, len(cfgRules))
+	for _, r := range cfgRules {
+		rules = append(rules, aggregation.AlertRule{
+			Name:        r.Name,
+			Spec:        r.Spec,
+			Metric:      r.Metric,
+			Labels:      aggregation.Labels(r.Labels),
+			Op:          aggregation.AlertOp(r.Op),
+			Threshold:   r.Threshold,
+			For:         r.For,
+			Severity:    r.Severity,
+			Description: r.Description,
+		})
+	}
+	return rules
+}
+
+// notifiersFromConfig builds the alert notifiers, logging alerts when none is configured
+func notifiersFromConfig(cfgNotifiers []config.NotifierConfig, logger *zap.Logger) []aggregation.Notifier {
+	if len(cfgNotifiers) == 0 {
+		return []aggregation.Notifier{aggregation.NewLogNotifier(logger)}
+	}
+	notifiers := make([]aggregation.Notifier, 0, len(cfgNotifiers))
+	for _, n := range cfgNotifiers {
+		switch n.Type {
+		case "log":
+			notifiers = append(notifiers, aggregation.NewLogNotifier(logger))
+		case "file":
+			notifiers = append(notifiers, aggregation.NewFileNotifier(n.Path))
+		case "webhook":
+			notifiers = append(notifiers, aggregation.NewWebhookNotifier(n.URL, n.Headers))
+		}
+	}
+	return notifiers
+}
+
+// warnUnknownAlertSpecs flags alert rules whose window spec is not configured
+// (they would never be evaluated)
+func warnUnknownAlertSpecs(rules []config.AlertRuleConfig, specs []aggregation.WindowSpec, logger *zap.Logger) {
+	known := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		if spec.Kind != aggregation.WindowKindSession {
+			known[spec.Name] = true
+		}
+	}
+	for _, rule := range rules {
+		if !known[rule.Spec] {
+			logger.Warn("alert rule targets an unknown window spec and will never fire",
+				zap.String("rule", rule.Name),
+				zap.String("spec", rule.Spec),
+			)
+		}
+	}
+}
+
+// backfillRetention rebuilds the retention cohorts from the events table
+func backfillRetention(store *storage.PostegresStorage, retention *aggregation.Retention, from time.Time, logger *zap.Logger) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	days := 0
+	err := store.LoadUserActivity(ctx, from, func(userID string, day time.Time) {
+		retention.Record(userID, day)
+		days++
+	})
+	if err != nil {
+		logger.Error("failed to backfill retention", zap.Error(err))
+		return
+	}
+	logger.Info("retention backfilled from events",
+		zap.Time("from", from),
+		zap.Int("user_days", days),
+		zap.Int("users", retention.Users()),
+	)
+}
+
+// persistedSpec returns the window spec written to metrics_1m, which must be a
+// configured 1-minute tumbling spec
+func persistedSpec(specs []aggregation.WindowSpec, name string) (aggregation.WindowSpec, error) {
+	for _, spec := range specs {
+		if spec.Name != name {
+			continue
+		}
+		if spec.Kind != aggregation.WindowKindTumbling || spec.Size != time.Minute {
+			return aggregation.WindowSpec{}, fmt.Errorf("spec %s is not a 1-minute tumbling window", name)
+		}
+		return spec, nil
+	}
+	return aggregation.WindowSpec{}, fmt.Errorf("spec %s is not configured", name)
+}
+
+// metricRowsFromWindow converts every series of a closed window into metrics_1m rows,
+// with their mergeable state when the window is rolled up.
+func metricRowsFromWindow(window *aggregation.TimeWindow, withState bool) []storage.MetricRow {
+	return metricRowsFromSnapshot(window.StartTime, window.Metrics, map[string]interface{}{
+		"spec":     window.Spec,
+		"revision": window.Revision,
+	}, withState)
+}
+
+// metricRowsFromSnapshot converts every series of a snapshot into rows starting at start.
+// Gauges and histograms carry min/max; averages, percentiles and top values go to
+// data along with base. With withState, the rows also carry the mergeable state the
+// next rollup level is computed from (see storage.EncodeState).
+func metricRowsFromSnapshot(start time.Time, snapshot *aggregation.MetricsSnapshot, base map[string]interface{}, withState bool) []storage.MetricRow {
+	metrics := snapshot.GetAllMetrics()
+	rows := make([]storage.MetricRow, 0, len(metrics))
+	for _, metric := range metrics {
+		row := storage.MetricRow{
+			Time:   start,
+			Name:   metric.Name,
+			Labels: metric.Labels,
+			Type:   string(metric.Type),
+			Data:   make(map[string]interface{}, len(base)+5),
+		}
+		for k, v := range base {
+			row.Data[k] = v
+		}
+
+		summary := metric.Summary()
+		row.Value = summary.Value
+		row.Count = summary.Count
+		row.Min = summary.Min
+		row.Max = summary.Max
+		if summary.Avg != nil {
+			row.Data["avg"] = *summary.Avg
+			row.Data["last"] = summary.Value
+		}
+		if summary.Percentiles != nil {
+			row.Data["percentiles"] = summary.Percentiles
+		}
+		if summary.Top != nil {
+			row.Data["top"] = summary.Top
+		}
+		if withState {
+			row.State, _ = storage.EncodeState(metric.State())
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// runRollups writes the hours and days whose grace period is over to metrics_1h and metrics_1d.
+// Each period replaces its previous rows, so rolling the same period up again never double-counts.
+func runRollups(ctx context.Context, store *storage.PostegresStorage, rollup *aggregation.Rollup, logger *zap.Logger) {
+	ticker := time.NewTicker(time.Minute)
 	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
-			logger.Info("worker stopping",
-				zap.Int("worker_id", workerID),
-				zap.Int("processed", processed),
+			return
+
+		case now := <-ticker.C:
+			persistRollups(ctx, store, rollup.Flush(now), logger)
+		}
+	}
+}
+
+// persistRollups writes rolled-up periods to their table. Only the levels that feed a
+// coarser one (hours, not days) keep the mergeable state of their series.
+func persistRollups(ctx context.Context, store *storage.PostegresStorage, results []aggregation.RollupResult, logger *zap.Logger) {
+	levels := aggregation.DefaultRollupLevels()
+	coarsest := levels[len(levels)-1].Name
+
+	for _, result := range results {
+		rows := metricRowsFromSnapshot(result.Start, result.Metrics, map[string]interface{}{
+			"level":    result.Level,
+			"children": result.Children,
+		}, result.Level != coarsest)
+		saveCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		err := store.SaveRollup(saveCtx, result.Level, result.Start, rows)
+		cancel()
+		if err != nil {
+			logger.Error("failed to persist rollup",
+				zap.String("level", result.Level),
+				zap.Time("start", result.Start),
+				zap.Error(err),
 			)
+			continue
+		}
+		logger.Info("rollup persisted",
+			zap.String("level", result.Level),
+			zap.Time("start", result.Start),
+			zap.Int("children", result.Children),
+		)
+	}
+}
+
+// checkpointStoreFromConfig returns the configured checkpoint store
+// (postgres requires the storage to be enabled, see config validation)
+func checkpointStoreFromConfig(cfg config.CheckpointConfig, store *storage.PostegresStorage) aggregation.CheckpointStore {
+	if cfg.Backend == "postgres" && store != nil {
+		return store
+	}
+	return aggregation.NewFileCheckpointStore(cfg.Path)
+}
+
+// restoreCheckpoint restores the aggregator from the last checkpoint, if any,
+// and returns it. A checkpoint that cannot be read is logged and the service
+// starts empty.
+func restoreCheckpoint(agg *aggregation.Aggregator, store aggregation.CheckpointStore, logger *zap.Logger) *aggregation.Checkpoint {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	checkpoint, err := agg.RestoreCheckpoint(ctx, store)
+	if err != nil {
+		logger.Error("failed to restore checkpoint, starting empty", zap.Error(err))
+		return nil
+	}
+	if checkpoint == nil {
+		logger.Info("no checkpoint to restore")
+		return nil
+	}
+	logger.Info("checkpoint restored",
+		zap.Int("version", checkpoint.Version),
+		zap.Time("created_at", checkpoint.CreatedAt),
+		zap.Int("metrics", len(checkpoint.Global.Metrics)),
+	)
+	return checkpoint
+}
+
+// runCheckpoints saves the aggregator state every interval
+func runCheckpoints(ctx context.Context, agg *aggregation.Aggregator, store aggregation.CheckpointStore, journal *wal.WAL, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
 			return
 
-		case event := <-eventQueue:
-			// Convertir server.Event en aggregation.Event
-			aggEvent := aggregation.Event{
-				ID:         event.ID,
-				Type:       event.Type,
-				Timestamp:  event.Timestamp,
-				UserID:     event.UserID,
-				SessionID:  event.SessionID,
-				Properties: event.Properties,
-			}
+		case <-ticker.C:
+			saveCheckpoint(ctx, agg, store, journal, logger)
+		}
+	}
+}
+
+// saveCheckpoint captures and saves one checkpoint, logging failures. With a
+// write-ahead log, the checkpoint records the log position it covers and the
+// segments before that position are removed once it is saved.
+func saveCheckpoint(ctx context.Context, agg *aggregation.Aggregator, store aggregation.CheckpointStore, journal *wal.WAL, logger *zap.Logger) {
+	saveCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
 
-			// Traiter l'événement via l'aggregator
+	start := time.Now()
+	if journal == nil {
+		checkpoint, err := agg.SaveCheckpoint(saveCtx, store)
+		if err != nil {
+			logger.Error("failed to save checkpoint", zap.Error(err))
+			return
+		}
+		logger.Debug("checkpoint saved",
+			zap.Time("created_at", checkpoint.CreatedAt),
+			zap.Duration("duration", time.Since(start)),
+		)
+		return
+	}
+
+	// No event is being processed while the state is captured, so the
+	// checkpoint holds exactly the events of the position
+	var checkpoint *aggregation.Checkpoint
+	position := journal.Snapshot(func() {
+		checkpoint = agg.Checkpoint()
+	})
+	checkpoint.Log = &aggregation.LogPosition{Next: position.Next, Done: position.Done}
+
+	data, err := aggregation.EncodeCheckpoint(checkpoint)
+	if err == nil {
+		err = store.SaveCheckpoint(saveCtx, data)
+	}
+	if err != nil {
+		logger.Error("failed to save checkpoint", zap.Error(err))
+		return
+	}
+	if err := journal.Commit(position); err != nil {
+		logger.Error("failed to truncate write-ahead log", zap.Error(err))
+	}
+	logger.Debug("checkpoint saved",
+		zap.Time("created_at", checkpoint.CreatedAt),
+		zap.Uint64("wal_position", position.Next),
+		zap.Duration("duration", time.Since(start)),
+	)
+}
+
+// replayWAL processes the logged events that the restored checkpoint does not
+// cover (without a checkpoint, those not processed before the restart). Their
+// IDs are remembered by seen, so a retry sent after the restart is a duplicate.
+func replayWAL(agg *aggregation.Aggregator, journal *wal.WAL, checkpoint *aggregation.Checkpoint, seen *dedup.Store, logger *zap.Logger) {
+	from := journal.Committed()
+	if checkpoint != nil && checkpoint.Log != nil {
+		from = wal.Position{Next: checkpoint.Log.Next, Done: checkpoint.Log.Done}
+	}
+
+	start := time.Now()
+	invalid := 0
+	replayed, err := journal.Replay(from, func(seq uint64, payload []byte) error {
+		var event server.Event
+		if err := json.Unmarshal(payload, &event); err != nil {
+			invalid++
+			return nil
+		}
+		if seen != nil {
+			seen.Seen(event.ID)
+		}
+		agg.ProcessEvent(toAggregationEvent(event))
+		return nil
+	})
+	if err != nil {
+		logger.Error("failed to replay write-ahead log", zap.Error(err))
+	}
+	logger.Info("write-ahead log replayed",
+		zap.Int("events", replayed-invalid),
+		zap.Int("invalid", invalid),
+		zap.Uint64("from", from.Next),
+		zap.Int("segments", journal.Segments()),
+		zap.Duration("duration", time.Since(start)),
+	)
+}
+
+// runWALCommits removes the processed segments of the write-ahead log every interval
+func runWALCommits(ctx context.Context, journal *wal.WAL, interval time.Duration, logger *zap.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+
+		case <-ticker.C:
+			commitWAL(journal, logger)
+		}
+	}
+}
+
+// commitWAL records the processed position of the write-ahead log, logging failures
+func commitWAL(journal *wal.WAL, logger *zap.Logger) {
+	if err := journal.Commit(journal.Position()); err != nil {
+		logger.Error("failed to truncate write-ahead log", zap.Error(err))
+	}
+}
+
+// restoreRollups re-rolls every period persisted before a restart that has no rolled-up row
+// yet: the minutes after the last hour of metrics_1h and the hours after the last day of
+// metrics_1d. Minutes are reloaded one UTC day at a time, and the hours and days they complete
+// are written right away. Rollups key their inputs by start time, so a reloaded period is
+// replaced, never added twice.
+func restoreRollups(store *storage.PostegresStorage, rollup *aggregation.Rollup, now time.Time, logger *zap.Logger) {
+	const day = 24 * time.Hour
+	ctx := context.Background()
+
+	hourFrom, ok := nextUnrolled(ctx, store, "1h", time.Hour, "1m", logger)
+	if !ok {
+		return
+	}
+	dayFrom, ok := nextUnrolled(ctx, store, "1d", day, "1h", logger)
+	if !ok || dayFrom.After(hourFrom) {
+		dayFrom = hourFrom.Truncate(day)
+	}
+
+	// Hours already rolled up that belong to days not rolled up yet
+	restoreRollupInputs(ctx, store, rollup, "1h", time.Hour, dayFrom, hourFrom, logger)
+
+	for from := hourFrom; from.Before(now); from = from.Truncate(day).Add(day) {
+		to := from.Truncate(day).Add(day)
+		if to.After(now) {
+			to = now
+		}
+		restoreRollupInputs(ctx, store, rollup, "1m", time.Minute, from, to, logger)
+		persistRollups(ctx, store, rollup.Flush(now), logger)
+	}
+}
+
+// nextUnrolled returns the start of the first period of level (of the given size) that has
+// no row yet: the period after its last row or, when the level is empty, the period holding
+// the first row of child. It returns false when neither table has rows.
+func nextUnrolled(ctx context.Context, store *storage.PostegresStorage, level string, size time.Duration, child string, logger *zap.Logger) (time.Time, bool) {
+	queryCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	_, last, ok, err := store.MetricsTimeRange(queryCtx, level)
+	if err != nil {
+		logger.Error("failed to restore rollups", zap.String("level", level), zap.Error(err))
+		return time.Time{}, false
+	}
+	if ok {
+		return last.UTC().Truncate(size).Add(size), true
+	}
+
+	first, _, ok, err := store.MetricsTimeRange(queryCtx, child)
+	if err != nil {
+		logger.Error("failed to restore rollups", zap.String("level", child), zap.Error(err))
+		return time.Time{}, false
+	}
+	return first.UTC().Truncate(size), ok
+}
+
+// restoreRollupInputs adds the periods of level stored in [from, to) to the rollups.
+func restoreRollupInputs(ctx context.Context, store *storage.PostegresStorage, rollup *aggregation.Rollup, level string, size time.Duration, from, to time.Time, logger *zap.Logger) {
+	if !from.Before(to) {
+		return
+	}
+	loadCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	rows, err := store.LoadMetrics(loadCtx, level, from, to)
+	if err != nil {
+		logger.Error("failed to restore rollups", zap.String("level", level), zap.Error(err))
+		return
+	}
+
+	restored := 0
+	snapshots, skipped := snapshotsFromRows(rows)
+	for start, snapshot := range snapshots {
+		if rollup.Add(start, size, snapshot) {
+			restored++
+		}
+	}
+	logger.Info("rollup inputs restored",
+		zap.String("level", level),
+		zap.Time("from", from),
+		zap.Time("to", to),
+		zap.Int("periods", restored),
+		zap.Int("skipped_rows", skipped),
+	)
+}
+
+// snapshotsFromRows rebuilds one snapshot per period from the mergeable state of each row.
+// Rows without state (written before rollups existed, or of the coarsest level) are skipped
+// and counted.
+func snapshotsFromRows(rows []storage.MetricRow) (map[time.Time]*aggregation.MetricsSnapshot, int) {
+	states := make(map[time.Time]*aggregation.SnapshotState)
+	skipped := 0
+	for _, row := range rows {
+		if len(row.State) == 0 {
+			skipped++
+			continue
+		}
+		var metric aggregation.MetricState
+		if err := storage.DecodeState(row.State, &metric); err != nil {
+			skipped++
+			continue
+		}
+
+		start := row.Time.UTC()
+		state, ok := states[start]
+		if !ok {
+			state = &aggregation.SnapshotState{Version: aggregation.SnapshotStateVersion, Timestamp: start}
+			states[start] = state
+		}
+		state.Metrics = append(state.Metrics, metric)
+	}
+
+	snapshots := make(map[time.Time]*aggregation.MetricsSnapshot, len(states))
+	for start, state := range states {
+		snapshot, err := aggregation.NewMetricsSnapshotFromState(*state, aggregation.SnapshotOptions{})
+		if err != nil {
+			skipped += len(state.Metrics)
+			continue
+		}
+		snapshots[start] = snapshot
+	}
+	return snapshots, skipped
+}
+
+// processEvents is a worker function that processes events from the queue.
+// Once ctx is cancelled, it processes the events still queued before returning.
+func processEvents(ctx context.Context, workerID int, eventQueue <-chan server.Event, agg *aggregation.Aggregator, journal *wal.WAL, logger *zap.Logger) {
+	logger.Info("worker started", zap.Int("worker_id", workerID))
+
+	processed := 0
+	ticker := time.NewTicker(10 * time.Second)
+	defer ticker.Stop()
+
+	process := func(event server.Event) {
+		// Convertir server.Event en aggregation.Event
+		aggEvent := toAggregationEvent(event)
+
+		// Traiter l'événement via l'aggregator (puis le marquer traité dans le journal)
+		if journal != nil && event.Seq != 0 {
+			journal.Process(event.Seq, func() {
+				agg.ProcessEvent(aggEvent)
+			})
+		} else {
 			agg.ProcessEvent(aggEvent)
+		}
 
-			processed++
+		processed++
 
-			// Log event details
-			logger.Debug("processing event",
-				zap.Int("worker_id", workerID),
-				zap.String("event_id", event.ID),
-				zap.String("event_type", event.Type),
-				zap.String("user_id", event.UserID),
-				zap.Time("timestamp", event.Timestamp),
-			)
+		// Log event details
+		logger.Debug("processing event",
+			zap.Int("worker_id", workerID),
+			zap.String("event_id", event.ID),
+			zap.String("event_type", event.Type),
+			zap.String("user_id", event.UserID),
+			zap.Time("timestamp", event.Timestamp),
+		)
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			// The HTTP server is shut down first: process what it queued, then stop
+			for {
+				select {
+				case event := <-eventQueue:
+					process(event)
+				default:
+					logger.Info("worker stopping",
+						zap.Int("worker_id", workerID),
+						zap.Int("processed", processed),
+					)
+					return
+				}
+			}
+
+		case event := <-eventQueue:
+			process(event)
 
 		case <-ticker.C:
 			// Periodic stats logging
@@ -227,3 +1030,15 @@ func processEvents(ctx context.Context, workerID int, eventQueue <-chan server.E
 		}
 	}
 }
+
+// toAggregationEvent converts a server event to an aggregation event
+func toAggregationEvent(event server.Event) aggregation.Event {
+	return aggregation.Event{
+		ID:         event.ID,
+		Type:       event.Type,
+		Timestamp:  event.Timestamp,
+		UserID:     event.UserID,
+		SessionID:  event.SessionID,
+		Properties: event.Properties,
+	}
+}
@@ -20,46 +20,338 @@ type Event struct {
 
 // Aggregator agrège les événements en métriques
 type Aggregator struct {
+	// Prochain shard des événements sans clé (accès atomique, en tête pour l'alignement)
+	nextShard uint64
+
 	// Métriques globales (depuis le début)
 	globalMetrics *MetricsSnapshot
 
-	// Fenêtres de temps (1 minute)
-	windowManager *WindowManager
+	// Fenêtres de temps (un gestionnaire par WindowSpec)
+	windowManagers []*WindowManager
+
+	// Accumulateurs locaux des événements, fusionnés au flush et à la lecture.
+	// Chaque shard suit aussi les fenêtres de session de ses clés.
+	shards     []*aggregatorShard
+	shardCount int
 
 	// Configuration
-	windowDuration time.Duration
-	flushInterval  time.Duration
+	windowSpecs   []WindowSpec
+	flushInterval time.Duration
+	clock         Clock
+	metricOpts    SnapshotOptions
+
+	// Règles de métriques évaluées sur les métriques globales et de fenêtre
+	globalRules []MetricRule
+	windowRules []MetricRule
+
+	// Propriété numérique des métriques de montant des fenêtres
+	amountProperty string
+
+	// Spec de session dont les sessions fermées alimentent la métrique
+	// engagement des fenêtres temporelles ("": aucune)
+	engagementSpec string
+
+	// Funnels suivis par utilisateur ou session (parcours en cours par funnel)
+	funnels        []Funnel
+	funnelTrackers []*funnelTracker
+
+	// Cohortes de rétention (nil: désactivé)
+	retention *Retention
+
+	// Dernière page vue par session pour les transitions (nil: désactivé)
+	transitions *transitionTracker
+
+	// Détection d'anomalies sur les fenêtres fermées (nil: désactivé)
+	anomalies *AnomalyDetector
+
+	// Agrégats glissants des métriques globales (nil: désactivé)
+	rolling *Rolling
+
+	// Événements arrivés après la période de retard autorisé (compteur par spec)
+	lateEvents *MetricsSnapshot
+
+	// Séries redirigées vers __other__ (compteur par famille, fenêtres comprises)
+	overflows *MetricsSnapshot
 
 	// Callbacks
 	onWindowClosed func(*TimeWindow)
+	onLateEvent    func(LateEvent)
+	onAnomaly      func(Anomaly)
 
 	// Logger
 	logger *zap.Logger
 
-	// Synchronisation
+	// Synchronisation des métriques globales (les événements ne verrouillent que leur shard)
 	mu sync.RWMutex
 }
 
-// NewAggregator crée un nouvel agrégateur
+// Options regroupe la configuration d'un agrégateur
+type Options struct {
+	// Windows liste les familles de fenêtres à maintenir
+	Windows []WindowSpec
+	// FlushInterval est la période de fermeture des fenêtres expirées
+	FlushInterval time.Duration
+	// AllowedLateness s'applique aux specs qui ne définissent pas leur propre retard autorisé
+	AllowedLateness time.Duration
+	// Clock pilote la fermeture des fenêtres et l'horodatage (nil: horloge murale)
+	Clock Clock
+	// Sets choisit le stockage des métriques uniques (exact, hll ou auto)
+	Sets SetOptions
+	// Rules déclare les métriques dérivées des événements (nil: DefaultMetricRules)
+	Rules []MetricRule
+	// Gauges liste les propriétés numériques mesurées (cart_size...), ajoutées aux règles
+	Gauges []string
+	// AmountProperty est la propriété numérique sommée et mesurée par les
+	// métriques de fenêtre sum, avg, p95 et p99 ("": DefaultAmountProperty)
+	AmountProperty string
+	// Cardinality borne le nombre de séries labellisées par famille (zéro: illimité)
+	Cardinality CardinalityLimits
+	// TopKCapacity est le nombre de compteurs des métriques topk (0: DefaultTopKCapacity)
+	TopKCapacity int
+	// Shards est le nombre d'accumulateurs indépendants, typiquement le nombre
+	// de workers (0: runtime.GOMAXPROCS). 1 sérialise tous les événements.
+	Shards int
+	// Funnels déclare les funnels de conversion suivis globalement et par fenêtre
+	Funnels []Funnel
+	// Retention active les cohortes de rétention par jour et semaine (nil: désactivé)
+	Retention *RetentionOptions
+	// Transitions active le comptage des transitions entre pages d'une session,
+	// globalement et par fenêtre (nil: désactivé)
+	Transitions *TransitionOptions
+	// Anomalies active la détection d'anomalies (EWMA, z-score) sur les
+	// fenêtres fermées (nil: désactivé)
+	Anomalies *AnomalyOptions
+	// Rolling active les agrégats glissants (dernière heure, 24 heures) des
+	// métriques globales (nil: désactivé)
+	Rolling *RollingOptions
+}
+
+// NewAggregator crée un nouvel agrégateur avec une seule fenêtre tumbling
 func NewAggregator(windowDuration, flushInterval time.Duration, logger *zap.Logger) *Aggregator {
-	return &Aggregator{
-		globalMetrics:  NewMetricsSnapshot(),
-		windowManager:  NewWindowManager(windowDuration),
-		windowDuration: windowDuration,
-		flushInterval:  flushInterval,
+	return NewAggregatorWithOptions(Options{
+		Windows:       []WindowSpec{{Kind: WindowKindTumbling, Size: windowDuration}},
+		FlushInterval: flushInterval,
+	}, logger)
+}
+
+// NewAggregatorWithOptions crée un agrégateur à partir d'options complètes
+func NewAggregatorWithOptions(opts Options, logger *zap.Logger) *Aggregator {
+	specs := make([]WindowSpec, 0, len(opts.Windows))
+	for _, spec := range opts.Windows {
+		spec = spec.normalize()
+		if spec.AllowedLateness == 0 {
+			spec.AllowedLateness = opts.AllowedLateness
+		}
+		if spec.Kind == WindowKindSession {
+			specs = append(specs, spec)
+			continue
+		}
+		for _, metric := range spec.Metrics {
+			if !IsKnownWindowMetric(metric) {
+				logger.Warn("unknown window metric ignored",
+					zap.String("spec", spec.Name),
+					zap.String("metric", metric),
+				)
+			}
+		}
+		specs = append(specs, spec)
+	}
+
+	if opts.AmountProperty == "" {
+		opts.AmountProperty = DefaultAmountProperty
+	}
+
+	rules := opts.Rules
+	if rules == nil {
+		rules = DefaultMetricRules()
+	}
+	rules = append(rules[:len(rules):len(rules)], GaugeRules(opts.Gauges)...)
+	var globalRules, windowRules []MetricRule
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			logger.Warn("invalid metric rule ignored", zap.Error(err))
+			continue
+		}
+		if rule.appliesTo(RuleScopeGlobal) {
+			globalRules = append(globalRules, rule)
+		}
+		if rule.appliesTo(RuleScopeWindow) {
+			windowRules = append(windowRules, rule)
+		}
+	}
+
+	funnels := make([]Funnel, 0, len(opts.Funnels))
+	funnelNames := make(map[string]bool, len(opts.Funnels))
+	for _, funnel := range opts.Funnels {
+		if err := funnel.Validate(); err != nil {
+			logger.Warn("invalid funnel ignored", zap.Error(err))
+			continue
+		}
+		if funnelNames[funnel.Name] {
+			logger.Warn("duplicate funnel ignored", zap.String("funnel", funnel.Name))
+			continue
+		}
+		if funnel.By == "" {
+			funnel.By = FunnelByUser
+		}
+		funnelNames[funnel.Name] = true
+		funnels = append(funnels, funnel)
+	}
+
+	clock := orSystemClock(opts.Clock)
+
+	a := &Aggregator{
+		lateEvents:     NewMetricsSnapshotWithOptions(SnapshotOptions{Clock: clock}),
+		overflows:      NewMetricsSnapshotWithOptions(SnapshotOptions{Clock: clock}),
+		windowSpecs:    specs,
+		flushInterval:  opts.FlushInterval,
+		clock:          clock,
+		globalRules:    globalRules,
+		windowRules:    windowRules,
+		amountProperty: opts.AmountProperty,
+		funnels:        funnels,
+		shardCount:     opts.Shards,
 		logger:         logger,
 	}
+	a.metricOpts = SnapshotOptions{
+		Clock:        clock,
+		Sets:         opts.Sets,
+		Cardinality:  opts.Cardinality,
+		TopKCapacity: opts.TopKCapacity,
+		OnOverflow:   a.recordOverflow,
+	}
+	a.globalMetrics = NewMetricsSnapshotWithOptions(a.metricOpts)
+	a.windowManagers = newWindowManagers(specs, a.metricOpts)
+	a.shards = newAggregatorShards(a.shardCount, specs, a.metricOpts, a.globalMetrics)
+	a.funnelTrackers = newFunnelTrackers(funnels)
+	a.engagementSpec = engagementSpec(specs, logger)
+	if opts.Retention != nil {
+		retention := *opts.Retention
+		if retention.Clock == nil {
+			retention.Clock = clock
+		}
+		a.retention = NewRetention(retention)
+	}
+	if opts.Transitions != nil {
+		a.transitions = newTransitionTracker(*opts.Transitions)
+	}
+	if opts.Anomalies != nil {
+		a.anomalies = NewAnomalyDetector(*opts.Anomalies, clock)
+	}
+	if opts.Rolling != nil {
+		if spec, ok := rollingSpec(specs, opts.Rolling.Spec); ok {
+			a.rolling = newRolling(spec, opts.Rolling.Ranges, a.metricOpts)
+		} else {
+			logger.Warn("rolling metrics disabled: no matching tumbling window spec",
+				zap.String("spec", opts.Rolling.Spec),
+			)
+		}
+	}
+	return a
+}
+
+// engagementSpec retourne la première spec de session, dont les sessions
+// fermées alimentent la métrique engagement; "" si aucune spec temporelle ne
+// la demande, ou sans spec de session (avertissement)
+func engagementSpec(specs []WindowSpec, logger *zap.Logger) string {
+	requested := false
+	for _, spec := range specs {
+		requested = requested || (spec.Kind != WindowKindSession && spec.HasMetric(WindowMetricEngagement))
+	}
+	if !requested {
+		return ""
+	}
+	for _, spec := range specs {
+		if spec.Kind == WindowKindSession {
+			return spec.Name
+		}
+	}
+	logger.Warn("engagement metrics disabled: no session window spec")
+	return ""
+}
+
+// rollingSpec retourne la spec tumbling name, ou la plus petite si name est vide
+func rollingSpec(specs []WindowSpec, name string) (WindowSpec, bool) {
+	var found WindowSpec
+	ok := false
+	for _, spec := range specs {
+		if spec.Kind != WindowKindTumbling {
+			continue
+		}
+		if name != "" {
+			if spec.Name == name {
+				return spec, true
+			}
+			continue
+		}
+		if !ok || spec.Size < found.Size {
+			found, ok = spec, true
+		}
+	}
+	return found, ok
+}
+
+// newFunnelTrackers crée le suivi des parcours de chaque funnel
+func newFunnelTrackers(funnels []Funnel) []*funnelTracker {
+	trackers := make([]*funnelTracker, 0, len(funnels))
+	for _, funnel := range funnels {
+		trackers = append(trackers, newFunnelTracker(funnel))
+	}
+	return trackers
+}
+
+// newWindowManagers crée un gestionnaire de fenêtres par spec temporelle
+func newWindowManagers(specs []WindowSpec, opts SnapshotOptions) []*WindowManager {
+	managers := make([]*WindowManager, 0, len(specs))
+	for _, spec := range specs {
+		if spec.Kind == WindowKindSession {
+			continue
+		}
+		managers = append(managers, newWindowManager(spec, opts))
+	}
+	return managers
+}
+
+// newSessionManagers crée un gestionnaire de sessions par spec de session
+func newSessionManagers(specs []WindowSpec, opts SnapshotOptions) []*SessionManager {
+	managers := make([]*SessionManager, 0)
+	for _, spec := range specs {
+		if spec.Kind == WindowKindSession {
+			managers = append(managers, newSessionManager(spec, opts))
+		}
+	}
+	return managers
 }
 
 // SetWindowClosedCallback définit un callback appelé quand une fenêtre se ferme
+// Les sessions expirées pouvant être émises depuis ProcessEvent, le callback
+// doit supporter des appels concurrents. Il n'est jamais appelé sous le verrou
+// d'un shard et peut donc interroger l'agrégateur.
+// Une fenêtre amendée par des événements en retard est réémise avec Revision > 0
 func (a *Aggregator) SetWindowClosedCallback(callback func(*TimeWindow)) {
 	a.onWindowClosed = callback
 }
 
+// SetLateEventCallback définit la sortie annexe des événements trop en retard
+func (a *Aggregator) SetLateEventCallback(callback func(LateEvent)) {
+	a.onLateEvent = callback
+}
+
+// SetAnomalyCallback définit un callback appelé pour chaque anomalie détectée
+// à la fermeture d'une fenêtre (mêmes garanties que SetWindowClosedCallback)
+func (a *Aggregator) SetAnomalyCallback(callback func(Anomaly)) {
+	a.onAnomaly = callback
+}
+
 // Start démarre l'agrégateur
 func (a *Aggregator) Start(ctx context.Context) {
+	specNames := make([]string, 0, len(a.windowSpecs))
+	for _, spec := range a.windowSpecs {
+		specNames = append(specNames, spec.Name)
+	}
+
 	a.logger.Info("aggregator started",
-		zap.Duration("window_duration", a.windowDuration),
+		zap.Strings("windows", specNames),
 		zap.Duration("flush_interval", a.flushInterval),
 	)
 
@@ -73,167 +365,413 @@ func (a *Aggregator) Start(ctx context.Context) {
 			return
 
 		case <-ticker.C:
-			a.flushExpiredWindows()
-			a.cleanup()
+			a.Flush()
 		}
 	}
 }
 
-// ProcessEvent traite un événement et met à jour les métriques
+// Flush ferme les fenêtres expirées selon l'horloge de l'agrégateur puis nettoie
+// les anciennes fenêtres. Appelé par Start à chaque tick; un rejeu piloté par une
+// ManualClock l'appelle directement après avoir avancé l'horloge.
+func (a *Aggregator) Flush() {
+	a.flushExpiredWindows()
+	a.cleanup()
+}
+
+// ProcessEvent traite un événement et met à jour les métriques. Seul le shard
+// de l'événement est verrouillé: des workers concurrents ne se bloquent que
+// s'ils tombent sur le même shard.
 func (a *Aggregator) ProcessEvent(event Event) {
-	now := time.Now()
+	now := a.clock.Now()
+
+	var late []lateDrop
+	var expired []*TimeWindow
+
+	shard := a.shardFor(event)
+	shard.mu.Lock()
+
+	// Faire avancer les parcours des funnels
+	var progress []funnelProgress
+	for _, tracker := range a.funnelTrackers {
+		if step, ok := tracker.track(event); ok {
+			progress = append(progress, step)
+		}
+	}
+
+	// Transition depuis la page précédente de la session
+	var from, to string
+	transition := false
+	if a.transitions != nil {
+		from, to, transition = a.transitions.track(event)
+	}
 
 	// Mettre à jour métriques globales
-	a.updateGlobalMetrics(event)
+	a.updateGlobalMetrics(shard.global, event)
+	for _, step := range progress {
+		step.record(shard.global)
+	}
+	if transition {
+		recordTransition(shard.global, from, to)
+	}
+
+	// Et celles de la période de l'événement dans l'anneau glissant
+	if a.rolling != nil {
+		delta := shard.rollingDelta(a.rolling.period(event.Timestamp))
+		a.updateGlobalMetrics(delta, event)
+		for _, step := range progress {
+			step.record(delta)
+		}
+		if transition {
+			recordTransition(delta, from, to)
+		}
+	}
+
+	// Mettre à jour les fenêtres de chaque spec
+	// (en sliding, un événement appartient à plusieurs fenêtres)
+	for _, wm := range a.windowManagers {
+		spec := wm.Spec()
+		windows, dropped := wm.AcceptEvent(event.Timestamp)
+		for _, window := range windows {
+			delta := shard.windowDelta(wm, window)
+			a.updateWindowMetrics(delta.metrics, spec, event)
+			for _, step := range progress {
+				step.record(delta.metrics)
+			}
+			if transition {
+				recordTransition(delta.metrics, from, to)
+			}
+		}
+		if dropped > 0 {
+			late = append(late, lateDrop{manager: wm, dropped: dropped})
+		}
+	}
+
+	// Mettre à jour les sessions
+	if key := SessionKey(event); key != "" {
+		for _, sm := range shard.sessions {
+			if _, closed := sm.Track(key, event); closed != nil {
+				expired = append(expired, closed)
+			}
+		}
+	}
 
-	// Mettre à jour fenêtre de temps
-	window := a.windowManager.GetOrCreateWindow(event.Timestamp)
-	a.updateWindowMetrics(window, event)
+	shard.mu.Unlock()
+
+	// La rétention a ses propres verrous par utilisateur
+	if a.retention != nil {
+		a.retention.Track(event)
+	}
+
+	// Les callbacks s'exécutent hors du verrou du shard
+	for _, drop := range late {
+		a.recordLateEvent(drop.manager, event, drop.dropped)
+	}
+	for _, window := range expired {
+		a.emitClosedWindow(window)
+	}
 
 	a.logger.Debug("event processed",
 		zap.String("event_type", event.Type),
 		zap.String("user_id", event.UserID),
-		zap.Duration("processing_time", time.Since(now)),
+		zap.Duration("processing_time", a.clock.Now().Sub(now)),
 	)
 }
 
-// updateGlobalMetrics met à jour les métriques globales
-func (a *Aggregator) updateGlobalMetrics(event Event) {
-	a.mu.Lock()
-	defer a.mu.Unlock()
+// lateDrop retient les fenêtres refusées d'un événement jusqu'à la libération du shard
+type lateDrop struct {
+	manager *WindowManager
+	dropped int
+}
 
+// updateGlobalMetrics met à jour les métriques globales (le delta d'un shard)
+func (a *Aggregator) updateGlobalMetrics(metrics *MetricsSnapshot, event Event) {
 	// Compteur total d'événements
-	totalEvents := a.globalMetrics.GetMetric("total_events", MetricTypeCounter)
+	totalEvents := metrics.GetMetric("total_events", MetricTypeCounter)
 	totalEvents.Increment()
 
 	// Compteur par type d'événement
-	eventTypeKey := "events_by_type:" + event.Type
-	eventTypeMetric := a.globalMetrics.GetMetric(eventTypeKey, MetricTypeCounter)
+	eventTypeMetric := metrics.GetMetricWithLabels("events_by_type", Labels{"type": event.Type}, MetricTypeCounter)
 	eventTypeMetric.Increment()
 
 	// Utilisateurs uniques
-	uniqueUsers := a.globalMetrics.GetMetric("unique_users", MetricTypeSet)
+	uniqueUsers := metrics.GetMetric("unique_users", MetricTypeSet)
 	if event.UserID != "" {
 		uniqueUsers.AddUnique(event.UserID)
 	}
 
 	// Sessions uniques
-	uniqueSessions := a.globalMetrics.GetMetric("unique_sessions", MetricTypeSet)
+	uniqueSessions := metrics.GetMetric("unique_sessions", MetricTypeSet)
 	if event.SessionID != "" {
 		uniqueSessions.AddUnique(event.SessionID)
 	}
 
-	// Métriques spécifiques par type d'événement
-	switch event.Type {
-	case "pageview":
-		a.processPageviewMetrics(event)
-	case "click":
-		a.processClickMetrics(event)
-	case "purchase":
-		a.processPurchaseMetrics(event)
+	// Métriques déclarées par les règles
+	for _, rule := range a.globalRules {
+		rule.Apply(metrics, event)
 	}
 }
 
-// processPageviewMetrics traite les métriques de pageview
-func (a *Aggregator) processPageviewMetrics(event Event) {
-	// Compteur de pageviews
-	pageviews := a.globalMetrics.GetMetric("pageviews", MetricTypeCounter)
-	pageviews.Increment()
+// updateWindowMetrics met à jour les métriques d'une fenêtre (le delta d'un shard) selon sa spec
+func (a *Aggregator) updateWindowMetrics(metrics *MetricsSnapshot, spec WindowSpec, event Event) {
+	// Événements dans cette fenêtre (aussi nécessaire pour le débit)
+	if spec.HasMetric(WindowMetricCount) || spec.HasMetric(WindowMetricRate) {
+		windowEvents := metrics.GetMetric("events", MetricTypeCounter)
+		windowEvents.Increment()
+	}
 
-	// Pages uniques visitées
-	if page, ok := event.Properties["page"].(string); ok {
-		uniquePages := a.globalMetrics.GetMetric("unique_pages", MetricTypeSet)
-		uniquePages.AddUnique(page)
+	// Par type
+	if spec.HasMetric(WindowMetricCount) {
+		eventTypeMetric := metrics.GetMetricWithLabels("events", Labels{"type": event.Type}, MetricTypeCounter)
+		eventTypeMetric.Increment()
+	}
 
-		// Compteur par page
-		pageKey := "page_views:" + page
-		pageMetric := a.globalMetrics.GetMetric(pageKey, MetricTypeCounter)
-		pageMetric.Increment()
+	// Utilisateurs actifs dans la fenêtre
+	if spec.HasMetric(WindowMetricUnique) && event.UserID != "" {
+		activeUsers := metrics.GetMetric("active_users", MetricTypeSet)
+		activeUsers.AddUnique(event.UserID)
 	}
-}
 
-// processClickMetrics traite les métriques de click
-func (a *Aggregator) processClickMetrics(event Event) {
-	// Compteur de clicks
-	clicks := a.globalMetrics.GetMetric("clicks", MetricTypeCounter)
-	clicks.Increment()
+	// Métriques déclarées par les règles
+	for _, rule := range a.windowRules {
+		rule.Apply(metrics, event)
+	}
 
-	// Clicks par élément
-	if element, ok := event.Properties["element"].(string); ok {
-		elementKey := "clicks:" + element
-		elementMetric := a.globalMetrics.GetMetric(elementKey, MetricTypeCounter)
-		elementMetric.Increment()
+	// Montants (somme, moyenne, percentiles)
+	amount, ok := eventNumber(event, a.amountProperty)
+	if !ok {
+		return
+	}
+	if spec.HasMetric(WindowMetricSum) || spec.HasMetric(WindowMetricAvg) {
+		amountMetric := metrics.GetMetric("amount", MetricTypeCounter)
+		amountMetric.IncrementBy(amount)
+	}
+	if spec.HasMetric(WindowMetricP95) || spec.HasMetric(WindowMetricP99) {
+		amountHist := metrics.GetMetric("amount_histogram", MetricTypeHistogram)
+		amountHist.Observe(amount)
 	}
 }
 
-// processPurchaseMetrics traite les métriques d'achat
-func (a *Aggregator) processPurchaseMetrics(event Event) {
-	// Compteur d'achats
-	purchases := a.globalMetrics.GetMetric("purchases", MetricTypeCounter)
-	purchases.Increment()
-
-	// Revenue total
-	if amount, ok := event.Properties["amount"].(float64); ok {
-		revenue := a.globalMetrics.GetMetric("revenue", MetricTypeCounter)
-		revenue.IncrementBy(amount)
+// finalizeWindow calcule les métriques dérivées d'une fenêtre qui se ferme
+func (a *Aggregator) finalizeWindow(window *TimeWindow, wm *WindowManager) {
+	spec := wm.Spec()
+	deriveWindowMetrics(window.Metrics, spec, window.Duration)
 
-		// Histogramme des montants
-		revenueHist := a.globalMetrics.GetMetric("revenue_histogram", MetricTypeHistogram)
-		revenueHist.Observe(amount)
+	if spec.HasMetric(WindowMetricEngagement) {
+		deriveEngagementRates(window.Metrics)
 	}
+
+	finalizeFunnels(window, a.funnels)
 }
 
-// updateWindowMetrics met à jour les métriques d'une fenêtre
-func (a *Aggregator) updateWindowMetrics(window *TimeWindow, event Event) {
-	// Événements dans cette fenêtre
-	windowEvents := window.Metrics.GetMetric("events", MetricTypeCounter)
-	windowEvents.Increment()
+// deriveWindowMetrics calcule les gauges dérivées des montants et le débit
+// d'un snapshot couvrant duration
+func deriveWindowMetrics(snapshot *MetricsSnapshot, spec WindowSpec, duration time.Duration) {
+	metrics := snapshot.GetAllMetrics()
 
-	// Par type
-	eventTypeKey := "events:" + event.Type
-	eventTypeMetric := window.Metrics.GetMetric(eventTypeKey, MetricTypeCounter)
-	eventTypeMetric.Increment()
+	if amountMetric, ok := metrics["amount"]; ok && spec.HasMetric(WindowMetricAvg) {
+		snapshot.GetMetric("amount_avg", MetricTypeGauge).Set(amountMetric.Average())
+	}
 
-	// Utilisateurs actifs dans la fenêtre
-	activeUsers := window.Metrics.GetMetric("active_users", MetricTypeSet)
-	if event.UserID != "" {
-		activeUsers.AddUnique(event.UserID)
+	if amountHist, ok := metrics["amount_histogram"]; ok {
+		if spec.HasMetric(WindowMetricP95) {
+			snapshot.GetMetric("amount_p95", MetricTypeGauge).Set(amountHist.Quantile(0.95))
+		}
+		if spec.HasMetric(WindowMetricP99) {
+			snapshot.GetMetric("amount_p99", MetricTypeGauge).Set(amountHist.Quantile(0.99))
+		}
+	}
+
+	if spec.HasMetric(WindowMetricRate) && duration > 0 {
+		events, _ := snapshot.GetMetricValue("events")
+		snapshot.GetMetric("events_rate", MetricTypeGauge).Set(events / duration.Seconds())
 	}
 }
 
-// flushExpiredWindows ferme et traite les fenêtres expirées
+// flushExpiredWindows ferme les sessions expirées, fusionne les shards puis
+// ferme et traite les fenêtres expirées. Les sessions sont fermées d'abord:
+// leur engagement rejoint la fenêtre où elles se terminent avant sa fermeture.
 func (a *Aggregator) flushExpiredWindows() {
-	now := time.Now()
-	closedWindows := a.windowManager.CloseExpiredWindows(now)
+	now := a.clock.Now()
+
+	for _, sm := range a.sessionManagers() {
+		closedSessions := sm.CloseExpiredSessions(now)
+		if len(closedSessions) == 0 {
+			continue
+		}
+
+		a.logger.Info("flushing expired sessions",
+			zap.String("spec", sm.Spec().Name),
+			zap.Int("count", len(closedSessions)),
+		)
+
+		for _, window := range closedSessions {
+			a.emitClosedWindow(window)
+		}
+	}
+
+	closed := make([][]*TimeWindow, len(a.windowManagers))
+	a.drain(func() {
+		for i, wm := range a.windowManagers {
+			closed[i] = wm.CloseExpiredWindows(now)
+		}
+	})
+
+	for i, wm := range a.windowManagers {
+		closedWindows := closed[i]
+		if len(closedWindows) == 0 {
+			continue
+		}
 
-	if len(closedWindows) > 0 {
 		a.logger.Info("flushing expired windows",
+			zap.String("spec", wm.Spec().Name),
 			zap.Int("count", len(closedWindows)),
 		)
 
 		for _, window := range closedWindows {
-			a.logger.Debug("window closed",
+			a.finalizeWindow(window, wm)
+			a.emitClosedWindow(window)
+		}
+	}
+
+	// Réémettre les fenêtres amendées par des événements en retard
+	for _, wm := range a.windowManagers {
+		for _, window := range wm.TakeAmendedWindows() {
+			a.logger.Debug("re-emitting amended window",
+				zap.String("spec", window.Spec),
 				zap.Time("start", window.StartTime),
-				zap.Time("end", window.EndTime),
-				zap.Int("metrics_count", len(window.Metrics.Metrics)),
+				zap.Int("revision", window.Revision),
 			)
+			a.finalizeWindow(window, wm)
+			a.emitClosedWindow(window)
+		}
+	}
+}
 
-			// Appeler callback si défini
-			if a.onWindowClosed != nil {
-				a.onWindowClosed(window)
+// emitClosedWindow transmet une fenêtre fermée (temporelle ou de session) au callback
+func (a *Aggregator) emitClosedWindow(window *TimeWindow) {
+	if window.session != nil && window.Spec == a.engagementSpec {
+		a.recordEngagement(window)
+	}
+
+	a.logger.Debug("window closed",
+		zap.String("spec", window.Spec),
+		zap.String("key", window.Key),
+		zap.Time("start", window.StartTime),
+		zap.Time("end", window.EndTime),
+		zap.Int("metrics_count", len(window.Metrics.GetAllMetrics())),
+	)
+
+	// Appeler callback si défini
+	if a.onWindowClosed != nil {
+		a.onWindowClosed(window)
+	}
+
+	if a.anomalies != nil {
+		for _, anomaly := range a.anomalies.Observe(window, a.WindowStride(window.Spec)) {
+			a.logger.Warn("anomaly detected",
+				zap.String("spec", anomaly.Spec),
+				zap.String("series", anomaly.Series),
+				zap.String("direction", string(anomaly.Direction)),
+				zap.Time("window_start", anomaly.WindowStart),
+				zap.Float64("value", anomaly.Value),
+				zap.Float64("expected", anomaly.Expected),
+				zap.Float64("zscore", anomaly.ZScore),
+			)
+			if a.onAnomaly != nil {
+				a.onAnomaly(anomaly)
 			}
 		}
 	}
 }
 
+// recordEngagement ajoute une session fermée à l'engagement des fenêtres
+// temporelles qui le demandent. La session se termine à l'expiration de son
+// inactivité (dernier événement + gap): elle est comptée une fois, dans la
+// fenêtre qui contient cet instant (une fenêtre fermée mais encore amendable
+// est réémise; au-delà du retard autorisé, la session est ignorée).
+func (a *Aggregator) recordEngagement(session *TimeWindow) {
+	var gap time.Duration
+	for _, spec := range a.windowSpecs {
+		if spec.Name == session.Spec {
+			gap = spec.Gap
+		}
+	}
+	end := session.EndTime.Add(gap)
+	for _, wm := range a.windowManagers {
+		if !wm.Spec().HasMetric(WindowMetricEngagement) {
+			continue
+		}
+		wm.AddEvent(end, func(window *TimeWindow) {
+			addSessionEngagement(window.Metrics, session.session)
+		})
+	}
+}
+
+// recordLateEvent compte un événement trop en retard et l'envoie à la sortie annexe
+func (a *Aggregator) recordLateEvent(wm *WindowManager, event Event, dropped int) {
+	spec := wm.Spec().Name
+	a.lateEvents.GetMetric(spec, MetricTypeCounter).Increment()
+
+	late := LateEvent{
+		Event:          event,
+		Spec:           spec,
+		Watermark:      wm.Watermark(),
+		DroppedWindows: dropped,
+	}
+
+	a.logger.Debug("late event dropped",
+		zap.String("spec", spec),
+		zap.String("event_id", event.ID),
+		zap.Time("event_time", event.Timestamp),
+		zap.Time("watermark", late.Watermark),
+	)
+
+	if a.onLateEvent != nil {
+		a.onLateEvent(late)
+	}
+}
+
+// recordOverflow compte une série redirigée vers la série __other__
+// d'une famille saturée et avertit à la première saturation
+func (a *Aggregator) recordOverflow(family string) {
+	counter := a.overflows.GetMetric(family, MetricTypeCounter)
+	counter.Increment()
+
+	counter.mu.RLock()
+	first := counter.Count == 1
+	counter.mu.RUnlock()
+	if first {
+		a.logger.Warn("metric family reached its cardinality limit, new label values folded into __other__",
+			zap.String("family", family),
+			zap.Int("limit", a.metricOpts.Cardinality.Limit(family)),
+		)
+	}
+}
+
 // cleanup nettoie les anciennes fenêtres
 func (a *Aggregator) cleanup() {
 	// Garder les fenêtres fermées pendant 5 minutes
-	a.windowManager.Cleanup(5 * time.Minute)
+	for _, wm := range a.windowManagers {
+		wm.Cleanup(5 * time.Minute)
+	}
+
+	// Abandonner les parcours de funnel sortis de leur fenêtre de conversion
+	now := a.clock.Now()
+	for _, tracker := range a.funnelTrackers {
+		tracker.expire(now)
+	}
+	if a.transitions != nil {
+		a.transitions.expire(now)
+	}
+	// Oublier les utilisateurs sortis de l'horizon de rétention
+	if a.retention != nil {
+		a.retention.expire(now)
+	}
 }
 
 // GetGlobalMetrics retourne les métriques globales
 func (a *Aggregator) GetGlobalMetrics() map[string]*Metric {
+	a.drain(nil)
 	a.mu.RLock()
 	defer a.mu.RUnlock()
 
@@ -242,19 +780,238 @@ func (a *Aggregator) GetGlobalMetrics() map[string]*Metric {
 
 // GetGlobalMetricValue retourne la valeur d'une métrique globale
 func (a *Aggregator) GetGlobalMetricValue(name string) (float64, bool) {
+	a.drain(nil)
 	a.mu.RLock()
 	defer a.mu.RUnlock()
 
 	return a.globalMetrics.GetMetricValue(name)
 }
 
-// GetActiveWindows retourne les fenêtres actives
+// QueryGlobalMetrics retourne les séries globales de name filtrées par labels
+func (a *Aggregator) QueryGlobalMetrics(name string, match Labels) []*Metric {
+	a.drain(nil)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.globalMetrics.Query(name, match)
+}
+
+// GroupGlobalMetrics regroupe les séries globales de name par les labels by
+func (a *Aggregator) GroupGlobalMetrics(name string, by []string, match Labels) []MetricGroup {
+	a.drain(nil)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return a.globalMetrics.GroupBy(name, by, match)
+}
+
+// GlobalTopK retourne les k valeurs les plus fréquentes de la métrique topk
+// globale name (séries filtrées par match puis fusionnées)
+func (a *Aggregator) GlobalTopK(name string, match Labels, k int) []TopKEntry {
+	a.drain(nil)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return mergeTopK(a.globalMetrics.Query(name, match), k)
+}
+
+// WindowTopK retourne les k valeurs les plus fréquentes de la métrique topk
+// name dans la dernière fenêtre de la spec (voir WindowManager.LatestWindow).
+// La fenêtre est nil si la spec est inconnue ou n'a encore aucune fenêtre.
+func (a *Aggregator) WindowTopK(spec, name string, match Labels, k int) (*TimeWindow, []TopKEntry) {
+	a.drain(nil)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for _, wm := range a.windowManagers {
+		if wm.Spec().Name != spec {
+			continue
+		}
+		window := wm.LatestWindow()
+		if window == nil {
+			return nil, nil
+		}
+		return window, mergeTopK(window.Metrics.Query(name, match), k)
+	}
+	return nil, nil
+}
+
+// mergeTopK fusionne les séries topk et retourne leurs k valeurs les plus fréquentes
+func mergeTopK(series []*Metric, k int) []TopKEntry {
+	if len(series) == 1 {
+		return series[0].Top(k)
+	}
+	merged := NewMetric("", MetricTypeTopK)
+	for _, metric := range series {
+		if metric.Type == MetricTypeTopK {
+			merged.Merge(metric)
+		}
+	}
+	return merged.Top(k)
+}
+
+// GetFunnels retourne les funnels configurés
+func (a *Aggregator) GetFunnels() []Funnel {
+	funnels := make([]Funnel, len(a.funnels))
+	copy(funnels, a.funnels)
+	return funnels
+}
+
+// GlobalFunnels retourne les comptes et conversions de chaque funnel depuis le début
+func (a *Aggregator) GlobalFunnels() []FunnelResult {
+	a.drain(nil)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	results := make([]FunnelResult, 0, len(a.funnels))
+	for _, funnel := range a.funnels {
+		results = append(results, funnelResult(a.globalMetrics, funnel))
+	}
+	return results
+}
+
+// WindowFunnels retourne les résultats des funnels dans la dernière fenêtre de
+// la spec (voir WindowManager.LatestWindow). Un parcours est compté dans la
+// fenêtre de chacune de ses étapes: les conversions d'une fenêtre rapportent
+// les étapes franchies pendant la fenêtre aux étapes précédentes de la même
+// fenêtre. La fenêtre est nil si la spec est inconnue ou n'a aucune fenêtre.
+func (a *Aggregator) WindowFunnels(spec string) (*TimeWindow, []FunnelResult) {
+	a.drain(nil)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for _, wm := range a.windowManagers {
+		if wm.Spec().Name != spec {
+			continue
+		}
+		window := wm.LatestWindow()
+		if window == nil {
+			return nil, nil
+		}
+		results := make([]FunnelResult, 0, len(a.funnels))
+		for _, funnel := range a.funnels {
+			results = append(results, funnelResult(window.Metrics, funnel))
+		}
+		return window, results
+	}
+	return nil, nil
+}
+
+// GlobalTransitions retourne les transitions entre pages depuis le début,
+// filtrées par labels from et/ou to, triées par compte décroissant.
+// Retourne nil si le suivi des transitions est désactivé.
+func (a *Aggregator) GlobalTransitions(match Labels) []PageTransition {
+	if a.transitions == nil {
+		return nil
+	}
+	a.drain(nil)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	return pageTransitions(a.globalMetrics.Query(TransitionMetric, match))
+}
+
+// WindowTransitions retourne les transitions de la dernière fenêtre de la
+// spec (voir WindowManager.LatestWindow). La fenêtre est nil si le suivi est
+// désactivé, si la spec est inconnue ou n'a encore aucune fenêtre.
+func (a *Aggregator) WindowTransitions(spec string, match Labels) (*TimeWindow, []PageTransition) {
+	if a.transitions == nil {
+		return nil, nil
+	}
+	a.drain(nil)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	for _, wm := range a.windowManagers {
+		if wm.Spec().Name != spec {
+			continue
+		}
+		window := wm.LatestWindow()
+		if window == nil {
+			return nil, nil
+		}
+		return window, pageTransitions(window.Metrics.Query(TransitionMetric, match))
+	}
+	return nil, nil
+}
+
+// TransitionsEnabled indique si les transitions entre pages sont suivies
+func (a *Aggregator) TransitionsEnabled() bool {
+	return a.transitions != nil
+}
+
+// Anomalies retourne les anomalies détectées correspondant au filtre, les
+// plus récentes en premier (nil si la détection est désactivée)
+func (a *Aggregator) Anomalies(filter AnomalyFilter) []Anomaly {
+	if a.anomalies == nil {
+		return nil
+	}
+	return a.anomalies.Anomalies(filter)
+}
+
+// RollingMetrics retourne l'agrégat des métriques globales de la durée
+// glissante rng; false si les agrégats glissants sont désactivés ou si rng
+// n'est pas une durée servie (voir RollingRanges)
+func (a *Aggregator) RollingMetrics(rng time.Duration) (*RollingMetrics, bool) {
+	if a.rolling == nil {
+		return nil, false
+	}
+	a.drain(nil)
+	return a.rolling.Metrics(rng)
+}
+
+// RollingRanges retourne les durées glissantes servies (nil: désactivé)
+func (a *Aggregator) RollingRanges() []time.Duration {
+	if a.rolling == nil {
+		return nil
+	}
+	return a.rolling.Ranges()
+}
+
+// AnomaliesEnabled indique si la détection d'anomalies est active
+func (a *Aggregator) AnomaliesEnabled() bool {
+	return a.anomalies != nil
+}
+
+// Retention retourne le suivi des cohortes de rétention (nil si désactivé)
+func (a *Aggregator) Retention() *Retention {
+	return a.retention
+}
+
+// GetActiveWindows retourne les fenêtres actives de toutes les specs
 func (a *Aggregator) GetActiveWindows() []*TimeWindow {
-	return a.windowManager.GetActiveWindows()
+	a.drain(nil)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	active := make([]*TimeWindow, 0)
+	for _, wm := range a.windowManagers {
+		active = append(active, wm.GetActiveWindows()...)
+	}
+	return active
+}
+
+// WindowStride retourne l'écart entre deux fenêtres consécutives d'une spec
+// configurée (0 pour une spec inconnue)
+func (a *Aggregator) WindowStride(name string) time.Duration {
+	for _, spec := range a.windowSpecs {
+		if spec.Name == name {
+			return spec.stride()
+		}
+	}
+	return 0
+}
+
+// GetWindowSpecs retourne les specs de fenêtres configurées
+func (a *Aggregator) GetWindowSpecs() []WindowSpec {
+	specs := make([]WindowSpec, len(a.windowSpecs))
+	copy(specs, a.windowSpecs)
+	return specs
 }
 
 // GetStats retourne les statistiques de l'agrégateur
 func (a *Aggregator) GetStats() map[string]interface{} {
+	a.drain(nil)
 	a.mu.RLock()
 	defer a.mu.RUnlock()
 
@@ -262,15 +1019,86 @@ func (a *Aggregator) GetStats() map[string]interface{} {
 	uniqueUsers, _ := a.globalMetrics.GetMetricValue("unique_users")
 	uniqueSessions, _ := a.globalMetrics.GetMetricValue("unique_sessions")
 
-	activeWindows := a.windowManager.GetActiveWindows()
+	activeWindows := 0
+	windowsBySpec := make(map[string]int, len(a.windowManagers))
+	for _, wm := range a.windowManagers {
+		count := len(wm.GetActiveWindows())
+		windowsBySpec[wm.Spec().Name] = count
+		activeWindows += count
+	}
+
+	openSessions := 0
+	for _, sm := range a.sessionManagers() {
+		count := sm.ActiveSessions()
+		windowsBySpec[sm.Spec().Name] += count
+		openSessions += count
+	}
+
+	funnelsInProgress := make(map[string]int, len(a.funnelTrackers))
+	for _, tracker := range a.funnelTrackers {
+		funnelsInProgress[tracker.funnel.Name] = tracker.inProgress()
+	}
+
+	retentionUsers := 0
+	if a.retention != nil {
+		retentionUsers = a.retention.Users()
+	}
+
+	transitionSessions := 0
+	if a.transitions != nil {
+		transitionSessions = a.transitions.sessions()
+	}
+
+	anomalies := 0
+	if a.anomalies != nil {
+		anomalies = a.anomalies.count()
+	}
+
+	rollingWindows := 0
+	if a.rolling != nil {
+		rollingWindows = a.rolling.count()
+	}
+
+	lateEvents := make(map[string]int64)
+	for spec, metric := range a.lateEvents.GetAllMetrics() {
+		lateEvents[spec] = metric.Count
+	}
+
+	// Cardinalité des familles globales, et des familles saturées dans les fenêtres
+	cardinality := make(map[string]FamilyCardinality)
+	for family, series := range a.globalMetrics.Cardinality() {
+		limit := a.metricOpts.Cardinality.Limit(family)
+		cardinality[family] = FamilyCardinality{
+			Series:    series,
+			Limit:     limit,
+			Saturated: limit > 0 && series >= limit,
+		}
+	}
+	for family, metric := range a.overflows.GetAllMetrics() {
+		stats := cardinality[family]
+		stats.Limit = a.metricOpts.Cardinality.Limit(family)
+		stats.Overflow = metric.Count
+		stats.Saturated = true
+		cardinality[family] = stats
+	}
 
 	return map[string]interface{}{
-		"total_events":    totalEvents,
-		"unique_users":    uniqueUsers,
-		"unique_sessions": uniqueSessions,
-		"active_windows":  len(activeWindows),
-		"metrics_count":   len(a.globalMetrics.Metrics),
-		"uptime":          time.Since(a.globalMetrics.Timestamp),
+		"total_events":        totalEvents,
+		"unique_users":        uniqueUsers,
+		"unique_sessions":     uniqueSessions,
+		"active_windows":      activeWindows,
+		"open_sessions":       openSessions,
+		"windows_by_spec":     windowsBySpec,
+		"late_events":         lateEvents,
+		"funnels":             funnelsInProgress,
+		"retention_users":     retentionUsers,
+		"transition_sessions": transitionSessions,
+		"anomalies":           anomalies,
+		"rolling_windows":     rollingWindows,
+		"cardinality":         cardinality,
+		"metrics_count":       len(a.globalMetrics.Metrics),
+		"shards":              len(a.shards),
+		"uptime":              a.clock.Now().Sub(a.globalMetrics.Timestamp),
 	}
 }
 
@@ -278,9 +1106,30 @@ func (a *Aggregator) GetStats() map[string]interface{} {
 func (a *Aggregator) Reset() {
 	a.mu.Lock()
 	defer a.mu.Unlock()
+	a.lockShards()
+	defer a.unlockShards()
 
 	a.globalMetrics.Reset()
-	a.windowManager = NewWindowManager(a.windowDuration)
+	a.lateEvents.Reset()
+	a.overflows.Reset()
+	a.windowManagers = newWindowManagers(a.windowSpecs, a.metricOpts)
+	for _, shard := range a.shards {
+		shard.takeLocked(a.globalMetrics)
+		shard.sessions = newSessionManagers(a.windowSpecs, a.metricOpts)
+	}
+	a.funnelTrackers = newFunnelTrackers(a.funnels)
+	if a.retention != nil {
+		a.retention.reset()
+	}
+	if a.transitions != nil {
+		a.transitions.reset()
+	}
+	if a.rolling != nil {
+		a.rolling.reset()
+	}
+	if a.anomalies != nil {
+		a.anomalies.reset()
+	}
 
 	a.logger.Info("aggregator reset")
 }
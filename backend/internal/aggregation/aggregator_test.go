@@ -76,8 +76,12 @@ func TestMetricHistogram(t *testing.T) {
 		t.Errorf("Expected count %d, got %d", len(values), metric.Count)
 	}
 
-	if len(metric.Values) != len(values) {
-		t.Errorf("Expected %d values, got %d", len(values), len(metric.Values))
+	if metric.Min() != 10 || metric.Max() != 50 {
+		t.Errorf("Expected min 10 and max 50, got %.2f and %.2f", metric.Min(), metric.Max())
+	}
+
+	if p50 := metric.Quantile(0.5); math.Abs(p50-30) > 30*DefaultSketchRelativeAccuracy {
+		t.Errorf("Expected p50 close to 30, got %.2f", p50)
 	}
 }
 
@@ -168,9 +172,9 @@ func TestTimeWindowShouldClose(t *testing.T) {
 
 // TestWindowManagerGetOrCreate teste la gestion de fenêtres
 func TestWindowManagerGetOrCreate(t *testing.T) {
-	wm := NewWindowManager(1 * time.Minute)
-
-	now := time.Now()
+	// Début de minute fixe: now+30s reste dans la même fenêtre
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	wm := NewWindowManager(1*time.Minute, NewManualClock(now))
 
 	// Créer première fenêtre
 	window1 := wm.GetOrCreateWindow(now)
@@ -411,6 +415,241 @@ func TestAggregatorStats(t *testing.T) {
 	}
 }
 
+// TestAggregatorWindowSpecs teste une fenêtre par spec et la sélection des métriques
+func TestAggregatorWindowSpecs(t *testing.T) {
+	logger := zap.NewNop()
+	past := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewManualClock(past)
+	agg := NewAggregatorWithOptions(Options{
+		Windows: []WindowSpec{
+			{Kind: WindowKindTumbling, Size: 1 * time.Minute, Metrics: []string{"count", "sum", "avg"}},
+			{Kind: WindowKindTumbling, Size: 5 * time.Minute, Metrics: []string{"count", "p95", "p99"}},
+		},
+		FlushInterval: 10 * time.Second,
+		Clock:         clock,
+	}, logger)
+
+	var closed []*TimeWindow
+	agg.SetWindowClosedCallback(func(window *TimeWindow) {
+		closed = append(closed, window)
+	})
+
+	for i, amount := range []float64{10, 20, 30, 40} {
+		agg.ProcessEvent(Event{
+			ID:         fmt.Sprintf("evt_%d", i),
+			Type:       "purchase",
+			Timestamp:  past.Add(time.Duration(i) * time.Second),
+			UserID:     "user_1",
+			Properties: map[string]interface{}{"amount": amount},
+		})
+	}
+
+	if len(agg.GetActiveWindows()) != 2 {
+		t.Fatalf("Expected 2 active windows, got %d", len(agg.GetActiveWindows()))
+	}
+
+	// Avancer l'horloge au-delà des deux fenêtres
+	clock.Advance(6 * time.Minute)
+	agg.Flush()
+
+	if len(closed) != 2 {
+		t.Fatalf("Expected 2 closed windows, got %d", len(closed))
+	}
+
+	bySpec := make(map[string]*TimeWindow)
+	for _, window := range closed {
+		bySpec[window.Spec] = window
+	}
+
+	oneMinute, ok := bySpec["tumbling_1m"]
+	if !ok {
+		t.Fatal("Expected a window from spec tumbling_1m")
+	}
+	if avg, _ := oneMinute.Metrics.GetMetricValue("amount_avg"); avg != 25 {
+		t.Errorf("Expected amount_avg 25, got %.2f", avg)
+	}
+	if _, ok := oneMinute.Metrics.GetMetricValue("amount_p95"); ok {
+		t.Error("Expected no amount_p95 in tumbling_1m")
+	}
+	if _, ok := oneMinute.Metrics.GetMetricValue("active_users"); ok {
+		t.Error("Expected no active_users when unique is not listed")
+	}
+
+	fiveMinutes, ok := bySpec["tumbling_5m"]
+	if !ok {
+		t.Fatal("Expected a window from spec tumbling_5m")
+	}
+	if p95, _ := fiveMinutes.Metrics.GetMetricValue("amount_p95"); p95 != 40 {
+		t.Errorf("Expected amount_p95 40, got %.2f", p95)
+	}
+	if events, _ := fiveMinutes.Metrics.GetMetricValue("events"); events != 4 {
+		t.Errorf("Expected 4 events, got %.0f", events)
+	}
+	if _, ok := fiveMinutes.Metrics.GetMetricValue("amount"); ok {
+		t.Error("Expected no amount sum in tumbling_5m")
+	}
+}
+
+// TestWindowManagerSliding teste l'affectation d'un événement aux fenêtres qui se chevauchent
+func TestWindowManagerSliding(t *testing.T) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	wm := NewWindowManagerForSpec(WindowSpec{
+		Kind:    WindowKindSliding,
+		Size:    5 * time.Minute,
+		Step:    1 * time.Minute,
+		Metrics: []string{"rate", "unique"},
+	}, NewManualClock(base))
+
+	if wm.Spec().Name != "sliding_5m_1m" {
+		t.Errorf("Expected spec name sliding_5m_1m, got %s", wm.Spec().Name)
+	}
+
+	windows := wm.GetOrCreateWindows(base.Add(30 * time.Second))
+	if len(windows) != 5 {
+		t.Fatalf("Expected event in 5 windows, got %d", len(windows))
+	}
+	for _, window := range windows {
+		if window.Duration != 5*time.Minute {
+			t.Errorf("Expected window duration 5m, got %s", window.Duration)
+		}
+		if base.Add(30*time.Second).Before(window.StartTime) || !base.Add(30*time.Second).Before(window.EndTime) {
+			t.Errorf("Window [%s, %s) does not contain the event", window.StartTime, window.EndTime)
+		}
+	}
+
+	// Une seule fenêtre se ferme à chaque pas
+	closed := wm.CloseExpiredWindows(base.Add(1*time.Minute + time.Second))
+	if len(closed) != 1 {
+		t.Errorf("Expected 1 window closed after one step, got %d", len(closed))
+	}
+	closed = wm.CloseExpiredWindows(base.Add(2*time.Minute + time.Second))
+	if len(closed) != 1 {
+		t.Errorf("Expected 1 window closed after two steps, got %d", len(closed))
+	}
+}
+
+// TestAggregatorSlidingRate teste le débit et les uniques sur toute la taille de la fenêtre
+func TestAggregatorSlidingRate(t *testing.T) {
+	logger := zap.NewNop()
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	agg := NewAggregatorWithOptions(Options{
+		Windows: []WindowSpec{
+			{Kind: WindowKindSliding, Size: 5 * time.Minute, Step: 1 * time.Minute, Metrics: []string{"rate", "unique"}},
+		},
+		FlushInterval: 10 * time.Second,
+		Clock:         clock,
+	}, logger)
+
+	var closed []*TimeWindow
+	agg.SetWindowClosedCallback(func(window *TimeWindow) {
+		closed = append(closed, window)
+	})
+
+	// 300 événements répartis sur 5 minutes, 3 utilisateurs
+	for i := 0; i < 300; i++ {
+		clock.Set(start.Add(time.Duration(i) * time.Second))
+		agg.ProcessEvent(Event{
+			ID:        fmt.Sprintf("evt_%d", i),
+			Type:      "pageview",
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+			UserID:    fmt.Sprintf("user_%d", i%3),
+		})
+	}
+
+	clock.Set(start.Add(10 * time.Minute))
+	agg.Flush()
+
+	var full *TimeWindow
+	for _, window := range closed {
+		if window.StartTime.Equal(start) {
+			full = window
+		}
+	}
+	if full == nil {
+		t.Fatal("Expected the window starting with the first event to be closed")
+	}
+
+	if rate, _ := full.Metrics.GetMetricValue("events_rate"); rate != 1 {
+		t.Errorf("Expected events_rate 1/s over 5m, got %.2f", rate)
+	}
+	if users := full.Metrics.GetMetric("active_users", MetricTypeSet); users.Count != 3 {
+		t.Errorf("Expected 3 active users, got %d", users.Count)
+	}
+}
+
+// TestAggregatorManualClock teste le rejeu déterministe piloté par une horloge manuelle
+func TestAggregatorManualClock(t *testing.T) {
+	logger := zap.NewNop()
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	agg := NewAggregatorWithOptions(Options{
+		Windows:       []WindowSpec{{Kind: WindowKindTumbling, Size: 1 * time.Minute}},
+		FlushInterval: 10 * time.Second,
+		Clock:         clock,
+	}, logger)
+
+	closed := 0
+	agg.SetWindowClosedCallback(func(*TimeWindow) { closed++ })
+
+	// Rejouer 3 minutes de données historiques, une seconde à la fois
+	for i := 0; i < 180; i++ {
+		clock.Set(start.Add(time.Duration(i) * time.Second))
+		agg.ProcessEvent(Event{Type: "pageview", Timestamp: clock.Now(), UserID: "user_1"})
+		agg.Flush()
+	}
+
+	// Les deux premières minutes sont fermées, la troisième reste ouverte
+	if closed != 2 {
+		t.Errorf("Expected 2 closed windows, got %d", closed)
+	}
+	if len(agg.GetActiveWindows()) != 1 {
+		t.Errorf("Expected 1 active window, got %d", len(agg.GetActiveWindows()))
+	}
+
+	// Les horodatages viennent de l'horloge, pas de l'heure murale
+	total := agg.GetGlobalMetrics()["total_events"]
+	if !total.Timestamp.Equal(start.Add(179 * time.Second)) {
+		t.Errorf("Expected metric timestamp from clock, got %s", total.Timestamp)
+	}
+}
+
+// TestAggregatorAmountProperty teste les métriques de montant des fenêtres
+// calculées sur une propriété configurée, y compris en chaîne numérique
+func TestAggregatorAmountProperty(t *testing.T) {
+	start := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	agg := NewAggregatorWithOptions(Options{
+		Windows:        []WindowSpec{{Kind: WindowKindTumbling, Size: time.Minute, Metrics: []string{WindowMetricSum, WindowMetricAvg}}},
+		FlushInterval:  10 * time.Second,
+		Clock:          clock,
+		AmountProperty: "price",
+	}, zap.NewNop())
+
+	var closed *TimeWindow
+	agg.SetWindowClosedCallback(func(window *TimeWindow) { closed = window })
+
+	for _, props := range []map[string]interface{}{
+		{"price": 10.0},
+		{"price": "30"},
+		{"amount": 1000.0}, // pas la propriété configurée
+	} {
+		agg.ProcessEvent(Event{Type: "purchase", Timestamp: start, Properties: props})
+	}
+	clock.Set(start.Add(time.Minute + time.Second))
+	agg.Flush()
+
+	if closed == nil {
+		t.Fatal("Expected the window to close")
+	}
+	if sum, _ := closed.Metrics.GetMetricValue("amount"); sum != 40 {
+		t.Errorf("Expected amount 40 from the price property, got %v", sum)
+	}
+	if avg, _ := closed.Metrics.GetMetricValue("amount_avg"); avg != 20 {
+		t.Errorf("Expected amount_avg 20, got %v", avg)
+	}
+}
+
 // BenchmarkMetricIncrement benchmark l'incrémentation
 func BenchmarkMetricIncrement(b *testing.B) {
 	metric := NewMetric("bench", MetricTypeCounter)
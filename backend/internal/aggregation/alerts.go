@@ -0,0 +1,398 @@
+package aggregation
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// AlertOp est la comparaison d'une règle d'alerte entre la valeur et le seuil
+type AlertOp string
+
+const (
+	AlertOpLess         AlertOp = "<"
+	AlertOpLessEqual    AlertOp = "<="
+	AlertOpGreater      AlertOp = ">"
+	AlertOpGreaterEqual AlertOp = ">="
+	AlertOpEqual        AlertOp = "=="
+	AlertOpNotEqual     AlertOp = "!="
+)
+
+// compare applique l'opérateur
+func (op AlertOp) compare(value, threshold float64) bool {
+	switch op {
+	case AlertOpLess:
+		return value < threshold
+	case AlertOpLessEqual:
+		return value <= threshold
+	case AlertOpGreater:
+		return value > threshold
+	case AlertOpGreaterEqual:
+		return value >= threshold
+	case AlertOpEqual:
+		return value == threshold
+	case AlertOpNotEqual:
+		return value != threshold
+	}
+	return false
+}
+
+// AlertState est l'état d'une alerte
+type AlertState string
+
+const (
+	AlertInactive AlertState = "inactive" // condition fausse
+	AlertPending  AlertState = "pending"  // condition vraie depuis moins de For fenêtres
+	AlertFiring   AlertState = "firing"   // condition vraie depuis For fenêtres consécutives
+	AlertResolved AlertState = "resolved" // condition redevenue fausse après un déclenchement
+)
+
+// maxAlertGap borne le nombre de fenêtres vides évaluées après un trou
+const maxAlertGap = 1440
+
+// AlertRule compare à chaque fermeture d'une fenêtre de Spec la valeur de la
+// métrique Metric (somme des séries correspondant à Labels, 0 sans série) à
+// Threshold. L'alerte se déclenche après For fenêtres consécutives où la
+// condition est vraie: events{type="purchase"} < 5 pendant 3 fenêtres de 1m
+// s'écrit {Spec: "tumbling_1m", Metric: "events", Labels: {type: purchase},
+// Op: "<", Threshold: 5, For: 3}.
+type AlertRule struct {
+	Name        string  `json:"name"`
+	Spec        string  `json:"spec"`
+	Metric      string  `json:"metric"`
+	Labels      Labels  `json:"labels,omitempty"`
+	Op          AlertOp `json:"op"`
+	Threshold   float64 `json:"threshold"`
+	For         int     `json:"for"` // fenêtres consécutives (défaut 1)
+	Severity    string  `json:"severity,omitempty"`
+	Description string  `json:"description,omitempty"`
+}
+
+// Validate vérifie qu'une règle d'alerte est utilisable
+func (r AlertRule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("alert rule name is required")
+	}
+	if r.Spec == "" || r.Metric == "" {
+		return fmt.Errorf("alert rule %s: spec and metric are required", r.Name)
+	}
+	switch r.Op {
+	case AlertOpLess, AlertOpLessEqual, AlertOpGreater, AlertOpGreaterEqual, AlertOpEqual, AlertOpNotEqual:
+	default:
+		return fmt.Errorf("alert rule %s: invalid op: %s", r.Name, r.Op)
+	}
+	if r.For < 0 {
+		return fmt.Errorf("alert rule %s: for must not be negative", r.Name)
+	}
+	return nil
+}
+
+// seriesValue est la valeur d'une série comparable d'une fenêtre à l'autre:
+// la cardinalité pour un set, la valeur sinon
+func seriesValue(metric *Metric) float64 {
+	summary := metric.Summary()
+	if metric.Type == MetricTypeSet {
+		return float64(summary.Count)
+	}
+	return summary.Value
+}
+
+// value retourne la valeur de la règle dans une fenêtre (0 sans série)
+func (r AlertRule) value(window *TimeWindow) float64 {
+	total := 0.0
+	for _, metric := range window.Metrics.Query(r.Metric, r.Labels) {
+		total += seriesValue(metric)
+	}
+	return total
+}
+
+// AlertStatus est l'état courant d'une règle
+type AlertStatus struct {
+	Rule        AlertRule  `json:"rule"`
+	State       AlertState `json:"state"`
+	Value       float64    `json:"value"`       // valeur de la dernière fenêtre évaluée
+	Consecutive int        `json:"consecutive"` // fenêtres consécutives où la condition est vraie
+	WindowStart time.Time  `json:"window_start"`
+	WindowEnd   time.Time  `json:"window_end"`
+	ActiveSince time.Time  `json:"active_since"` // début de la première fenêtre en condition
+	FiredAt     time.Time  `json:"fired_at"`
+	ResolvedAt  time.Time  `json:"resolved_at"`
+}
+
+// AlertNotification est envoyée aux notifiers quand une alerte se déclenche
+// ou est résolue
+type AlertNotification struct {
+	Rule        string     `json:"rule"`
+	Severity    string     `json:"severity,omitempty"`
+	Description string     `json:"description,omitempty"`
+	State       AlertState `json:"state"` // firing ou resolved
+	Spec        string     `json:"spec"`
+	Series      string     `json:"series"`
+	Op          AlertOp    `json:"op"`
+	Threshold   float64    `json:"threshold"`
+	Value       float64    `json:"value"`
+	WindowStart time.Time  `json:"window_start"`
+	WindowEnd   time.Time  `json:"window_end"`
+	At          time.Time  `json:"at"`
+}
+
+// alertRuleState suit une règle entre deux fenêtres
+type alertRuleState struct {
+	status    AlertStatus
+	lastStart time.Time
+}
+
+// Alerts évalue des règles de seuil sur les fenêtres fermées, transmises par
+// le callback de SetWindowClosedCallback. Seule la première émission d'une
+// fenêtre temporelle est évaluée (pas les révisions amendées ni les fenêtres
+// de session), dans l'ordre de leur début; une fenêtre manquante (aucun
+// événement) est évaluée comme une fenêtre vide. Les passages à firing et à
+// resolved sont mis en file et envoyés aux notifiers par Run, hors du chemin de
+// fermeture des fenêtres; quand la file est pleine, la notification est perdue
+// (comptée par Dropped).
+type Alerts struct {
+	rules     []*alertRuleState
+	notifiers []Notifier
+	timeout   time.Duration
+	queue     chan AlertNotification
+	dropped   uint64
+	clock     Clock
+	logger    *zap.Logger
+	mu        sync.RWMutex
+}
+
+// AlertOptions configure le moteur d'alertes
+type AlertOptions struct {
+	Rules     []AlertRule
+	Notifiers []Notifier
+	// Timeout borne l'envoi d'une notification (0: DefaultNotifyTimeout)
+	Timeout time.Duration
+	// QueueSize est le nombre de notifications en attente d'envoi
+	// (0: DefaultNotifyQueueSize)
+	QueueSize int
+	// Clock horodate les transitions (nil: horloge murale)
+	Clock Clock
+}
+
+// DefaultNotifyTimeout est le délai d'envoi d'une notification
+const DefaultNotifyTimeout = 5 * time.Second
+
+// DefaultNotifyQueueSize est la taille par défaut de la file des notifications
+const DefaultNotifyQueueSize = 256
+
+// NewAlerts crée un moteur d'alertes; les règles invalides ou en double sont
+// refusées
+func NewAlerts(opts AlertOptions, logger *zap.Logger) (*Alerts, error) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultNotifyTimeout
+	}
+	if opts.QueueSize <= 0 {
+		opts.QueueSize = DefaultNotifyQueueSize
+	}
+	a := &Alerts{
+		notifiers: opts.Notifiers,
+		timeout:   opts.Timeout,
+		queue:     make(chan AlertNotification, opts.QueueSize),
+		clock:     orSystemClock(opts.Clock),
+		logger:    logger,
+	}
+	names := make(map[string]bool, len(opts.Rules))
+	for _, rule := range opts.Rules {
+		if err := rule.Validate(); err != nil {
+			return nil, err
+		}
+		if names[rule.Name] {
+			return nil, fmt.Errorf("alert rule %s: duplicate name", rule.Name)
+		}
+		if rule.For == 0 {
+			rule.For = 1
+		}
+		rule.Labels = rule.Labels.clone()
+		names[rule.Name] = true
+		a.rules = append(a.rules, &alertRuleState{status: AlertStatus{Rule: rule, State: AlertInactive}})
+	}
+	return a, nil
+}
+
+// Evaluate évalue les règles de la spec d'une fenêtre fermée et met en file les
+// notifications de ses transitions, qu'elle retourne. Elle ne bloque jamais sur
+// les notifiers. step est l'écart entre les débuts de deux fenêtres
+// consécutives de la spec (Step pour une fenêtre glissante, Size sinon).
+func (a *Alerts) Evaluate(window *TimeWindow, step time.Duration) []AlertNotification {
+	if window.Key != "" || window.Revision > 0 || window.Duration <= 0 || step <= 0 {
+		return nil
+	}
+
+	a.mu.Lock()
+	notifications := make([]AlertNotification, 0)
+	for _, state := range a.rules {
+		if state.status.Rule.Spec != window.Spec {
+			continue
+		}
+		if !state.lastStart.IsZero() && !window.StartTime.After(state.lastStart) {
+			continue
+		}
+		// Fenêtres manquantes depuis la dernière évaluée: les débuts avancent de
+		// step, chaque fenêtre couvre window.Duration
+		if !state.lastStart.IsZero() {
+			missing := int(window.StartTime.Sub(state.lastStart)/step) - 1
+			if missing > maxAlertGap {
+				missing = maxAlertGap
+			}
+			for i := missing; i > 0; i-- {
+				start := window.StartTime.Add(-time.Duration(i) * step)
+				if n, ok := a.transitionLocked(state, 0, start, start.Add(window.Duration)); ok {
+					notifications = append(notifications, n)
+				}
+			}
+		}
+		if n, ok := a.transitionLocked(state, state.status.Rule.value(window), window.StartTime, window.EndTime); ok {
+			notifications = append(notifications, n)
+		}
+		state.lastStart = window.StartTime
+	}
+	a.mu.Unlock()
+
+	for _, notification := range notifications {
+		a.enqueue(notification)
+	}
+	return notifications
+}
+
+// enqueue met une notification en file sans bloquer; file pleine, elle est
+// perdue et comptée
+func (a *Alerts) enqueue(notification AlertNotification) {
+	select {
+	case a.queue <- notification:
+	default:
+		a.mu.Lock()
+		a.dropped++
+		a.mu.Unlock()
+		a.logger.Warn("alert notification dropped, queue full",
+			zap.String("rule", notification.Rule),
+			zap.String("state", string(notification.State)),
+		)
+	}
+}
+
+// Run envoie les notifications en file aux notifiers, dans l'ordre, jusqu'à
+// l'annulation de ctx; les notifications encore en file sont alors envoyées
+// avant de retourner
+func (a *Alerts) Run(ctx context.Context) {
+	for {
+		select {
+		case notification := <-a.queue:
+			a.notify(notification)
+		case <-ctx.Done():
+			for {
+				select {
+				case notification := <-a.queue:
+					a.notify(notification)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Dropped retourne le nombre de notifications perdues faute de place en file
+func (a *Alerts) Dropped() uint64 {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	return a.dropped
+}
+
+// transitionLocked fait évoluer une règle avec la valeur d'une fenêtre et
+// retourne la notification d'un passage à firing ou à resolved (a.mu tenu)
+func (a *Alerts) transitionLocked(state *alertRuleState, value float64, start, end time.Time) (AlertNotification, bool) {
+	status := &state.status
+	rule := status.Rule
+	status.Value = value
+	status.WindowStart, status.WindowEnd = start, end
+	previous := status.State
+
+	if rule.Op.compare(value, rule.Threshold) {
+		if status.Consecutive == 0 {
+			status.ActiveSince = start
+		}
+		status.Consecutive++
+		if status.Consecutive >= rule.For {
+			status.State = AlertFiring
+		} else {
+			status.State = AlertPending
+		}
+	} else {
+		status.Consecutive = 0
+		status.ActiveSince = time.Time{}
+		switch previous {
+		case AlertFiring:
+			status.State = AlertResolved
+		case AlertPending:
+			status.State = AlertInactive
+		}
+	}
+
+	if status.State == previous || (status.State != AlertFiring && status.State != AlertResolved) {
+		return AlertNotification{}, false
+	}
+	now := a.clock.Now()
+	if status.State == AlertFiring {
+		status.FiredAt, status.ResolvedAt = now, time.Time{}
+	} else {
+		status.ResolvedAt = now
+	}
+	return AlertNotification{
+		Rule:        rule.Name,
+		Severity:    rule.Severity,
+		Description: rule.Description,
+		State:       status.State,
+		Spec:        rule.Spec,
+		Series:      SeriesKey(rule.Metric, rule.Labels),
+		Op:          rule.Op,
+		Threshold:   rule.Threshold,
+		Value:       value,
+		WindowStart: start,
+		WindowEnd:   end,
+		At:          now,
+	}, true
+}
+
+// notify envoie une notification à chaque notifier; les échecs sont journalisés
+func (a *Alerts) notify(notification AlertNotification) {
+	for _, notifier := range a.notifiers {
+		ctx, cancel := context.WithTimeout(context.Background(), a.timeout)
+		if err := notifier.Notify(ctx, notification); err != nil {
+			a.logger.Error("alert notification failed",
+				zap.String("rule", notification.Rule),
+				zap.String("state", string(notification.State)),
+				zap.Error(err),
+			)
+		}
+		cancel()
+	}
+}
+
+// Statuses retourne l'état de chaque règle (toutes si state est vide), triées par nom
+func (a *Alerts) Statuses(state AlertState) []AlertStatus {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	statuses := make([]AlertStatus, 0, len(a.rules))
+	for _, rule := range a.rules {
+		if state != "" && rule.status.State != state {
+			continue
+		}
+		status := rule.status
+		status.Rule.Labels = status.Rule.Labels.clone()
+		statuses = append(statuses, status)
+	}
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Rule.Name < statuses[j].Rule.Name
+	})
+	return statuses
+}
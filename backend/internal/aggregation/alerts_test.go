@@ -0,0 +1,251 @@
+package aggregation
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// recordingNotifier retient les notifications reçues
+type recordingNotifier struct {
+	notifications []AlertNotification
+	mu            sync.Mutex
+}
+
+func (n *recordingNotifier) Notify(ctx context.Context, notification AlertNotification) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.notifications = append(n.notifications, notification)
+	return nil
+}
+
+// TestAlertsLifecycle teste pending, firing et resolved d'une règle de seuil
+// sur les fenêtres fermées, fenêtres manquantes comprises
+func TestAlertsLifecycle(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	agg := NewAggregatorWithOptions(Options{
+		Windows:       []WindowSpec{{Kind: WindowKindTumbling, Size: time.Minute}},
+		FlushInterval: 10 * time.Second,
+		Clock:         clock,
+		Shards:        4,
+	}, zap.NewNop())
+
+	recorder := &recordingNotifier{}
+	path := filepath.Join(t.TempDir(), "alerts.jsonl")
+	alerts, err := NewAlerts(AlertOptions{
+		Rules: []AlertRule{{
+			Name:      "low_purchases",
+			Spec:      "tumbling_1m",
+			Metric:    "events",
+			Labels:    Labels{"type": "purchase"},
+			Op:        AlertOpLess,
+			Threshold: 5,
+			For:       3,
+		}},
+		Notifiers: []Notifier{recorder, NewFileNotifier(path), NewLogNotifier(zap.NewNop())},
+		Clock:     clock,
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewAlerts: %v", err)
+	}
+	agg.SetWindowClosedCallback(func(window *TimeWindow) {
+		alerts.Evaluate(window, agg.WindowStride(window.Spec))
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	delivered := make(chan struct{})
+	go func() {
+		alerts.Run(ctx)
+		close(delivered)
+	}()
+
+	// Une minute avec n achats (et une page vue, pour que la fenêtre existe)
+	minute := func(i, purchases int) {
+		at := start.Add(time.Duration(i) * time.Minute)
+		agg.ProcessEvent(Event{Type: "pageview", Timestamp: at})
+		for j := 0; j < purchases; j++ {
+			agg.ProcessEvent(Event{Type: "purchase", Timestamp: at.Add(time.Second)})
+		}
+		clock.Set(at.Add(time.Minute + time.Second))
+		agg.Flush()
+	}
+	state := func() AlertStatus {
+		statuses := alerts.Statuses("")
+		if len(statuses) != 1 {
+			t.Fatalf("Expected 1 alert status, got %d", len(statuses))
+		}
+		return statuses[0]
+	}
+
+	minute(0, 10)
+	if s := state(); s.State != AlertInactive || s.Value != 10 {
+		t.Errorf("Expected inactive at 10 purchases, got %+v", s)
+	}
+	minute(1, 2)
+	minute(2, 0) // aucun achat: série absente, valeur 0
+	if s := state(); s.State != AlertPending || s.Consecutive != 2 || !s.ActiveSince.Equal(start.Add(time.Minute)) {
+		t.Errorf("Expected pending for 2 windows, got %+v", s)
+	}
+	if len(recorder.notifications) != 0 {
+		t.Fatalf("Expected no notification while pending, got %+v", recorder.notifications)
+	}
+
+	// La minute 3 n'a aucun événement: comptée comme vide, l'alerte se déclenche.
+	// Run envoie la file restante avant de retourner.
+	minute(4, 10)
+	cancel()
+	<-delivered
+	if len(recorder.notifications) != 2 {
+		t.Fatalf("Expected firing then resolved, got %+v", recorder.notifications)
+	}
+	firing, resolved := recorder.notifications[0], recorder.notifications[1]
+	if firing.State != AlertFiring || firing.Value != 0 || !firing.WindowStart.Equal(start.Add(3*time.Minute)) || firing.Series != `events{type="purchase"}` {
+		t.Errorf("Unexpected firing notification %+v", firing)
+	}
+	if resolved.State != AlertResolved || resolved.Value != 10 {
+		t.Errorf("Unexpected resolved notification %+v", resolved)
+	}
+	if s := state(); s.State != AlertResolved || s.FiredAt.IsZero() || s.ResolvedAt.IsZero() {
+		t.Errorf("Expected resolved status, got %+v", s)
+	}
+	if firingOnly := alerts.Statuses(AlertFiring); len(firingOnly) != 0 {
+		t.Errorf("Expected no firing alert, got %+v", firingOnly)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("open alert file: %v", err)
+	}
+	defer file.Close()
+	lines := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var notification AlertNotification
+		if err := json.Unmarshal(scanner.Bytes(), &notification); err != nil || notification.Rule != "low_purchases" {
+			t.Errorf("Unexpected alert file line %q", scanner.Text())
+		}
+		lines++
+	}
+	if lines != 2 {
+		t.Errorf("Expected 2 lines in the alert file, got %d", lines)
+	}
+}
+
+// blockingNotifier bloque chaque envoi jusqu'à la fermeture de release
+type blockingNotifier struct {
+	release chan struct{}
+}
+
+func (n *blockingNotifier) Notify(ctx context.Context, notification AlertNotification) error {
+	<-n.release
+	return nil
+}
+
+// TestAlertsQueueFull teste qu'un notifier lent ne bloque pas Evaluate: les
+// notifications au-delà de la file sont perdues et comptées
+func TestAlertsQueueFull(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	notifier := &blockingNotifier{release: make(chan struct{})}
+	alerts, err := NewAlerts(AlertOptions{
+		Rules:     []AlertRule{{Name: "no_traffic", Spec: "tumbling_1m", Metric: "events", Op: AlertOpLess, Threshold: 1}},
+		Notifiers: []Notifier{notifier},
+		QueueSize: 1,
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewAlerts: %v", err)
+	}
+
+	// Alternance de minutes vides et actives: une transition par fenêtre
+	for i := 0; i < 4; i++ {
+		window := NewTimeWindow(start.Add(time.Duration(i)*time.Minute), time.Minute)
+		window.Spec = "tumbling_1m"
+		if i%2 == 1 {
+			window.Metrics.GetMetric("events", MetricTypeCounter).Increment()
+		}
+		window.Close()
+		if notifications := alerts.Evaluate(window, time.Minute); len(notifications) != 1 {
+			t.Fatalf("Expected 1 transition for window %d, got %+v", i, notifications)
+		}
+	}
+	if dropped := alerts.Dropped(); dropped != 3 {
+		t.Errorf("Expected 3 dropped notifications, got %d", dropped)
+	}
+	close(notifier.release)
+}
+
+// TestAlertsSlidingGap teste que les fenêtres glissantes manquantes sont
+// comptées au pas de la spec, et non à sa taille
+func TestAlertsSlidingGap(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	alerts, err := NewAlerts(AlertOptions{
+		Rules: []AlertRule{{Name: "no_traffic", Spec: "sliding_5m_1m", Metric: "events", Op: AlertOpLess, Threshold: 1, For: 3}},
+	}, zap.NewNop())
+	if err != nil {
+		t.Fatalf("NewAlerts: %v", err)
+	}
+	window := func(offset time.Duration) *TimeWindow {
+		window := NewTimeWindow(start.Add(offset), 5*time.Minute)
+		window.Spec = "sliding_5m_1m"
+		window.Metrics.GetMetric("events", MetricTypeCounter).Increment()
+		window.Close()
+		return window
+	}
+
+	if notifications := alerts.Evaluate(window(0), time.Minute); len(notifications) != 0 {
+		t.Fatalf("Expected no transition on an active window, got %+v", notifications)
+	}
+	// 12:01, 12:02 et 12:03 manquent: trois fenêtres vides déclenchent l'alerte,
+	// que la fenêtre de 12:04 résout
+	notifications := alerts.Evaluate(window(4*time.Minute), time.Minute)
+	if len(notifications) != 2 {
+		t.Fatalf("Expected firing then resolved, got %+v", notifications)
+	}
+	firing, resolved := notifications[0], notifications[1]
+	if firing.State != AlertFiring || !firing.WindowStart.Equal(start.Add(3*time.Minute)) || !firing.WindowEnd.Equal(start.Add(8*time.Minute)) {
+		t.Errorf("Unexpected firing notification %+v", firing)
+	}
+	if resolved.State != AlertResolved || !resolved.WindowStart.Equal(start.Add(4*time.Minute)) {
+		t.Errorf("Unexpected resolved notification %+v", resolved)
+	}
+}
+
+// TestWebhookNotifier teste l'envoi JSON d'une notification et les réponses en erreur
+func TestWebhookNotifier(t *testing.T) {
+	var received AlertNotification
+	var header string
+	status := http.StatusOK
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&received)
+		w.WriteHeader(status)
+	}))
+	defer ts.Close()
+
+	notifier := NewWebhookNotifier(ts.URL, map[string]string{"Authorization": "Bearer token"})
+	notification := AlertNotification{Rule: "low_purchases", State: AlertFiring, Value: 2}
+	if err := notifier.Notify(context.Background(), notification); err != nil {
+		t.Fatalf("Notify: %v", err)
+	}
+	if received.Rule != "low_purchases" || received.State != AlertFiring || header != "Bearer token" {
+		t.Errorf("Unexpected webhook payload %+v (authorization %q)", received, header)
+	}
+
+	status = http.StatusInternalServerError
+	if err := notifier.Notify(context.Background(), notification); err == nil {
+		t.Error("Expected an error on a 500 response")
+	}
+
+	if _, err := NewAlerts(AlertOptions{Rules: []AlertRule{{Name: "bad", Spec: "tumbling_1m", Metric: "events", Op: "~"}}}, zap.NewNop()); err == nil {
+		t.Error("Expected an invalid op to be rejected")
+	}
+}
@@ -0,0 +1,296 @@
+package aggregation
+
+import (
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Valeurs par défaut de la détection d'anomalies
+const (
+	DefaultAnomalyAlpha     = 0.3
+	DefaultAnomalyThreshold = 3.0
+	DefaultAnomalyWarmup    = 10
+	DefaultAnomalyHistory   = 1000
+
+	// maxAnomalyGap borne le nombre de fenêtres vides rejouées après un trou
+	maxAnomalyGap = 1440
+)
+
+// anomalySeries sont les familles surveillées dans chaque fenêtre fermée:
+// events, chaque events{type} et active_users
+var anomalySeries = map[string]bool{
+	"events":       true,
+	"active_users": true,
+}
+
+// AnomalyOptions configure la détection d'anomalies sur les fenêtres fermées
+type AnomalyOptions struct {
+	// Alpha est le poids de la dernière fenêtre dans la moyenne et la variance
+	// mobiles exponentielles (EWMA), entre 0 et 1 (0: DefaultAnomalyAlpha)
+	Alpha float64
+	// Threshold est le z-score absolu à partir duquel une fenêtre est
+	// anormale (0: DefaultAnomalyThreshold)
+	Threshold float64
+	// Warmup est le nombre de fenêtres observées avant de signaler une
+	// anomalie sur une série (0: DefaultAnomalyWarmup)
+	Warmup int
+	// MinDeviation est l'écart absolu minimal à la moyenne pour signaler une
+	// anomalie, pour ignorer les variations infimes des séries très stables
+	MinDeviation float64
+	// History est le nombre d'anomalies conservées (0: DefaultAnomalyHistory)
+	History int
+}
+
+// AnomalyDirection indique le sens d'un écart
+type AnomalyDirection string
+
+const (
+	AnomalySpike AnomalyDirection = "spike" // au-dessus de la référence
+	AnomalyDrop  AnomalyDirection = "drop"  // en dessous de la référence
+)
+
+// Anomaly décrit une série dont la valeur dans une fenêtre fermée s'écarte
+// de sa référence récente
+type Anomaly struct {
+	Spec        string           `json:"spec"`
+	Series      string           `json:"series"` // clé de série: events, events{type="pageview"}, active_users
+	WindowStart time.Time        `json:"window_start"`
+	WindowEnd   time.Time        `json:"window_end"`
+	Value       float64          `json:"value"`
+	Expected    float64          `json:"expected"` // moyenne EWMA avant la fenêtre
+	StdDev      float64          `json:"stddev"`
+	ZScore      float64          `json:"zscore"`
+	Direction   AnomalyDirection `json:"direction"`
+	DetectedAt  time.Time        `json:"detected_at"`
+}
+
+// ewmaBaseline est la référence d'une série: moyenne et variance mobiles
+type ewmaBaseline struct {
+	mean     float64
+	variance float64
+	samples  int
+}
+
+// observe compare x à la référence puis l'y intègre; retourne le z-score
+// (0 si l'écart-type est nul et x égal à la moyenne)
+func (b *ewmaBaseline) observe(x, alpha float64) (mean, stddev, z float64) {
+	mean, stddev = b.mean, math.Sqrt(b.variance)
+	switch {
+	case stddev > 0:
+		z = (x - mean) / stddev
+	case x != mean:
+		z = math.Inf(1)
+		if x < mean {
+			z = math.Inf(-1)
+		}
+	}
+
+	if b.samples == 0 {
+		b.mean = x
+	} else {
+		diff := x - b.mean
+		b.mean += alpha * diff
+		b.variance = (1 - alpha) * (b.variance + alpha*diff*diff)
+	}
+	b.samples++
+	return mean, stddev, z
+}
+
+// specBaselines suit les séries d'une spec de fenêtres
+type specBaselines struct {
+	series    map[string]*ewmaBaseline
+	lastStart time.Time
+	step      time.Duration // écart entre les débuts de deux fenêtres consécutives
+}
+
+// AnomalyDetector compare les séries clés de chaque fenêtre fermée à une
+// référence EWMA des fenêtres précédentes de la même spec, et signale celles
+// dont le z-score dépasse le seuil. Une série absente d'une fenêtre vaut 0, et
+// les fenêtres manquantes (aucun événement) sont comptées comme des fenêtres
+// vides: une chute à zéro est détectée dès la fenêtre suivante.
+type AnomalyDetector struct {
+	opts      AnomalyOptions
+	clock     Clock
+	baselines map[string]*specBaselines
+	anomalies []Anomaly // les plus récentes en dernier, au plus opts.History
+	mu        sync.RWMutex
+}
+
+// NewAnomalyDetector crée un détecteur (clock nil: horloge murale)
+func NewAnomalyDetector(opts AnomalyOptions, clock Clock) *AnomalyDetector {
+	if opts.Alpha <= 0 || opts.Alpha > 1 {
+		opts.Alpha = DefaultAnomalyAlpha
+	}
+	if opts.Threshold <= 0 {
+		opts.Threshold = DefaultAnomalyThreshold
+	}
+	if opts.Warmup <= 0 {
+		opts.Warmup = DefaultAnomalyWarmup
+	}
+	if opts.History <= 0 {
+		opts.History = DefaultAnomalyHistory
+	}
+	return &AnomalyDetector{
+		opts:      opts,
+		clock:     orSystemClock(clock),
+		baselines: make(map[string]*specBaselines),
+	}
+}
+
+// anomalyValues extrait les séries surveillées d'une fenêtre
+func anomalyValues(window *TimeWindow) map[string]float64 {
+	values := make(map[string]float64)
+	for key, metric := range window.Metrics.GetAllMetrics() {
+		if !anomalySeries[metric.Name] {
+			continue
+		}
+		values[key] = seriesValue(metric)
+	}
+	return values
+}
+
+// Observe évalue une fenêtre fermée et retourne ses anomalies. Seule la
+// première émission d'une fenêtre temporelle est évaluée: les fenêtres de
+// session, les révisions amendées et les fenêtres antérieures à la dernière
+// observée sont ignorées. step est l'écart entre les débuts de deux fenêtres
+// consécutives de la spec (Step pour une fenêtre glissante, Size sinon).
+func (d *AnomalyDetector) Observe(window *TimeWindow, step time.Duration) []Anomaly {
+	if window.Key != "" || window.Revision > 0 || window.Duration <= 0 || step <= 0 {
+		return nil
+	}
+	values := anomalyValues(window)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	spec, ok := d.baselines[window.Spec]
+	if !ok {
+		spec = &specBaselines{series: make(map[string]*ewmaBaseline), step: step}
+		d.baselines[window.Spec] = spec
+	}
+	if !spec.lastStart.IsZero() && !window.StartTime.After(spec.lastStart) {
+		return nil
+	}
+
+	// Fenêtres manquantes depuis la dernière observée: les débuts avancent de
+	// step, chaque fenêtre couvre window.Duration
+	detected := make([]Anomaly, 0)
+	if !spec.lastStart.IsZero() {
+		missing := int(window.StartTime.Sub(spec.lastStart)/spec.step) - 1
+		if missing > maxAnomalyGap {
+			missing = maxAnomalyGap
+		}
+		for i := missing; i > 0; i-- {
+			start := window.StartTime.Add(-time.Duration(i) * spec.step)
+			detected = append(detected, d.observeLocked(spec, window.Spec, start, start.Add(window.Duration), nil)...)
+		}
+	}
+	detected = append(detected, d.observeLocked(spec, window.Spec, window.StartTime, window.EndTime, values)...)
+	spec.lastStart = window.StartTime
+
+	d.anomalies = append(d.anomalies, detected...)
+	if overflow := len(d.anomalies) - d.opts.History; overflow > 0 {
+		d.anomalies = append([]Anomaly(nil), d.anomalies[overflow:]...)
+	}
+	return detected
+}
+
+// observeLocked intègre les valeurs d'une fenêtre (nil: fenêtre vide) aux
+// références de la spec (d.mu doit être tenu)
+func (d *AnomalyDetector) observeLocked(spec *specBaselines, name string, start, end time.Time, values map[string]float64) []Anomaly {
+	for key := range values {
+		if _, ok := spec.series[key]; !ok {
+			spec.series[key] = &ewmaBaseline{}
+		}
+	}
+
+	keys := make([]string, 0, len(spec.series))
+	for key := range spec.series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	detected := make([]Anomaly, 0)
+	for _, key := range keys {
+		baseline := spec.series[key]
+		warm := baseline.samples >= d.opts.Warmup
+		value := values[key]
+		mean, stddev, z := baseline.observe(value, d.opts.Alpha)
+		if !warm || math.Abs(z) < d.opts.Threshold || math.Abs(value-mean) < d.opts.MinDeviation {
+			continue
+		}
+
+		anomaly := Anomaly{
+			Spec:        name,
+			Series:      key,
+			WindowStart: start,
+			WindowEnd:   end,
+			Value:       value,
+			Expected:    mean,
+			StdDev:      stddev,
+			ZScore:      z,
+			Direction:   AnomalySpike,
+			DetectedAt:  d.clock.Now(),
+		}
+		if value < mean {
+			anomaly.Direction = AnomalyDrop
+		}
+		// JSON ne représente pas l'infini: écart sur une série jusqu'ici constante
+		if math.IsInf(z, 0) {
+			anomaly.ZScore = math.Copysign(math.MaxFloat64, z)
+		}
+		detected = append(detected, anomaly)
+	}
+	return detected
+}
+
+// AnomalyFilter sélectionne les anomalies retournées par Anomalies
+type AnomalyFilter struct {
+	Spec   string    // vide: toutes les specs
+	Series string    // clé de série exacte, vide: toutes
+	Since  time.Time // début de fenêtre minimal, zéro: sans borne
+	Limit  int       // nombre maximal, les plus récentes (0: toutes)
+}
+
+// Anomalies retourne les anomalies conservées correspondant au filtre, les
+// plus récentes en premier
+func (d *AnomalyDetector) Anomalies(filter AnomalyFilter) []Anomaly {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	result := make([]Anomaly, 0)
+	for i := len(d.anomalies) - 1; i >= 0; i-- {
+		anomaly := d.anomalies[i]
+		if filter.Spec != "" && anomaly.Spec != filter.Spec {
+			continue
+		}
+		if filter.Series != "" && anomaly.Series != filter.Series {
+			continue
+		}
+		if !filter.Since.IsZero() && anomaly.WindowStart.Before(filter.Since) {
+			continue
+		}
+		result = append(result, anomaly)
+		if filter.Limit > 0 && len(result) == filter.Limit {
+			break
+		}
+	}
+	return result
+}
+
+// count retourne le nombre d'anomalies conservées
+func (d *AnomalyDetector) count() int {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	return len(d.anomalies)
+}
+
+// reset oublie les références et les anomalies
+func (d *AnomalyDetector) reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.baselines = make(map[string]*specBaselines)
+	d.anomalies = nil
+}
@@ -0,0 +1,125 @@
+package aggregation
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TestAggregatorAnomalies teste la détection d'une chute du trafic après une
+// période stable, puis d'une fenêtre sans aucun événement
+func TestAggregatorAnomalies(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	agg := NewAggregatorWithOptions(Options{
+		Windows: []WindowSpec{{
+			Kind:    WindowKindTumbling,
+			Size:    time.Minute,
+			Metrics: []string{WindowMetricCount, WindowMetricUnique},
+		}},
+		FlushInterval: 10 * time.Second,
+		Clock:         clock,
+		Shards:        4,
+		Anomalies:     &AnomalyOptions{Warmup: 5},
+	}, zap.NewNop())
+
+	var notified []Anomaly
+	agg.SetAnomalyCallback(func(anomaly Anomaly) {
+		notified = append(notified, anomaly)
+	})
+
+	// Une minute de trafic: n pages vues de n utilisateurs, à ±10% près
+	minute := func(i, n int) {
+		for j := 0; j < n; j++ {
+			agg.ProcessEvent(Event{
+				Type:      "pageview",
+				UserID:    fmt.Sprintf("u%d", j),
+				Timestamp: start.Add(time.Duration(i)*time.Minute + time.Duration(j)*time.Millisecond),
+			})
+		}
+		clock.Set(start.Add(time.Duration(i+1)*time.Minute + time.Second))
+		agg.Flush()
+	}
+
+	for i := 0; i < 8; i++ {
+		minute(i, 100+10*(i%2))
+	}
+	if len(notified) != 0 {
+		t.Fatalf("Expected no anomaly on steady traffic, got %+v", notified)
+	}
+
+	// Chute à 20%: events, events{type="pageview"} et active_users
+	minute(8, 20)
+	drops := agg.Anomalies(AnomalyFilter{})
+	if len(drops) != 3 || len(notified) != 3 {
+		t.Fatalf("Expected 3 anomalies, got %+v", drops)
+	}
+	for _, anomaly := range drops {
+		if anomaly.Direction != AnomalyDrop || anomaly.Value != 20 || anomaly.ZScore > -DefaultAnomalyThreshold {
+			t.Errorf("Unexpected anomaly %+v", anomaly)
+		}
+		if !anomaly.WindowStart.Equal(start.Add(8 * time.Minute)) {
+			t.Errorf("Expected the anomaly on the 9th window, got %v", anomaly.WindowStart)
+		}
+	}
+	if users := agg.Anomalies(AnomalyFilter{Series: "active_users"}); len(users) != 1 || users[0].Expected < 100 {
+		t.Errorf("Unexpected active_users anomaly %+v", users)
+	}
+
+	// Après le retour à la normale, une minute sans événement n'a pas de
+	// fenêtre: elle est comptée comme vide
+	for i := 9; i < 30; i++ {
+		minute(i, 100)
+	}
+	minute(31, 100)
+	empty := agg.Anomalies(AnomalyFilter{Since: start.Add(9 * time.Minute), Series: "events"})
+	if len(empty) != 1 || empty[0].Value != 0 || !empty[0].WindowStart.Equal(start.Add(30*time.Minute)) {
+		t.Errorf("Expected a drop to zero on the missing window, got %+v", empty)
+	}
+	if limited := agg.Anomalies(AnomalyFilter{Limit: 2}); len(limited) != 2 || limited[0].WindowStart.Before(limited[1].WindowStart) {
+		t.Errorf("Expected the 2 most recent anomalies first, got %+v", limited)
+	}
+	if stats := agg.GetStats(); stats["anomalies"] != len(agg.Anomalies(AnomalyFilter{})) {
+		t.Errorf("Unexpected anomalies stat %v", stats["anomalies"])
+	}
+
+	disabled := NewAggregator(time.Minute, time.Second, zap.NewNop())
+	if disabled.AnomaliesEnabled() || disabled.Anomalies(AnomalyFilter{}) != nil {
+		t.Error("Expected anomaly detection to be disabled by default")
+	}
+}
+
+// TestAnomalyDetectorSlidingGap teste le comptage des fenêtres manquantes
+// d'une spec glissante au pas de la spec, et non à sa taille
+func TestAnomalyDetectorSlidingGap(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	detector := NewAnomalyDetector(AnomalyOptions{Warmup: 5}, NewManualClock(start))
+
+	// Fenêtres de 5 minutes, une nouvelle chaque minute
+	window := func(i int) *TimeWindow {
+		w := NewTimeWindow(start.Add(time.Duration(i)*time.Minute), 5*time.Minute)
+		w.Spec = "sliding_5m_1m"
+		w.Metrics.GetMetric("events", MetricTypeCounter).IncrementBy(float64(500 + 50*(i%2)))
+		w.Close()
+		return w
+	}
+
+	for i := 0; i < 10; i++ {
+		if detected := detector.Observe(window(i), time.Minute); len(detected) != 0 {
+			t.Fatalf("Expected no anomaly on steady traffic, got %+v", detected)
+		}
+	}
+
+	// Trois minutes sans fenêtre: la première fenêtre manquante commence à la
+	// minute 10 et couvre la taille de la spec
+	detected := detector.Observe(window(13), time.Minute)
+	if len(detected) == 0 {
+		t.Fatal("Expected the missing windows to be counted as empty")
+	}
+	first := detected[0]
+	if first.Value != 0 || !first.WindowStart.Equal(start.Add(10*time.Minute)) || !first.WindowEnd.Equal(start.Add(15*time.Minute)) {
+		t.Errorf("Expected an empty window [10m, 15m), got %+v", first)
+	}
+}
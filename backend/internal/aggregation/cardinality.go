@@ -0,0 +1,65 @@
+package aggregation
+
+// OverflowLabelValue remplace les valeurs de labels d'une famille saturée
+const OverflowLabelValue = "__other__"
+
+// DefaultCardinalityLimit est la limite de séries par famille suggérée par la configuration
+const DefaultCardinalityLimit = 1000
+
+// CardinalityLimits borne le nombre de séries labellisées par famille (nom de
+// métrique). Une fois la limite atteinte, les nouvelles combinaisons de labels
+// sont regroupées dans la série dont tous les labels valent __other__.
+type CardinalityLimits struct {
+	Default  int            // 0: illimité
+	Families map[string]int // surcharge par famille (0: illimité)
+}
+
+// Limit retourne la limite applicable à une famille (0: illimité)
+func (c CardinalityLimits) Limit(family string) int {
+	if limit, ok := c.Families[family]; ok {
+		return limit
+	}
+	return c.Default
+}
+
+// FamilyCardinality décrit l'occupation d'une famille de métriques
+type FamilyCardinality struct {
+	Series    int   `json:"series"`    // séries labellisées distinctes (hors __other__)
+	Limit     int   `json:"limit"`     // 0: illimité
+	Overflow  int64 `json:"overflow"`  // mises à jour redirigées vers __other__
+	Saturated bool  `json:"saturated"` // limite atteinte
+}
+
+// overflowLabels retourne les mêmes noms de labels avec la valeur __other__
+func overflowLabels(labels Labels) Labels {
+	overflow := make(Labels, len(labels))
+	for k := range labels {
+		overflow[k] = OverflowLabelValue
+	}
+	return overflow
+}
+
+// isOverflow indique si la série est la série __other__ de sa famille
+func (l Labels) isOverflow() bool {
+	if len(l) == 0 {
+		return false
+	}
+	for _, v := range l {
+		if v != OverflowLabelValue {
+			return false
+		}
+	}
+	return true
+}
+
+// Cardinality retourne le nombre de séries labellisées par famille (hors __other__)
+func (ms *MetricsSnapshot) Cardinality() map[string]int {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	result := make(map[string]int, len(ms.families))
+	for family, series := range ms.families {
+		result[family] = series
+	}
+	return result
+}
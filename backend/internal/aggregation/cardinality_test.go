@@ -0,0 +1,114 @@
+package aggregation
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TestMetricsSnapshotCardinalityLimit teste le regroupement dans __other__ au-delà de la limite
+func TestMetricsSnapshotCardinalityLimit(t *testing.T) {
+	var overflows []string
+	ms := NewMetricsSnapshotWithOptions(SnapshotOptions{
+		Cardinality: CardinalityLimits{Default: 3, Families: map[string]int{"clicks": 0}},
+		OnOverflow:  func(family string) { overflows = append(overflows, family) },
+	})
+
+	for i := 0; i < 10; i++ {
+		page := fmt.Sprintf("/page/%d", i)
+		ms.GetMetricWithLabels("page_views", Labels{"page": page}, MetricTypeCounter).Increment()
+		ms.GetMetricWithLabels("clicks", Labels{"element": page}, MetricTypeCounter).Increment()
+	}
+	// Une série existante reste accessible après saturation
+	ms.GetMetricWithLabels("page_views", Labels{"page": "/page/0"}, MetricTypeCounter).Increment()
+
+	if series := ms.Query("page_views", nil); len(series) != 4 {
+		t.Fatalf("Expected 3 series plus __other__, got %d", len(series))
+	}
+	other := ms.Query("page_views", Labels{"page": OverflowLabelValue})
+	if len(other) != 1 || other[0].Value != 7 {
+		t.Fatalf("Expected 7 updates folded into __other__, got %d series", len(other))
+	}
+	if value, _ := ms.GetMetricValue(SeriesKey("page_views", Labels{"page": "/page/0"})); value != 2 {
+		t.Errorf("Expected existing series to keep counting, got %.0f", value)
+	}
+	if len(overflows) != 7 {
+		t.Errorf("Expected 7 overflow notifications, got %d", len(overflows))
+	}
+
+	// Limite 0 pour clicks: illimité
+	if series := ms.Query("clicks", nil); len(series) != 10 {
+		t.Errorf("Expected unlimited clicks family, got %d series", len(series))
+	}
+	if cardinality := ms.Cardinality(); cardinality["page_views"] != 3 || cardinality["clicks"] != 10 {
+		t.Errorf("Unexpected cardinality %v", cardinality)
+	}
+}
+
+// TestAggregatorCardinalityStats teste la visibilité des familles saturées dans GetStats
+func TestAggregatorCardinalityStats(t *testing.T) {
+	logger := zap.NewNop()
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	agg := NewAggregatorWithOptions(Options{
+		Windows:       []WindowSpec{{Kind: WindowKindTumbling, Size: 1 * time.Minute}},
+		FlushInterval: 10 * time.Second,
+		Clock:         NewManualClock(start),
+		Cardinality:   CardinalityLimits{Default: 100, Families: map[string]int{"page_views": 2}},
+	}, logger)
+
+	for i := 0; i < 5; i++ {
+		agg.ProcessEvent(Event{Type: "pageview", Timestamp: start,
+			Properties: map[string]interface{}{"page": fmt.Sprintf("/crawler/%d", i)}})
+	}
+
+	cardinality := agg.GetStats()["cardinality"].(map[string]FamilyCardinality)
+	pages := cardinality["page_views"]
+	if pages.Series != 2 || pages.Limit != 2 || pages.Overflow != 3 || !pages.Saturated {
+		t.Errorf("Unexpected page_views cardinality %+v", pages)
+	}
+	if types := cardinality["events_by_type"]; types.Series != 1 || types.Saturated {
+		t.Errorf("Unexpected events_by_type cardinality %+v", types)
+	}
+}
+
+// TestShardCardinalityLimit teste que la limite s'applique dans les deltas des
+// shards entre deux fusions et que chaque mise à jour redirigée est comptée
+func TestShardCardinalityLimit(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	agg := NewAggregatorWithOptions(Options{
+		Windows:       []WindowSpec{{Kind: WindowKindTumbling, Size: 1 * time.Minute}},
+		FlushInterval: 10 * time.Second,
+		Clock:         NewManualClock(start),
+		Shards:        4,
+		Cardinality:   CardinalityLimits{Default: 100, Families: map[string]int{"page_views": 2}},
+	}, zap.NewNop())
+
+	// Deux passes sur 10 pages: seules les deux premières ont leur série
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 10; i++ {
+			agg.ProcessEvent(Event{ID: fmt.Sprintf("e%d_%d", round, i), Type: "pageview", Timestamp: start,
+				Properties: map[string]interface{}{"page": fmt.Sprintf("/crawler/%d", i)}})
+		}
+	}
+
+	// Avant toute fusion, aucun delta ne dépasse la limite (plus __other__)
+	for i, shard := range agg.shards {
+		shard.mu.Lock()
+		series := len(shard.global.Query("page_views", nil))
+		shard.mu.Unlock()
+		if series > 3 {
+			t.Errorf("Expected shard %d to hold at most 3 page_views series, got %d", i, series)
+		}
+	}
+
+	pages := agg.GetStats()["cardinality"].(map[string]FamilyCardinality)["page_views"]
+	if pages.Series != 2 || pages.Overflow != 16 || !pages.Saturated {
+		t.Errorf("Expected 2 series and 16 folded updates, got %+v", pages)
+	}
+	other := agg.GetGlobalMetrics()[SeriesKey("page_views", Labels{"page": OverflowLabelValue})]
+	if other == nil || other.Value != 16 {
+		t.Errorf("Expected 16 page views folded into __other__, got %+v", other)
+	}
+}
@@ -0,0 +1,357 @@
+package aggregation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// CheckpointVersion est la version courante du format des checkpoints. Les
+// ajouts de champs ne changent pas la version (un champ absent d'un ancien
+// checkpoint garde sa valeur zéro); un changement incompatible l'incrémente.
+// Un checkpoint d'une autre version est refusé (ErrCheckpointVersion).
+const CheckpointVersion = 1
+
+// ErrCheckpointVersion signale un checkpoint d'une version de format non supportée
+var ErrCheckpointVersion = errors.New("unsupported checkpoint version")
+
+// Checkpoint est l'état sérialisable d'un agrégateur: métriques globales,
+// événements en retard, fenêtres temporelles (ouvertes, ou fermées mais
+// encore amendables) avec leur watermark et anneau des agrégats glissants.
+// Les sessions ouvertes, les parcours
+// de funnel, les cohortes de rétention, les transitions et les références
+// des anomalies n'en font pas partie et repartent de zéro.
+type Checkpoint struct {
+	Version    int                  `json:"version"`
+	CreatedAt  time.Time            `json:"created_at"`
+	Global     SnapshotState        `json:"global"`
+	LateEvents SnapshotState        `json:"late_events"`
+	Windows    []WindowManagerState `json:"windows"`
+	Rolling    *RollingState        `json:"rolling,omitempty"` // nil sans agrégats glissants
+	// Log est la position du journal des événements acceptés couverte par le
+	// checkpoint (nil sans journal): seuls les événements suivants sont rejoués
+	Log *LogPosition `json:"log,omitempty"`
+}
+
+// LogPosition est une position du journal des événements acceptés: toutes
+// les séquences inférieures à Next, plus celles de Done
+type LogPosition struct {
+	Next uint64   `json:"next"`
+	Done []uint64 `json:"done,omitempty"`
+}
+
+// WindowManagerState est l'état des fenêtres d'une spec
+type WindowManagerState struct {
+	Spec      string        `json:"spec"`
+	Watermark time.Time     `json:"watermark"`
+	Windows   []WindowState `json:"windows"` // triées par début
+}
+
+// WindowState est l'état d'une fenêtre temporelle
+type WindowState struct {
+	Start    time.Time     `json:"start"`
+	Duration time.Duration `json:"duration"`
+	Closed   bool          `json:"closed"`
+	Revision int           `json:"revision"`
+	Amended  bool          `json:"amended,omitempty"` // réémission en attente
+	Metrics  SnapshotState `json:"metrics"`
+}
+
+// EncodeCheckpoint sérialise un checkpoint en JSON
+func EncodeCheckpoint(checkpoint *Checkpoint) ([]byte, error) {
+	return json.Marshal(checkpoint)
+}
+
+// DecodeCheckpoint lit un checkpoint JSON; une autre version du format que
+// CheckpointVersion est refusée
+func DecodeCheckpoint(data []byte) (*Checkpoint, error) {
+	var checkpoint Checkpoint
+	if err := json.Unmarshal(data, &checkpoint); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint: %w", err)
+	}
+	if checkpoint.Version != CheckpointVersion {
+		return nil, fmt.Errorf("%w %d (expected %d)", ErrCheckpointVersion, checkpoint.Version, CheckpointVersion)
+	}
+	return &checkpoint, nil
+}
+
+// Checkpoint capture l'état de l'agrégateur. Les shards sont vidés et restent
+// verrouillés pendant la capture: le checkpoint contient exactement les
+// événements traités avant lui.
+func (a *Aggregator) Checkpoint() *Checkpoint {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lockShards()
+	defer a.unlockShards()
+
+	for _, global := range a.takeShardsLocked() {
+		if err := a.globalMetrics.Merge(global); err != nil {
+			a.logger.Error("failed to merge shard metrics", zap.Error(err))
+		}
+	}
+
+	checkpoint := &Checkpoint{
+		Version:    CheckpointVersion,
+		CreatedAt:  a.clock.Now(),
+		Global:     a.globalMetrics.State(),
+		LateEvents: a.lateEvents.State(),
+		Windows:    make([]WindowManagerState, 0, len(a.windowManagers)),
+	}
+	for _, wm := range a.windowManagers {
+		checkpoint.Windows = append(checkpoint.Windows, wm.state())
+	}
+	if a.rolling != nil {
+		checkpoint.Rolling = a.rolling.state()
+	}
+	return checkpoint
+}
+
+// Restore remplace l'état de l'agrégateur par celui d'un checkpoint. À appeler
+// au démarrage, avant de traiter des événements. Les fenêtres des specs qui
+// ne sont plus configurées sont ignorées; les specs absentes du checkpoint
+// démarrent vides, comme l'anneau glissant d'un checkpoint qui n'en a pas ou
+// d'une autre résolution. L'état que les checkpoints ne contiennent pas
+// repart de zéro et est listé dans un avertissement, pour qu'un redémarrage
+// ne passe pas pour une chute du trafic.
+func (a *Aggregator) Restore(checkpoint *Checkpoint) error {
+	if checkpoint.Version != CheckpointVersion {
+		return fmt.Errorf("%w %d (expected %d)", ErrCheckpointVersion, checkpoint.Version, CheckpointVersion)
+	}
+	global, err := NewMetricsSnapshotFromState(checkpoint.Global, a.metricOpts)
+	if err != nil {
+		return fmt.Errorf("global metrics: %w", err)
+	}
+	lateEvents := NewMetricsSnapshotWithOptions(SnapshotOptions{Clock: a.clock})
+	if checkpoint.LateEvents.Version != 0 {
+		if lateEvents, err = NewMetricsSnapshotFromState(checkpoint.LateEvents, SnapshotOptions{Clock: a.clock}); err != nil {
+			return fmt.Errorf("late events: %w", err)
+		}
+	}
+	managers := make(map[string]WindowManagerState, len(checkpoint.Windows))
+	for _, state := range checkpoint.Windows {
+		managers[state.Spec] = state
+	}
+	windows := make(map[*WindowManager][]*TimeWindow, len(a.windowManagers))
+	for _, wm := range a.windowManagers {
+		state, ok := managers[wm.Spec().Name]
+		if !ok {
+			continue
+		}
+		restored, err := wm.windowsFromState(state)
+		if err != nil {
+			return fmt.Errorf("windows %s: %w", state.Spec, err)
+		}
+		windows[wm] = restored
+	}
+	var rollingBuckets []*rollingBucket
+	var rollingSince time.Time
+	if a.rolling != nil {
+		if rollingBuckets, rollingSince, err = a.rolling.bucketsFromState(checkpoint.Rolling); err != nil {
+			return fmt.Errorf("rolling metrics: %w", err)
+		}
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.lockShards()
+	defer a.unlockShards()
+
+	// Les deltas en attente visent les fenêtres et les métriques remplacées
+	a.globalMetrics = global
+	for _, shard := range a.shards {
+		shard.takeLocked(global)
+	}
+	a.lateEvents = lateEvents
+	for _, wm := range a.windowManagers {
+		state, ok := managers[wm.Spec().Name]
+		if !ok {
+			continue
+		}
+		wm.mu.Lock()
+		wm.Windows = windows[wm]
+		wm.watermark = state.Watermark
+		wm.mu.Unlock()
+	}
+	if a.rolling != nil {
+		a.rolling.replace(rollingBuckets, rollingSince)
+	}
+
+	if reset := a.unrestoredState(); len(reset) > 0 {
+		a.logger.Warn("checkpoint restored, state not checkpointed starts over",
+			zap.Strings("state", reset),
+			zap.Time("checkpoint_created_at", checkpoint.CreatedAt),
+		)
+	}
+	return nil
+}
+
+// unrestoredState liste l'état configuré que les checkpoints ne contiennent pas
+func (a *Aggregator) unrestoredState() []string {
+	reset := make([]string, 0)
+	for _, spec := range a.windowSpecs {
+		if spec.Kind == WindowKindSession {
+			reset = append(reset, "sessions")
+			break
+		}
+	}
+	if len(a.funnels) > 0 {
+		reset = append(reset, "funnel journeys")
+	}
+	if a.retention != nil {
+		reset = append(reset, "retention cohorts")
+	}
+	if a.transitions != nil {
+		reset = append(reset, "page transitions")
+	}
+	if a.anomalies != nil {
+		reset = append(reset, "anomaly baselines")
+	}
+	if cardinality := a.metricOpts.Cardinality; cardinality.Default > 0 || len(cardinality.Families) > 0 {
+		reset = append(reset, "cardinality overflow counts")
+	}
+	return reset
+}
+
+// state retourne l'état des fenêtres du gestionnaire
+func (wm *WindowManager) state() WindowManagerState {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+
+	state := WindowManagerState{
+		Spec:      wm.spec.Name,
+		Watermark: wm.watermark,
+		Windows:   make([]WindowState, 0, len(wm.Windows)),
+	}
+	for _, window := range wm.Windows {
+		windowState := WindowState{
+			Start:    window.StartTime,
+			Duration: window.Duration,
+			Closed:   window.Closed,
+			Revision: window.Revision,
+			Amended:  window.amended,
+			Metrics:  window.Metrics.State(),
+		}
+		state.Windows = append(state.Windows, windowState)
+	}
+	sort.Slice(state.Windows, func(i, j int) bool {
+		return state.Windows[i].Start.Before(state.Windows[j].Start)
+	})
+	return state
+}
+
+// windowsFromState reconstruit les fenêtres d'un état du gestionnaire
+func (wm *WindowManager) windowsFromState(state WindowManagerState) ([]*TimeWindow, error) {
+	windows := make([]*TimeWindow, 0, len(state.Windows))
+	for _, windowState := range state.Windows {
+		if windowState.Duration != wm.duration {
+			return nil, fmt.Errorf("window at %s lasts %s, expected %s", windowState.Start, windowState.Duration, wm.duration)
+		}
+		metrics, err := NewMetricsSnapshotFromState(windowState.Metrics, wm.metrics)
+		if err != nil {
+			return nil, fmt.Errorf("window at %s: %w", windowState.Start, err)
+		}
+		window := newTimeWindow(windowState.Start, windowState.Duration, wm.metrics)
+		window.Spec = wm.spec.Name
+		window.Metrics = metrics
+		window.Closed = windowState.Closed
+		window.Revision = windowState.Revision
+		window.amended = windowState.Amended
+		windows = append(windows, window)
+	}
+	return windows, nil
+}
+
+// CheckpointStore conserve le dernier checkpoint encodé (fichier, Postgres...)
+type CheckpointStore interface {
+	SaveCheckpoint(ctx context.Context, data []byte) error
+	// LoadCheckpoint retourne nil sans erreur s'il n'y a pas encore de checkpoint
+	LoadCheckpoint(ctx context.Context) ([]byte, error)
+}
+
+// FileCheckpointStore garde le checkpoint dans un fichier local, remplacé
+// atomiquement (écriture dans un fichier temporaire puis renommage)
+type FileCheckpointStore struct {
+	path string
+}
+
+// NewFileCheckpointStore crée un store de checkpoint dans path
+func NewFileCheckpointStore(path string) *FileCheckpointStore {
+	return &FileCheckpointStore{path: path}
+}
+
+// SaveCheckpoint remplace le fichier de checkpoint
+func (s *FileCheckpointStore) SaveCheckpoint(ctx context.Context, data []byte) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create checkpoint directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create checkpoint file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to sync checkpoint: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write checkpoint: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace checkpoint: %w", err)
+	}
+	return nil
+}
+
+// LoadCheckpoint lit le fichier de checkpoint (nil s'il n'existe pas)
+func (s *FileCheckpointStore) LoadCheckpoint(ctx context.Context) ([]byte, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read checkpoint: %w", err)
+	}
+	return data, nil
+}
+
+// SaveCheckpoint capture l'état de l'agrégateur et l'enregistre dans store
+func (a *Aggregator) SaveCheckpoint(ctx context.Context, store CheckpointStore) (*Checkpoint, error) {
+	checkpoint := a.Checkpoint()
+	data, err := EncodeCheckpoint(checkpoint)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode checkpoint: %w", err)
+	}
+	if err := store.SaveCheckpoint(ctx, data); err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
+
+// RestoreCheckpoint restaure l'agrégateur depuis le checkpoint de store;
+// retourne nil sans erreur si store n'a pas encore de checkpoint
+func (a *Aggregator) RestoreCheckpoint(ctx context.Context, store CheckpointStore) (*Checkpoint, error) {
+	data, err := store.LoadCheckpoint(ctx)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	checkpoint, err := DecodeCheckpoint(data)
+	if err != nil {
+		return nil, err
+	}
+	if err := a.Restore(checkpoint); err != nil {
+		return nil, err
+	}
+	return checkpoint, nil
+}
@@ -0,0 +1,109 @@
+package aggregation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TestAggregatorCheckpointRestore teste qu'un agrégateur restauré depuis un
+// checkpoint fichier reprend les totaux, les sets et les fenêtres ouvertes
+func TestAggregatorCheckpointRestore(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	newAggregator := func(clock Clock) *Aggregator {
+		return NewAggregatorWithOptions(Options{
+			Windows: []WindowSpec{{
+				Kind:    WindowKindTumbling,
+				Size:    10 * time.Minute,
+				Metrics: []string{WindowMetricCount, WindowMetricUnique},
+			}},
+			FlushInterval:   10 * time.Second,
+			AllowedLateness: time.Minute,
+			Clock:           clock,
+			Shards:          4,
+		}, zap.NewNop())
+	}
+
+	clock := NewManualClock(start.Add(5 * time.Minute))
+	before := newAggregator(clock)
+	// 5 pages vues d'utilisateurs différents, puis 15 clics des mêmes utilisateurs
+	for i := 0; i < 20; i++ {
+		event := Event{
+			Type:      "click",
+			UserID:    fmt.Sprintf("u%d", i%5),
+			Timestamp: start.Add(time.Duration(i) * time.Second),
+		}
+		if i < 5 {
+			event.Type = "pageview"
+			event.SessionID = fmt.Sprintf("s%d", i)
+			event.Properties = map[string]interface{}{"page": "/home"}
+		}
+		before.ProcessEvent(event)
+	}
+
+	store := NewFileCheckpointStore(filepath.Join(t.TempDir(), "state", "checkpoint.json"))
+	if data, err := store.LoadCheckpoint(context.Background()); err != nil || data != nil {
+		t.Fatalf("Expected no checkpoint yet, got %d bytes (%v)", len(data), err)
+	}
+	if _, err := before.SaveCheckpoint(context.Background(), store); err != nil {
+		t.Fatalf("SaveCheckpoint: %v", err)
+	}
+
+	after := newAggregator(clock)
+	checkpoint, err := after.RestoreCheckpoint(context.Background(), store)
+	if err != nil || checkpoint == nil {
+		t.Fatalf("RestoreCheckpoint: %v", err)
+	}
+	stats := after.GetStats()
+	if stats["total_events"] != 20.0 || stats["active_windows"] != 1 {
+		t.Errorf("Unexpected restored stats %v", stats)
+	}
+	if users := after.GetGlobalMetrics()["unique_users"]; users == nil || users.Count != 5 {
+		t.Errorf("Expected 5 restored unique users, got %+v", users)
+	}
+
+	// Le watermark restauré (12:00:19) refuse les événements trop en retard
+	// (la fenêtre de 11:40 n'accepte plus rien depuis 11:51)
+	late := 0
+	after.SetLateEventCallback(func(LateEvent) { late++ })
+	after.ProcessEvent(Event{Type: "pageview", Timestamp: start.Add(-15 * time.Minute)})
+	if late != 1 {
+		t.Errorf("Expected the restored watermark to drop a late event, got %d", late)
+	}
+
+	// Les événements suivants s'ajoutent à l'état restauré, sets compris
+	after.ProcessEvent(Event{Type: "pageview", UserID: "u0", SessionID: "s0", Timestamp: start.Add(time.Minute),
+		Properties: map[string]interface{}{"page": "/pricing"}})
+	after.ProcessEvent(Event{Type: "click", UserID: "u9", Timestamp: start.Add(time.Minute)})
+
+	var closed *TimeWindow
+	after.SetWindowClosedCallback(func(window *TimeWindow) {
+		closed = window
+	})
+	clock.Set(start.Add(11 * time.Minute))
+	after.Flush()
+	if closed == nil {
+		t.Fatal("Expected the restored window to close")
+	}
+	if events, _ := closed.Metrics.GetMetricValue("events"); events != 22 {
+		t.Errorf("Expected events = 22, got %.0f", events)
+	}
+	if users := closed.Metrics.GetAllMetrics()["active_users"]; users == nil || users.Count != 6 {
+		t.Errorf("Expected 6 active users (5 restored + u9), got %+v", users)
+	}
+
+	for _, version := range []int{0, 2, 99} {
+		data := []byte(fmt.Sprintf(`{"version": %d}`, version))
+		if _, err := DecodeCheckpoint(data); !errors.Is(err, ErrCheckpointVersion) {
+			t.Errorf("Expected checkpoint version %d to be rejected, got %v", version, err)
+		}
+		if err := after.Restore(&Checkpoint{Version: version}); !errors.Is(err, ErrCheckpointVersion) {
+			t.Errorf("Expected Restore to reject version %d, got %v", version, err)
+		}
+	}
+}
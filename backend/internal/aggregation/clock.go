@@ -0,0 +1,64 @@
+package aggregation
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock fournit l'heure courante à l'agrégation (fermeture des fenêtres,
+// watermark, horodatage des métriques)
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock est l'horloge murale
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// SystemClock retourne l'horloge murale, utilisée par défaut
+func SystemClock() Clock {
+	return systemClock{}
+}
+
+// orSystemClock retourne clock, ou l'horloge murale si clock est nil
+func orSystemClock(clock Clock) Clock {
+	if clock == nil {
+		return SystemClock()
+	}
+	return clock
+}
+
+// ManualClock est une horloge pilotée à la main (tests, rejeu de données historiques)
+type ManualClock struct {
+	now time.Time
+	mu  sync.RWMutex
+}
+
+// NewManualClock crée une horloge manuelle positionnée à start
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+// Now retourne l'heure courante de l'horloge
+func (c *ManualClock) Now() time.Time {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.now
+}
+
+// Set positionne l'horloge à t
+func (c *ManualClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// Advance avance l'horloge de d
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
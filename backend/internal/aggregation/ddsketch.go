@@ -0,0 +1,249 @@
+package aggregation
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+// Paramètres par défaut des sketches de quantiles
+const (
+	DefaultSketchRelativeAccuracy = 0.01 // erreur relative garantie sur les quantiles
+	DefaultSketchMaxBins          = 2048 // nombre maximal de buckets par signe
+)
+
+// DDSketch est un sketch de quantiles à mémoire bornée et fusionnable
+// (DDSketch, Masson et al. 2019). Chaque valeur v > 0 tombe dans le bucket
+// ceil(log_gamma(v)); le quantile retourné est à RelativeAccuracy près.
+// Quand le nombre de buckets dépasse MaxBins, les plus petits sont fusionnés
+// par lot, jusqu'à laisser une marge de MaxBins/8 buckets libres; les valeurs
+// qui tombent ensuite sous le bucket plancher y sont ajoutées directement, si
+// bien qu'un ajout reste en O(1) amorti.
+// Un DDSketch n'est pas protégé contre les accès concurrents: c'est la
+// métrique qui le contient qui le verrouille.
+type DDSketch struct {
+	RelativeAccuracy float64         `json:"relative_accuracy"`
+	MaxBins          int             `json:"max_bins"`
+	Positive         map[int]float64 `json:"positive"`
+	Negative         map[int]float64 `json:"negative"`
+	ZeroCount        float64         `json:"zero_count"`
+	Count            float64         `json:"count"`
+	Sum              float64         `json:"sum"`
+	Min              float64         `json:"min"`
+	Max              float64         `json:"max"`
+
+	gamma    float64
+	logGamma float64
+
+	// Planchers posés par le dernier collapse de chaque signe
+	positiveFloor binFloor
+	negativeFloor binFloor
+}
+
+// binFloor est le plus petit index conservé après un collapse: les index
+// inférieurs sont comptés dans ce bucket
+type binFloor struct {
+	index int
+	set   bool
+}
+
+// NewDDSketch crée un sketch avec l'erreur relative et le nombre de buckets donnés
+func NewDDSketch(relativeAccuracy float64, maxBins int) *DDSketch {
+	if relativeAccuracy <= 0 || relativeAccuracy >= 1 {
+		relativeAccuracy = DefaultSketchRelativeAccuracy
+	}
+	if maxBins <= 0 {
+		maxBins = DefaultSketchMaxBins
+	}
+	s := &DDSketch{
+		RelativeAccuracy: relativeAccuracy,
+		MaxBins:          maxBins,
+		Positive:         make(map[int]float64),
+		Negative:         make(map[int]float64),
+	}
+	s.init()
+	return s
+}
+
+// init calcule les constantes dérivées de RelativeAccuracy (après décodage JSON par ex.)
+func (s *DDSketch) init() {
+	s.gamma = (1 + s.RelativeAccuracy) / (1 - s.RelativeAccuracy)
+	s.logGamma = math.Log(s.gamma)
+	if s.Positive == nil {
+		s.Positive = make(map[int]float64)
+	}
+	if s.Negative == nil {
+		s.Negative = make(map[int]float64)
+	}
+}
+
+// Add ajoute une observation
+func (s *DDSketch) Add(value float64) {
+	if s.logGamma == 0 {
+		s.init()
+	}
+
+	switch {
+	case value > 0:
+		s.addBin(s.Positive, &s.positiveFloor, s.index(value), 1)
+	case value < 0:
+		s.addBin(s.Negative, &s.negativeFloor, s.index(-value), 1)
+	default:
+		s.ZeroCount++
+	}
+
+	if s.Count == 0 || value < s.Min {
+		s.Min = value
+	}
+	if s.Count == 0 || value > s.Max {
+		s.Max = value
+	}
+	s.Count++
+	s.Sum += value
+}
+
+// Merge ajoute les observations d'un autre sketch de même précision
+func (s *DDSketch) Merge(other *DDSketch) error {
+	if other == nil || other.Count == 0 {
+		return nil
+	}
+	if s.RelativeAccuracy != other.RelativeAccuracy {
+		return fmt.Errorf("cannot merge sketches with different accuracy (%g vs %g)",
+			s.RelativeAccuracy, other.RelativeAccuracy)
+	}
+	if s.logGamma == 0 {
+		s.init()
+	}
+
+	for idx, count := range other.Positive {
+		s.addBin(s.Positive, &s.positiveFloor, idx, count)
+	}
+	for idx, count := range other.Negative {
+		s.addBin(s.Negative, &s.negativeFloor, idx, count)
+	}
+
+	if s.Count == 0 || other.Min < s.Min {
+		s.Min = other.Min
+	}
+	if s.Count == 0 || other.Max > s.Max {
+		s.Max = other.Max
+	}
+	s.ZeroCount += other.ZeroCount
+	s.Count += other.Count
+	s.Sum += other.Sum
+	return nil
+}
+
+// Quantile retourne une estimation du quantile q (0..1), 0 si le sketch est vide
+func (s *DDSketch) Quantile(q float64) float64 {
+	if s.Count == 0 {
+		return 0
+	}
+	if q <= 0 {
+		return s.Min
+	}
+	if q >= 1 {
+		return s.Max
+	}
+	if s.logGamma == 0 {
+		s.init()
+	}
+
+	// Rang le plus proche: plus petite valeur dont la fraction cumulée atteint q
+	rank := q * s.Count
+	var seen float64
+
+	// Valeurs négatives: de la plus négative (plus grand index) à la plus proche de zéro
+	negKeys := sortedKeys(s.Negative)
+	for i := len(negKeys) - 1; i >= 0; i-- {
+		seen += s.Negative[negKeys[i]]
+		if seen >= rank {
+			return s.clamp(-s.value(negKeys[i]))
+		}
+	}
+
+	seen += s.ZeroCount
+	if seen >= rank {
+		return 0
+	}
+
+	for _, idx := range sortedKeys(s.Positive) {
+		seen += s.Positive[idx]
+		if seen >= rank {
+			return s.clamp(s.value(idx))
+		}
+	}
+	return s.Max
+}
+
+// Clone retourne une copie indépendante du sketch
+func (s *DDSketch) Clone() *DDSketch {
+	c := *s
+	c.Positive = make(map[int]float64, len(s.Positive))
+	for idx, count := range s.Positive {
+		c.Positive[idx] = count
+	}
+	c.Negative = make(map[int]float64, len(s.Negative))
+	for idx, count := range s.Negative {
+		c.Negative[idx] = count
+	}
+	return &c
+}
+
+// index retourne le bucket d'une valeur strictement positive
+func (s *DDSketch) index(value float64) int {
+	return int(math.Ceil(math.Log(value) / s.logGamma))
+}
+
+// value retourne la valeur représentative d'un bucket
+func (s *DDSketch) value(index int) float64 {
+	return 2 * math.Pow(s.gamma, float64(index)) / (s.gamma + 1)
+}
+
+// clamp ramène une estimation dans [Min, Max]
+func (s *DDSketch) clamp(v float64) float64 {
+	return math.Max(s.Min, math.Min(s.Max, v))
+}
+
+// addBin ajoute count au bucket idx (ou au plancher s'il est inférieur) et
+// fusionne les plus petits buckets par lot quand MaxBins est dépassé
+func (s *DDSketch) addBin(bins map[int]float64, floor *binFloor, idx int, count float64) {
+	if floor.set && idx < floor.index {
+		idx = floor.index
+	}
+	bins[idx] += count
+
+	if len(bins) > s.MaxBins {
+		floor.index, floor.set = s.collapse(bins), true
+	}
+}
+
+// collapse fusionne les plus petits buckets pour n'en garder que MaxBins
+// moins la marge, et retourne l'index du bucket qui les a reçus
+func (s *DDSketch) collapse(bins map[int]float64) int {
+	slack := s.MaxBins / 8
+	if slack >= s.MaxBins {
+		slack = s.MaxBins - 1
+	}
+	keys := sortedKeys(bins)
+	excess := len(keys) - (s.MaxBins - slack)
+	if excess <= 0 {
+		return keys[0]
+	}
+	target := keys[excess]
+	for _, idx := range keys[:excess] {
+		bins[target] += bins[idx]
+		delete(bins, idx)
+	}
+	return target
+}
+
+// sortedKeys retourne les index d'une map de buckets par ordre croissant
+func sortedKeys(bins map[int]float64) []int {
+	keys := make([]int, 0, len(bins))
+	for idx := range bins {
+		keys = append(keys, idx)
+	}
+	sort.Ints(keys)
+	return keys
+}
@@ -0,0 +1,126 @@
+package aggregation
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+)
+
+// TestDDSketchAccuracy teste l'erreur relative des quantiles
+func TestDDSketchAccuracy(t *testing.T) {
+	sketch := NewDDSketch(0.01, DefaultSketchMaxBins)
+	for i := 1; i <= 10000; i++ {
+		sketch.Add(float64(i))
+	}
+
+	for _, q := range []float64{0.5, 0.9, 0.95, 0.99} {
+		expected := q * 10000
+		got := sketch.Quantile(q)
+		if math.Abs(got-expected)/expected > 0.011 {
+			t.Errorf("q=%.2f: expected ~%.0f, got %.2f", q, expected, got)
+		}
+	}
+
+	if sketch.Min != 1 || sketch.Max != 10000 {
+		t.Errorf("Expected min 1 and max 10000, got %.0f and %.0f", sketch.Min, sketch.Max)
+	}
+}
+
+// TestDDSketchMerge teste la fusion de deux sketches
+func TestDDSketchMerge(t *testing.T) {
+	low := NewDDSketch(0.01, DefaultSketchMaxBins)
+	high := NewDDSketch(0.01, DefaultSketchMaxBins)
+	for i := 1; i <= 500; i++ {
+		low.Add(float64(i))
+		high.Add(float64(i + 500))
+	}
+
+	if err := low.Merge(high); err != nil {
+		t.Fatalf("Unexpected merge error: %v", err)
+	}
+	if low.Count != 1000 || low.Max != 1000 {
+		t.Errorf("Expected count 1000 and max 1000, got %.0f and %.0f", low.Count, low.Max)
+	}
+	if p50 := low.Quantile(0.5); math.Abs(p50-500)/500 > 0.011 {
+		t.Errorf("Expected merged p50 ~500, got %.2f", p50)
+	}
+
+	if err := low.Merge(NewDDSketch(0.05, DefaultSketchMaxBins)); err != nil {
+		t.Errorf("Expected merging an empty sketch to be a no-op, got %v", err)
+	}
+	other := NewDDSketch(0.05, DefaultSketchMaxBins)
+	other.Add(1)
+	if err := low.Merge(other); err == nil {
+		t.Error("Expected error when merging sketches with different accuracy")
+	}
+}
+
+// TestDDSketchBoundedMemory teste que le nombre de buckets reste borné
+func TestDDSketchBoundedMemory(t *testing.T) {
+	sketch := NewDDSketch(0.01, 64)
+	value := 1e-6
+	for i := 0; i < 5000; i++ {
+		sketch.Add(value)
+		sketch.Add(-value)
+		value *= 1.05
+	}
+
+	if len(sketch.Positive) > 64 || len(sketch.Negative) > 64 {
+		t.Errorf("Expected at most 64 bins, got %d/%d", len(sketch.Positive), len(sketch.Negative))
+	}
+	if sketch.Quantile(1) != sketch.Max {
+		t.Error("Expected q=1 to return the max")
+	}
+
+	// Les collapses se font par lot: 5000 nouveaux buckets n'en déclenchent
+	// qu'une fraction, et les hauts quantiles restent précis
+	collapses := 0
+	sketch = NewDDSketch(0.01, 64)
+	value = 1
+	for i := 0; i < 5000; i++ {
+		before := sketch.positiveFloor
+		sketch.Add(value)
+		if sketch.positiveFloor != before {
+			collapses++
+		}
+		value *= 1.05
+	}
+	if collapses > 5000/8 {
+		t.Errorf("Expected batched collapses, got %d", collapses)
+	}
+	if got, want := sketch.Quantile(0.999), math.Pow(1.05, 4994); math.Abs(got-want)/want > 0.011 {
+		t.Errorf("Expected p99.9 ~%.3g, got %.3g", want, got)
+	}
+}
+
+// TestMetricHistogramJSON teste l'exposition des percentiles d'un histogramme
+func TestMetricHistogramJSON(t *testing.T) {
+	metric := NewMetric("revenue_histogram", MetricTypeHistogram)
+	for i := 1; i <= 100; i++ {
+		metric.Observe(float64(i))
+	}
+
+	data, err := json.Marshal(metric)
+	if err != nil {
+		t.Fatalf("Unexpected marshal error: %v", err)
+	}
+
+	var decoded struct {
+		Name        string             `json:"name"`
+		Min         float64            `json:"min"`
+		Max         float64            `json:"max"`
+		Percentiles map[string]float64 `json:"percentiles"`
+	}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unexpected unmarshal error: %v", err)
+	}
+
+	if decoded.Name != "revenue_histogram" || decoded.Min != 1 || decoded.Max != 100 {
+		t.Errorf("Unexpected histogram summary: %s", data)
+	}
+	for _, name := range []string{"p50", "p90", "p95", "p99"} {
+		if _, ok := decoded.Percentiles[name]; !ok {
+			t.Errorf("Expected %s in percentiles: %s", name, data)
+		}
+	}
+}
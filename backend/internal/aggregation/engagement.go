@@ -0,0 +1,99 @@
+package aggregation
+
+import (
+	"time"
+)
+
+// Métriques d'engagement des sessions terminées dans une fenêtre (métrique de
+// spec "engagement")
+const (
+	EngagementMetricSessions    = "sessions"              // sessions terminées dans la fenêtre
+	EngagementMetricDuration    = "session_duration"      // durée des sessions en secondes (histogramme)
+	EngagementMetricDurationAvg = "session_duration_avg"  // durée moyenne
+	EngagementMetricDurationP50 = "session_duration_p50"  // durée médiane
+	EngagementMetricDurationP95 = "session_duration_p95"  // 95e percentile de la durée
+	EngagementMetricPages       = "pages_per_session"     // pages vues par session (histogramme)
+	EngagementMetricPagesAvg    = "pages_per_session_avg" // pages vues par session en moyenne
+	EngagementMetricBounces     = "bounces"               // sessions d'une seule page vue
+	EngagementMetricBounceRate  = "bounce_rate"           // bounces / sessions
+	EngagementMetricEntryPages  = "entry_pages"           // première page vue des sessions (topk)
+	EngagementMetricExitPages   = "exit_pages"            // dernière page vue des sessions (topk)
+)
+
+// sessionSummary résume les événements d'une session, suivie par son
+// SessionManager: bornes, comptes, pages d'entrée et de sortie
+type sessionSummary struct {
+	first, last time.Time
+	events      int
+	pageviews   int
+	entry       string
+	entryAt     time.Time
+	exit        string
+	exitAt      time.Time
+}
+
+// add ajoute un événement au résumé (un événement en retard peut précéder les autres)
+func (s *sessionSummary) add(event Event) {
+	t := event.Timestamp
+	if s.events == 0 || t.Before(s.first) {
+		s.first = t
+	}
+	if s.events == 0 || t.After(s.last) {
+		s.last = t
+	}
+	s.events++
+
+	if event.Type != "pageview" {
+		return
+	}
+	page, _ := eventPage(event)
+	if s.pageviews == 0 || t.Before(s.entryAt) {
+		s.entry, s.entryAt = page, t
+	}
+	if s.pageviews == 0 || !t.Before(s.exitAt) {
+		s.exit, s.exitAt = page, t
+	}
+	s.pageviews++
+}
+
+// addSessionEngagement ajoute une session terminée aux séries d'engagement
+// d'une fenêtre; les gauges sont dérivées à la fermeture (deriveEngagementRates)
+func addSessionEngagement(metrics *MetricsSnapshot, session *sessionSummary) {
+	metrics.GetMetric(EngagementMetricSessions, MetricTypeCounter).Increment()
+	metrics.GetMetric(EngagementMetricDuration, MetricTypeHistogram).Observe(session.last.Sub(session.first).Seconds())
+	metrics.GetMetric(EngagementMetricPages, MetricTypeHistogram).Observe(float64(session.pageviews))
+
+	bounces := metrics.GetMetric(EngagementMetricBounces, MetricTypeCounter)
+	if session.pageviews == 1 {
+		bounces.Increment()
+	}
+	if session.pageviews > 0 && session.entry != "" {
+		metrics.GetMetric(EngagementMetricEntryPages, MetricTypeTopK).AddTopK(session.entry)
+	}
+	if session.pageviews > 0 && session.exit != "" {
+		metrics.GetMetric(EngagementMetricExitPages, MetricTypeTopK).AddTopK(session.exit)
+	}
+}
+
+// deriveEngagementRates calcule les gauges d'engagement d'une fenêtre, ou d'un
+// snapshot qui en fusionne plusieurs (voir MergeWindows), à partir des
+// compteurs et des histogrammes. Chaque session n'est comptée que dans la
+// fenêtre où elle se termine: la fusion ne compte pas deux fois une session.
+func deriveEngagementRates(snapshot *MetricsSnapshot) {
+	sessions, _ := snapshot.GetMetricValue(EngagementMetricSessions)
+	if sessions == 0 {
+		return
+	}
+	bounces, _ := snapshot.GetMetricValue(EngagementMetricBounces)
+	snapshot.GetMetric(EngagementMetricBounceRate, MetricTypeGauge).Set(bounces / sessions)
+
+	metrics := snapshot.GetAllMetrics()
+	if durations, ok := metrics[EngagementMetricDuration]; ok {
+		snapshot.GetMetric(EngagementMetricDurationAvg, MetricTypeGauge).Set(durations.Average())
+		snapshot.GetMetric(EngagementMetricDurationP50, MetricTypeGauge).Set(durations.Quantile(0.5))
+		snapshot.GetMetric(EngagementMetricDurationP95, MetricTypeGauge).Set(durations.Quantile(0.95))
+	}
+	if pages, ok := metrics[EngagementMetricPages]; ok {
+		snapshot.GetMetric(EngagementMetricPagesAvg, MetricTypeGauge).Set(pages.Average())
+	}
+}
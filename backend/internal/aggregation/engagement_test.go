@@ -0,0 +1,129 @@
+package aggregation
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TestAggregatorSessionEngagement teste durée, pages par session, rebond et
+// pages d'entrée/sortie des sessions terminées dans une fenêtre, puis
+// l'amendement de la fenêtre par une session terminée après sa fermeture
+func TestAggregatorSessionEngagement(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start.Add(6 * time.Minute))
+	agg := NewAggregatorWithOptions(Options{
+		Windows: []WindowSpec{
+			{
+				Kind:    WindowKindTumbling,
+				Size:    10 * time.Minute,
+				Metrics: []string{WindowMetricCount, WindowMetricEngagement},
+			},
+			{Kind: WindowKindSession, Gap: 5 * time.Minute},
+		},
+		FlushInterval:   10 * time.Second,
+		AllowedLateness: 5 * time.Minute,
+		Clock:           clock,
+		Shards:          4,
+	}, zap.NewNop())
+
+	var emitted []*TimeWindow
+	agg.SetWindowClosedCallback(func(window *TimeWindow) {
+		if window.Key == "" {
+			emitted = append(emitted, window)
+		}
+	})
+
+	event := func(session, eventType, page string, offset time.Duration) Event {
+		e := Event{Type: eventType, SessionID: session, Timestamp: start.Add(offset)}
+		if session != "" {
+			e.UserID = "u_" + session
+		}
+		if page != "" {
+			e.Properties = map[string]interface{}{"page": page}
+		}
+		return e
+	}
+
+	for _, e := range []Event{
+		event("s1", "pageview", "/home", 0),
+		event("s1", "click", "", 120*time.Second),
+		event("s1", "pageview", "/pricing", 60*time.Second),
+		event("s2", "pageview", "/home", 30*time.Second),
+		event("s3", "pageview", "/home", 150*time.Second),
+		event("s3", "pageview", "/blog", 10*time.Second),
+		event("s3", "pageview", "/checkout", 250*time.Second),
+		event("s4", "click", "", 5*time.Second),
+		event("", "pageview", "/anonymous", 5*time.Second), // sans session: ignoré
+	} {
+		agg.ProcessEvent(e)
+	}
+
+	// Toutes les sessions se terminent (dernier événement + 5m) avant 12:10:
+	// elles sont fermées avant la fenêtre, qui est émise une seule fois
+	clock.Set(start.Add(10*time.Minute + time.Second))
+	agg.Flush()
+	if len(emitted) != 1 {
+		t.Fatalf("Expected 1 closed window, got %d", len(emitted))
+	}
+
+	value := func(window *TimeWindow, name string) float64 {
+		v, ok := window.Metrics.GetMetricValue(name)
+		if !ok {
+			t.Fatalf("Missing %s", name)
+		}
+		return v
+	}
+	window := emitted[0]
+	checks := map[string]float64{
+		EngagementMetricSessions:    4,
+		EngagementMetricBounces:     1,
+		EngagementMetricBounceRate:  0.25,
+		EngagementMetricDurationAvg: 90,  // (120 + 0 + 240 + 0) / 4
+		EngagementMetricPagesAvg:    1.5, // (2 + 1 + 3 + 0) / 4
+	}
+	for name, want := range checks {
+		if got := value(window, name); got != want {
+			t.Errorf("Expected %s = %.2f, got %.2f", name, want, got)
+		}
+	}
+	if p95 := value(window, EngagementMetricDurationP95); math.Abs(p95-240) > 240*0.02 {
+		t.Errorf("Expected a p95 duration close to 240s, got %.2f", p95)
+	}
+
+	metrics := window.Metrics.GetAllMetrics()
+	if top := metrics[EngagementMetricEntryPages].Top(1); top[0].Value != "/home" || top[0].Count != 2 {
+		t.Errorf("Expected /home as top entry page (s1, s2), got %+v", top)
+	}
+	exits := make(map[string]int64)
+	for _, entry := range metrics[EngagementMetricExitPages].Top(10) {
+		exits[entry.Value] = entry.Count
+	}
+	if exits["/pricing"] != 1 || exits["/home"] != 1 || exits["/checkout"] != 1 || len(exits) != 3 {
+		t.Errorf("Unexpected exit pages %v", exits)
+	}
+	if duration := metrics[EngagementMetricDuration]; duration.Count != 4 || duration.Max() != 240 {
+		t.Errorf("Expected 4 session durations up to 240s, got %d up to %.0f", duration.Count, duration.Max())
+	}
+
+	// Une session en retard (une page vue à 12:04) se termine à 12:09, dans la
+	// fenêtre déjà fermée: celle-ci est amendée et ses gauges recalculées
+	clock.Advance(10 * time.Second)
+	agg.ProcessEvent(event("s5", "pageview", "/about", 4*time.Minute))
+	agg.Flush()
+	if len(emitted) != 2 || emitted[1].Revision != 1 {
+		t.Fatalf("Expected the window to be re-emitted as amended, got %d emissions", len(emitted))
+	}
+	amended := emitted[1]
+	if sessions, bounces := value(amended, EngagementMetricSessions), value(amended, EngagementMetricBounces); sessions != 5 || bounces != 2 {
+		t.Errorf("Expected 5 sessions and 2 bounces after amendment, got %.0f and %.0f", sessions, bounces)
+	}
+	if rate := value(amended, EngagementMetricBounceRate); rate != 0.4 {
+		t.Errorf("Expected the bounce rate recomputed to 0.4, got %.2f", rate)
+	}
+	if count := amended.Metrics.GetAllMetrics()[EngagementMetricDuration].Count; count != 5 {
+		t.Errorf("Expected 5 session durations, got %d", count)
+	}
+}
@@ -0,0 +1,289 @@
+package aggregation
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Métriques des funnels, labellisées par funnel et step (1 à n)
+const (
+	FunnelMetricStep          = "funnel_step"           // clés ayant atteint l'étape (compteur)
+	FunnelMetricStepSeconds   = "funnel_step_seconds"   // délai depuis l'étape précédente (histogramme)
+	FunnelMetricConversion    = "funnel_conversion"     // étape / étape précédente, calculée à la fermeture
+	FunnelMetricMedianSeconds = "funnel_median_seconds" // médiane de funnel_step_seconds, calculée à la fermeture
+)
+
+// FunnelKey est le champ qui identifie un parcours
+type FunnelKey string
+
+const (
+	FunnelByUser    FunnelKey = "user"    // UserID (défaut)
+	FunnelBySession FunnelKey = "session" // SessionID
+)
+
+// funnelStripes est le nombre de verrous des parcours d'un funnel
+const funnelStripes = 32
+
+// FunnelStep est une étape d'un funnel: un type d'événement et des conditions
+// sur ses champs, comme pour une MetricRule
+type FunnelStep struct {
+	Name      string          `json:"name,omitempty"` // défaut: EventType
+	EventType string          `json:"event_type"`
+	Where     []RuleCondition `json:"where,omitempty"`
+}
+
+// Funnel est une suite ordonnée d'étapes, par exemple pageview /pricing ->
+// click signup -> purchase. Un parcours commence à la première étape et doit
+// atteindre chaque étape suivante, dans l'ordre, moins de Window après son début.
+type Funnel struct {
+	Name   string        `json:"name"`
+	Steps  []FunnelStep  `json:"steps"`
+	Window time.Duration `json:"window"`
+	By     FunnelKey     `json:"by,omitempty"`
+}
+
+// Validate vérifie qu'un funnel est utilisable
+func (f Funnel) Validate() error {
+	if f.Name == "" {
+		return fmt.Errorf("funnel: name is required")
+	}
+	if len(f.Steps) < 2 {
+		return fmt.Errorf("funnel %s: at least two steps are required", f.Name)
+	}
+	if f.Window <= 0 {
+		return fmt.Errorf("funnel %s: conversion window must be positive", f.Name)
+	}
+	switch f.By {
+	case "", FunnelByUser, FunnelBySession:
+	default:
+		return fmt.Errorf("funnel %s: unknown key %q", f.Name, f.By)
+	}
+	for i, step := range f.Steps {
+		if step.EventType == "" {
+			return fmt.Errorf("funnel %s: step %d: event type is required", f.Name, i+1)
+		}
+		for _, cond := range step.Where {
+			if cond.Property == "" {
+				return fmt.Errorf("funnel %s: step %d: condition without property", f.Name, i+1)
+			}
+		}
+	}
+	return nil
+}
+
+// key retourne l'identifiant du parcours d'un événement (vide: pas de parcours)
+func (f Funnel) key(event Event) string {
+	if f.By == FunnelBySession {
+		return event.SessionID
+	}
+	return event.UserID
+}
+
+// label retourne le nom affiché de l'étape
+func (s FunnelStep) label() string {
+	if s.Name != "" {
+		return s.Name
+	}
+	return s.EventType
+}
+
+// matches indique si l'événement correspond à l'étape
+func (s FunnelStep) matches(event Event) bool {
+	return MetricRule{EventType: s.EventType, Where: s.Where}.matches(event)
+}
+
+// funnelLabels retourne les labels des séries d'une étape
+func funnelLabels(funnel string, step int) Labels {
+	return Labels{"funnel": funnel, "step": strconv.Itoa(step)}
+}
+
+// funnelProgress est le passage d'un parcours à une étape
+type funnelProgress struct {
+	funnel string
+	step   int           // 1 à n
+	delay  time.Duration // depuis l'étape précédente (0 pour la première)
+}
+
+// record compte le passage dans les métriques de funnel de snapshot
+func (p funnelProgress) record(snapshot *MetricsSnapshot) {
+	labels := funnelLabels(p.funnel, p.step)
+	snapshot.GetMetricWithLabels(FunnelMetricStep, labels, MetricTypeCounter).Increment()
+	if p.step > 1 {
+		snapshot.GetMetricWithLabels(FunnelMetricStepSeconds, labels, MetricTypeHistogram).Observe(p.delay.Seconds())
+	}
+}
+
+// funnelState est un parcours en cours
+type funnelState struct {
+	step  int       // dernière étape atteinte
+	start time.Time // entrée dans le funnel
+	last  time.Time // dernière étape atteinte
+}
+
+// funnelTracker suit les parcours en cours d'un funnel. Les parcours sont
+// répartis sur plusieurs verrous: un funnel par utilisateur peut recevoir des
+// événements de plusieurs shards de l'agrégateur.
+type funnelTracker struct {
+	funnel  Funnel
+	stripes [funnelStripes]struct {
+		mu     sync.Mutex
+		states map[string]*funnelState
+	}
+}
+
+// newFunnelTracker crée le suivi d'un funnel validé
+func newFunnelTracker(funnel Funnel) *funnelTracker {
+	t := &funnelTracker{funnel: funnel}
+	for i := range t.stripes {
+		t.stripes[i].states = make(map[string]*funnelState)
+	}
+	return t
+}
+
+// track fait avancer le parcours de l'événement. Un événement fait passer au
+// plus une étape; un événement plus ancien que la dernière étape est ignoré.
+// Un parcours expiré est abandonné et peut recommencer à la première étape.
+func (t *funnelTracker) track(event Event) (funnelProgress, bool) {
+	key := t.funnel.key(event)
+	if key == "" {
+		return funnelProgress{}, false
+	}
+
+	stripe := &t.stripes[hashString(key)%funnelStripes]
+	stripe.mu.Lock()
+	defer stripe.mu.Unlock()
+
+	steps := t.funnel.Steps
+	ts := event.Timestamp
+	state := stripe.states[key]
+	if state != nil && ts.Sub(state.start) > t.funnel.Window {
+		delete(stripe.states, key)
+		state = nil
+	}
+
+	if state != nil {
+		if ts.Before(state.last) || !steps[state.step].matches(event) {
+			return funnelProgress{}, false
+		}
+		progress := funnelProgress{funnel: t.funnel.Name, step: state.step + 1, delay: ts.Sub(state.last)}
+		state.step++
+		state.last = ts
+		if state.step == len(steps) {
+			delete(stripe.states, key) // parcours complet
+		}
+		return progress, true
+	}
+
+	if !steps[0].matches(event) {
+		return funnelProgress{}, false
+	}
+	stripe.states[key] = &funnelState{step: 1, start: ts, last: ts}
+	return funnelProgress{funnel: t.funnel.Name, step: 1}, true
+}
+
+// expire abandonne les parcours commencés plus de Window avant now
+func (t *funnelTracker) expire(now time.Time) {
+	for i := range t.stripes {
+		stripe := &t.stripes[i]
+		stripe.mu.Lock()
+		for key, state := range stripe.states {
+			if now.Sub(state.start) > t.funnel.Window {
+				delete(stripe.states, key)
+			}
+		}
+		stripe.mu.Unlock()
+	}
+}
+
+// inProgress retourne le nombre de parcours en cours
+func (t *funnelTracker) inProgress() int {
+	count := 0
+	for i := range t.stripes {
+		stripe := &t.stripes[i]
+		stripe.mu.Lock()
+		count += len(stripe.states)
+		stripe.mu.Unlock()
+	}
+	return count
+}
+
+// FunnelStepResult résume une étape d'un funnel
+type FunnelStepResult struct {
+	Step              int      `json:"step"`
+	Name              string   `json:"name"`
+	Count             int64    `json:"count"`
+	Conversion        *float64 `json:"conversion,omitempty"`         // depuis l'étape précédente
+	OverallConversion *float64 `json:"overall_conversion,omitempty"` // depuis la première étape
+	MedianSeconds     *float64 `json:"median_seconds,omitempty"`     // délai médian depuis l'étape précédente
+}
+
+// FunnelResult résume un funnel sur un snapshot (global ou fenêtre)
+type FunnelResult struct {
+	Name                    string             `json:"name"`
+	By                      FunnelKey          `json:"by"`
+	ConversionWindowSeconds float64            `json:"conversion_window_seconds"`
+	Steps                   []FunnelStepResult `json:"steps"`
+}
+
+// funnelResult calcule les comptes, conversions et délais médians d'un funnel
+// à partir des métriques de snapshot
+func funnelResult(snapshot *MetricsSnapshot, funnel Funnel) FunnelResult {
+	result := FunnelResult{
+		Name:                    funnel.Name,
+		By:                      funnel.By,
+		ConversionWindowSeconds: funnel.Window.Seconds(),
+		Steps:                   make([]FunnelStepResult, 0, len(funnel.Steps)),
+	}
+	if result.By == "" {
+		result.By = FunnelByUser
+	}
+
+	metrics := snapshot.GetAllMetrics()
+	var first, previous int64
+	for i, step := range funnel.Steps {
+		labels := funnelLabels(funnel.Name, i+1)
+		stepResult := FunnelStepResult{Step: i + 1, Name: step.label()}
+		if counter, ok := metrics[SeriesKey(FunnelMetricStep, labels)]; ok {
+			stepResult.Count = int64(counter.Summary().Value)
+		}
+
+		if i == 0 {
+			first = stepResult.Count
+		} else {
+			if previous > 0 {
+				conversion := float64(stepResult.Count) / float64(previous)
+				stepResult.Conversion = &conversion
+			}
+			if first > 0 {
+				overall := float64(stepResult.Count) / float64(first)
+				stepResult.OverallConversion = &overall
+			}
+			if hist, ok := metrics[SeriesKey(FunnelMetricStepSeconds, labels)]; ok {
+				median := hist.Quantile(0.5)
+				stepResult.MedianSeconds = &median
+			}
+		}
+		previous = stepResult.Count
+		result.Steps = append(result.Steps, stepResult)
+	}
+	return result
+}
+
+// finalizeFunnels ajoute à une fenêtre qui se ferme les gauges de conversion
+// et de délai médian de chaque étape
+func finalizeFunnels(window *TimeWindow, funnels []Funnel) {
+	for _, funnel := range funnels {
+		result := funnelResult(window.Metrics, funnel)
+		for _, step := range result.Steps[1:] {
+			labels := funnelLabels(funnel.Name, step.Step)
+			if step.Conversion != nil {
+				window.Metrics.GetMetricWithLabels(FunnelMetricConversion, labels, MetricTypeGauge).Set(*step.Conversion)
+			}
+			if step.MedianSeconds != nil {
+				window.Metrics.GetMetricWithLabels(FunnelMetricMedianSeconds, labels, MetricTypeGauge).Set(*step.MedianSeconds)
+			}
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package aggregation
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// pricingFunnel est le funnel pageview /pricing -> click signup -> purchase
+func pricingFunnel(by FunnelKey) Funnel {
+	return Funnel{
+		Name:   "signup",
+		By:     by,
+		Window: 30 * time.Minute,
+		Steps: []FunnelStep{
+			{Name: "pricing", EventType: "pageview", Where: []RuleCondition{{Property: "page", Equals: "/pricing"}}},
+			{Name: "signup", EventType: "click", Where: []RuleCondition{{Property: "element", Equals: "signup"}}},
+			{Name: "purchase", EventType: "purchase"},
+		},
+	}
+}
+
+// funnelEvent crée un événement d'étape pour un utilisateur
+func funnelEvent(user string, at time.Time, step int) Event {
+	event := Event{UserID: user, SessionID: "s_" + user, Timestamp: at}
+	switch step {
+	case 1:
+		event.Type, event.Properties = "pageview", map[string]interface{}{"page": "/pricing"}
+	case 2:
+		event.Type, event.Properties = "click", map[string]interface{}{"element": "signup"}
+	default:
+		event.Type, event.Properties = "purchase", map[string]interface{}{"amount": 10.0}
+	}
+	return event
+}
+
+// TestFunnelTrackerProgression teste l'ordre des étapes et la fenêtre de conversion
+func TestFunnelTrackerProgression(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tracker := newFunnelTracker(pricingFunnel(FunnelByUser))
+
+	// Une étape hors ordre n'ouvre pas de parcours
+	if _, ok := tracker.track(funnelEvent("u1", start, 2)); ok {
+		t.Error("Expected step 2 without step 1 to be ignored")
+	}
+	// Une autre page ne correspond pas à la première étape
+	other := funnelEvent("u1", start, 1)
+	other.Properties = map[string]interface{}{"page": "/home"}
+	if _, ok := tracker.track(other); ok {
+		t.Error("Expected a pageview of another page to be ignored")
+	}
+
+	steps := []struct {
+		offset time.Duration
+		step   int
+		want   int
+		delay  time.Duration
+	}{
+		{0, 1, 1, 0},
+		{2 * time.Minute, 3, 0, 0}, // purchase avant le signup: ignoré
+		{3 * time.Minute, 2, 2, 3 * time.Minute},
+		{10 * time.Minute, 3, 3, 7 * time.Minute},
+	}
+	for _, s := range steps {
+		progress, ok := tracker.track(funnelEvent("u1", start.Add(s.offset), s.step))
+		if s.want == 0 {
+			if ok {
+				t.Errorf("Expected event at +%s to be ignored, got step %d", s.offset, progress.step)
+			}
+			continue
+		}
+		if !ok || progress.step != s.want || progress.delay != s.delay {
+			t.Errorf("At +%s expected step %d after %s, got %+v (%v)", s.offset, s.want, s.delay, progress, ok)
+		}
+	}
+	if tracker.inProgress() != 0 {
+		t.Errorf("Expected completed journey to be removed, got %d in progress", tracker.inProgress())
+	}
+
+	// Au-delà de la fenêtre de conversion, le parcours recommence
+	tracker.track(funnelEvent("u2", start, 1))
+	if _, ok := tracker.track(funnelEvent("u2", start.Add(31*time.Minute), 2)); ok {
+		t.Error("Expected step 2 after the conversion window to be ignored")
+	}
+	if progress, ok := tracker.track(funnelEvent("u2", start.Add(32*time.Minute), 1)); !ok || progress.step != 1 {
+		t.Errorf("Expected a new journey after expiry, got %+v", progress)
+	}
+	tracker.expire(start.Add(2 * time.Hour))
+	if tracker.inProgress() != 0 {
+		t.Errorf("Expected expired journeys to be dropped, got %d", tracker.inProgress())
+	}
+
+	if err := (Funnel{Name: "x", Window: time.Minute, Steps: []FunnelStep{{EventType: "a"}}}).Validate(); err == nil {
+		t.Error("Expected an error for a single step funnel")
+	}
+	if err := (Funnel{Name: "x", By: "device", Window: time.Minute, Steps: pricingFunnel("").Steps}).Validate(); err == nil {
+		t.Error("Expected an error for an unknown funnel key")
+	}
+}
+
+// TestAggregatorFunnels teste les comptes, conversions et délais médians par fenêtre et globaux
+func TestAggregatorFunnels(t *testing.T) {
+	logger := zap.NewNop()
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+
+	agg := NewAggregatorWithOptions(Options{
+		Windows:       []WindowSpec{{Kind: WindowKindTumbling, Size: 10 * time.Minute}},
+		FlushInterval: 10 * time.Second,
+		Clock:         clock,
+		Shards:        4,
+		Funnels:       []Funnel{pricingFunnel(FunnelByUser), pricingFunnel("")}, // doublon ignoré
+	}, logger)
+
+	var closed []*TimeWindow
+	agg.SetWindowClosedCallback(func(window *TimeWindow) {
+		closed = append(closed, window)
+	})
+
+	// 10 utilisateurs voient /pricing, 6 cliquent signup (après 1 à 6 minutes), 3 achètent
+	for i := 0; i < 10; i++ {
+		user := fmt.Sprintf("user_%d", i)
+		agg.ProcessEvent(funnelEvent(user, start, 1))
+		if i < 6 {
+			agg.ProcessEvent(funnelEvent(user, start.Add(time.Duration(i+1)*time.Minute), 2))
+		}
+		if i < 3 {
+			agg.ProcessEvent(funnelEvent(user, start.Add(8*time.Minute), 3))
+		}
+	}
+
+	clock.Set(start.Add(11 * time.Minute))
+	agg.Flush()
+	if len(closed) != 1 {
+		t.Fatalf("Expected 1 closed window, got %d", len(closed))
+	}
+
+	window := closed[0]
+	gauge := func(name string, step int) float64 {
+		value, ok := window.Metrics.GetMetricValue(SeriesKey(name, funnelLabels("signup", step)))
+		if !ok {
+			t.Fatalf("Missing %s for step %d", name, step)
+		}
+		return value
+	}
+	if count := gauge(FunnelMetricStep, 1); count != 10 {
+		t.Errorf("Expected 10 users at step 1, got %.0f", count)
+	}
+	if conversion := gauge(FunnelMetricConversion, 2); conversion != 0.6 {
+		t.Errorf("Expected 60%% conversion to step 2, got %.2f", conversion)
+	}
+	if conversion := gauge(FunnelMetricConversion, 3); conversion != 0.5 {
+		t.Errorf("Expected 50%% conversion to step 3, got %.2f", conversion)
+	}
+	// Délais vers le signup: 60s à 360s, médiane autour de 180-240s
+	if median := gauge(FunnelMetricMedianSeconds, 2); median < 170 || median > 250 {
+		t.Errorf("Expected median time to step 2 near 210s, got %.1f", median)
+	}
+
+	results := agg.GlobalFunnels()
+	if len(results) != 1 {
+		t.Fatalf("Expected the duplicate funnel to be ignored, got %d funnels", len(results))
+	}
+	steps := results[0].Steps
+	if steps[0].Count != 10 || steps[1].Count != 6 || steps[2].Count != 3 {
+		t.Errorf("Unexpected global step counts %+v", steps)
+	}
+	if steps[0].Conversion != nil || steps[2].OverallConversion == nil || *steps[2].OverallConversion != 0.3 {
+		t.Errorf("Unexpected global conversions %+v", steps)
+	}
+	if steps[2].MedianSeconds == nil || *steps[2].MedianSeconds < 100 {
+		t.Errorf("Expected a median time to purchase, got %v", steps[2].MedianSeconds)
+	}
+
+	latest, windowResults := agg.WindowFunnels("tumbling_10m")
+	if latest == nil || len(windowResults) != 1 || windowResults[0].Steps[1].Count != 6 {
+		t.Errorf("Unexpected latest window funnels %+v", windowResults)
+	}
+	if stats := agg.GetStats()["funnels"].(map[string]int); stats["signup"] != 7 {
+		t.Errorf("Expected 7 journeys in progress, got %v", stats)
+	}
+}
@@ -0,0 +1,67 @@
+package aggregation
+
+// GaugeStats résume les valeurs successives d'une gauge sur sa durée de vie
+// (une fenêtre pour les métriques de fenêtre). Comme DDSketch, elle est
+// verrouillée par la métrique qui la contient.
+type GaugeStats struct {
+	Min   float64 `json:"min"`
+	Max   float64 `json:"max"`
+	Last  float64 `json:"last"`
+	Sum   float64 `json:"sum"`
+	Count int64   `json:"count"`
+}
+
+// Add enregistre une nouvelle valeur
+func (g *GaugeStats) Add(value float64) {
+	if g.Count == 0 || value < g.Min {
+		g.Min = value
+	}
+	if g.Count == 0 || value > g.Max {
+		g.Max = value
+	}
+	g.Last = value
+	g.Sum += value
+	g.Count++
+}
+
+// Merge fusionne les valeurs d'une autre gauge. newer indique si other
+// est plus récente, auquel cas sa dernière valeur l'emporte.
+func (g *GaugeStats) Merge(other GaugeStats, newer bool) {
+	if other.Count == 0 {
+		return
+	}
+	if g.Count == 0 || other.Min < g.Min {
+		g.Min = other.Min
+	}
+	if g.Count == 0 || other.Max > g.Max {
+		g.Max = other.Max
+	}
+	if g.Count == 0 || newer {
+		g.Last = other.Last
+	}
+	g.Sum += other.Sum
+	g.Count += other.Count
+}
+
+// Average retourne la moyenne des valeurs, 0 sans valeur
+func (g *GaugeStats) Average() float64 {
+	if g.Count == 0 {
+		return 0
+	}
+	return g.Sum / float64(g.Count)
+}
+
+// GaugeRules retourne une règle gauge par propriété mesurée (cart_size,
+// queue_length...), évaluée sur tous les types d'événements, globalement et
+// par fenêtre
+func GaugeRules(properties []string) []MetricRule {
+	rules := make([]MetricRule, 0, len(properties))
+	for _, property := range properties {
+		rules = append(rules, MetricRule{
+			Name:     property,
+			Property: property,
+			Type:     RuleTypeGauge,
+		})
+	}
+	return rules
+}
@@ -0,0 +1,104 @@
+package aggregation
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TestMetricGaugeStats teste le suivi min/max/dernière valeur/moyenne d'une gauge
+func TestMetricGaugeStats(t *testing.T) {
+	gauge := NewMetric("queue_length", MetricTypeGauge)
+	for _, v := range []float64{4, 10, 1, 5} {
+		gauge.Set(v)
+	}
+
+	if gauge.Value != 5 || gauge.Count != 4 {
+		t.Errorf("Expected last value 5 over 4 samples, got %.0f over %d", gauge.Value, gauge.Count)
+	}
+	if gauge.Min() != 1 || gauge.Max() != 10 || gauge.Average() != 5 {
+		t.Errorf("Expected min 1, max 10, avg 5, got %.0f/%.0f/%.1f", gauge.Min(), gauge.Max(), gauge.Average())
+	}
+
+	data, err := json.Marshal(gauge)
+	if err != nil {
+		t.Fatalf("Unexpected marshal error: %v", err)
+	}
+	var decoded map[string]interface{}
+	json.Unmarshal(data, &decoded)
+	if decoded["min"] != 1.0 || decoded["max"] != 10.0 || decoded["avg"] != 5.0 {
+		t.Errorf("Expected min/max/avg in JSON, got %s", data)
+	}
+}
+
+// TestMetricGaugeMerge teste la fusion: dernière valeur de la plus récente, min/max/moyenne combinés
+func TestMetricGaugeMerge(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	opts := SnapshotOptions{Clock: clock}
+
+	older := NewMetricsSnapshotWithOptions(opts).GetMetric("cart_size", MetricTypeGauge)
+	older.Set(2)
+	older.Set(8)
+	clock.Advance(time.Minute)
+	newer := NewMetricsSnapshotWithOptions(opts).GetMetric("cart_size", MetricTypeGauge)
+	newer.Set(3)
+
+	if err := newer.Merge(older); err != nil {
+		t.Fatalf("Unexpected merge error: %v", err)
+	}
+	if newer.Value != 3 {
+		t.Errorf("Expected newer last value 3 to win, got %.0f", newer.Value)
+	}
+	if newer.Min() != 2 || newer.Max() != 8 || newer.Count != 3 {
+		t.Errorf("Expected min 2, max 8 over 3 samples, got %.0f/%.0f/%d", newer.Min(), newer.Max(), newer.Count)
+	}
+	if avg := newer.Average(); avg != 13.0/3 {
+		t.Errorf("Expected avg %.2f, got %.2f", 13.0/3, avg)
+	}
+}
+
+// TestAggregatorGauges teste l'ingestion de mesures numériques en gauges de fenêtre
+func TestAggregatorGauges(t *testing.T) {
+	logger := zap.NewNop()
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	agg := NewAggregatorWithOptions(Options{
+		Windows:       []WindowSpec{{Kind: WindowKindTumbling, Size: 1 * time.Minute}},
+		FlushInterval: 10 * time.Second,
+		Clock:         clock,
+		Gauges:        []string{"cart_size"},
+	}, logger)
+
+	var closed *TimeWindow
+	agg.SetWindowClosedCallback(func(window *TimeWindow) {
+		closed = window
+	})
+
+	for i, size := range []float64{1, 3, 2} {
+		agg.ProcessEvent(Event{Type: "add_to_cart", Timestamp: start.Add(time.Duration(i) * time.Second),
+			Properties: map[string]interface{}{"cart_size": size}})
+	}
+	agg.ProcessEvent(Event{Type: "pageview", Timestamp: start.Add(5 * time.Second)}) // sans mesure
+
+	// Les règles par défaut restent actives
+	if value, _ := agg.GetGlobalMetricValue("pageviews"); value != 1 {
+		t.Errorf("Expected default rules kept alongside gauges, got %.0f pageviews", value)
+	}
+
+	clock.Advance(2 * time.Minute)
+	agg.Flush()
+	if closed == nil {
+		t.Fatal("Expected window to be closed")
+	}
+
+	summary := closed.Metrics.GetAllMetrics()["cart_size"].Summary()
+	if summary.Value != 2 || summary.Count != 3 {
+		t.Errorf("Expected last value 2 over 3 samples, got %.0f over %d", summary.Value, summary.Count)
+	}
+	if summary.Min == nil || *summary.Min != 1 || *summary.Max != 3 || *summary.Avg != 2 {
+		t.Errorf("Expected min 1, max 3, avg 2, got %+v", summary)
+	}
+}
@@ -0,0 +1,122 @@
+package aggregation
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+// Précisions supportées par HyperLogLog (2^precision registres)
+const (
+	MinHLLPrecision     = 4
+	MaxHLLPrecision     = 18
+	DefaultHLLPrecision = 14 // 16 Ko, erreur standard ~0.8%
+)
+
+// HyperLogLog estime le nombre de valeurs distinctes en mémoire constante
+// (Flajolet et al. 2007). Deux sketches de même précision se fusionnent par
+// maximum des registres, ce qui permet de compter les uniques sur plusieurs
+// fenêtres sans garder les identifiants. Comme DDSketch, il est verrouillé
+// par la métrique qui le contient.
+type HyperLogLog struct {
+	Precision uint8   `json:"precision"`
+	Registers []uint8 `json:"registers"`
+}
+
+// NewHyperLogLog crée un sketch de 2^precision registres
+func NewHyperLogLog(precision uint8) *HyperLogLog {
+	if precision < MinHLLPrecision || precision > MaxHLLPrecision {
+		precision = DefaultHLLPrecision
+	}
+	return &HyperLogLog{
+		Precision: precision,
+		Registers: make([]uint8, 1<<precision),
+	}
+}
+
+// Add ajoute une valeur au sketch
+func (h *HyperLogLog) Add(value string) {
+	hash := hashString(value)
+	idx := hash >> (64 - h.Precision)
+	// Bit sentinelle pour borner le rang à 64 - precision + 1
+	w := hash<<h.Precision | 1<<(h.Precision-1)
+	rank := uint8(bits.LeadingZeros64(w)) + 1
+	if rank > h.Registers[idx] {
+		h.Registers[idx] = rank
+	}
+}
+
+// Merge fusionne un autre sketch de même précision
+func (h *HyperLogLog) Merge(other *HyperLogLog) error {
+	if other == nil {
+		return nil
+	}
+	if h.Precision != other.Precision {
+		return fmt.Errorf("cannot merge HyperLogLog with different precision (%d vs %d)",
+			h.Precision, other.Precision)
+	}
+	for i, r := range other.Registers {
+		if r > h.Registers[i] {
+			h.Registers[i] = r
+		}
+	}
+	return nil
+}
+
+// Estimate retourne le nombre estimé de valeurs distinctes
+func (h *HyperLogLog) Estimate() int64 {
+	m := float64(len(h.Registers))
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.Registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	estimate := hllAlpha(m) * m * m / sum
+
+	// Petites cardinalités: comptage linéaire, plus précis
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return int64(estimate + 0.5)
+}
+
+// Clone retourne une copie indépendante du sketch
+func (h *HyperLogLog) Clone() *HyperLogLog {
+	registers := make([]uint8, len(h.Registers))
+	copy(registers, h.Registers)
+	return &HyperLogLog{Precision: h.Precision, Registers: registers}
+}
+
+// hllAlpha est la constante de correction de biais pour m registres
+func hllAlpha(m float64) float64 {
+	switch {
+	case m <= 16:
+		return 0.673
+	case m <= 32:
+		return 0.697
+	case m <= 64:
+		return 0.709
+	default:
+		return 0.7213 / (1 + 1.079/m)
+	}
+}
+
+// hashString hache une valeur sur 64 bits (FNV-1a puis finaliseur murmur3
+// pour bien répartir les bits de poids fort)
+func hashString(value string) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(value))
+	h := hasher.Sum64()
+
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
@@ -0,0 +1,118 @@
+package aggregation
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// TestHyperLogLogEstimate teste la précision de l'estimation sur de grandes cardinalités
+func TestHyperLogLogEstimate(t *testing.T) {
+	for _, n := range []int{100, 10000, 100000} {
+		hll := NewHyperLogLog(DefaultHLLPrecision)
+		for i := 0; i < n; i++ {
+			hll.Add(fmt.Sprintf("user_%d", i))
+			hll.Add(fmt.Sprintf("user_%d", i)) // doublons ignorés
+		}
+
+		relErr := math.Abs(float64(hll.Estimate())-float64(n)) / float64(n)
+		if relErr > 0.03 {
+			t.Errorf("n=%d: estimate %d off by %.1f%%", n, hll.Estimate(), relErr*100)
+		}
+	}
+}
+
+// TestHyperLogLogMerge teste l'union de deux sketches
+func TestHyperLogLogMerge(t *testing.T) {
+	a := NewHyperLogLog(12)
+	b := NewHyperLogLog(12)
+	for i := 0; i < 6000; i++ {
+		a.Add(fmt.Sprintf("user_%d", i))
+	}
+	for i := 4000; i < 10000; i++ {
+		b.Add(fmt.Sprintf("user_%d", i))
+	}
+
+	if err := a.Merge(b); err != nil {
+		t.Fatalf("Unexpected merge error: %v", err)
+	}
+	if relErr := math.Abs(float64(a.Estimate())-10000) / 10000; relErr > 0.05 {
+		t.Errorf("Expected union ~10000, got %d", a.Estimate())
+	}
+
+	if err := a.Merge(NewHyperLogLog(10)); err == nil {
+		t.Error("Expected error when merging different precisions")
+	}
+}
+
+// TestMetricSetModes teste les modes exact, hll et auto des métriques set
+func TestMetricSetModes(t *testing.T) {
+	exact := NewMetricsSnapshotWithOptions(SnapshotOptions{}).GetMetric("users", MetricTypeSet)
+	hll := NewMetricsSnapshotWithOptions(SnapshotOptions{
+		Sets: SetOptions{Mode: SetModeHLL},
+	}).GetMetric("users", MetricTypeSet)
+	auto := NewMetricsSnapshotWithOptions(SnapshotOptions{
+		Sets: SetOptions{Mode: SetModeAuto, ExactThreshold: 100},
+	}).GetMetric("users", MetricTypeSet)
+
+	for i := 0; i < 50; i++ {
+		for _, m := range []*Metric{exact, hll, auto} {
+			m.AddUnique(fmt.Sprintf("user_%d", i))
+		}
+	}
+	if !exact.IsExact() || hll.IsExact() || !auto.IsExact() {
+		t.Fatal("Unexpected set representation below threshold")
+	}
+	if exact.Count != 50 || auto.Count != 50 {
+		t.Errorf("Expected exact count 50, got %d and %d", exact.Count, auto.Count)
+	}
+	if count := hll.Summary().Count; count != 50 {
+		t.Errorf("Expected HLL estimate 50 for small cardinality, got %d", count)
+	}
+
+	// Au-delà du seuil, le mode auto bascule et libère les valeurs
+	for i := 50; i < 5000; i++ {
+		auto.AddUnique(fmt.Sprintf("user_%d", i))
+	}
+	if auto.IsExact() || auto.UniqueSet != nil {
+		t.Fatal("Expected auto set to switch to HyperLogLog above threshold")
+	}
+	// L'estimation est calculée à la lecture, pas à chaque ajout
+	count := auto.Summary().Count
+	if relErr := math.Abs(float64(count)-5000) / 5000; relErr > 0.03 {
+		t.Errorf("Expected ~5000 uniques, got %d", count)
+	}
+	if auto.Count == count {
+		t.Errorf("Expected the cached count to be refreshed on read only, got %d", auto.Count)
+	}
+}
+
+// TestMetricMergeSet teste l'union de sets exacts et HyperLogLog
+func TestMetricMergeSet(t *testing.T) {
+	a := NewMetric("users", MetricTypeSet)
+	b := NewMetric("users", MetricTypeSet)
+	a.AddUnique("user_1")
+	a.AddUnique("user_2")
+	b.AddUnique("user_2")
+	b.AddUnique("user_3")
+
+	if err := a.MergeSet(b); err != nil {
+		t.Fatalf("Unexpected merge error: %v", err)
+	}
+	if !a.IsExact() || a.Count != 3 {
+		t.Errorf("Expected exact union of 3, got %d", a.Count)
+	}
+
+	sketch := NewMetricsSnapshotWithOptions(SnapshotOptions{
+		Sets: SetOptions{Mode: SetModeHLL},
+	}).GetMetric("users", MetricTypeSet)
+	sketch.AddUnique("user_3")
+	sketch.AddUnique("user_4")
+
+	if err := a.MergeSet(sketch); err != nil {
+		t.Fatalf("Unexpected merge error: %v", err)
+	}
+	if count := a.Summary().Count; a.IsExact() || count != 4 {
+		t.Errorf("Expected HyperLogLog union of 4, got %d (exact=%v)", count, a.IsExact())
+	}
+}
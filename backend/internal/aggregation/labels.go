@@ -0,0 +1,193 @@
+package aggregation
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Labels est l'ensemble des labels d'une série (page="/home", type="click").
+// Une série est identifiée par son nom et ses labels: page_views{page="/home"}.
+type Labels map[string]string
+
+// String retourne la forme canonique {a="1",b="2"} (labels triés), vide sans label
+func (l Labels) String() string {
+	if len(l) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(l))
+	for k := range l {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(strconv.Quote(l[k]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// Matches indique si la série porte tous les labels de match avec la même valeur
+func (l Labels) Matches(match Labels) bool {
+	for k, v := range match {
+		if value, ok := l[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// clone retourne une copie indépendante (nil reste nil)
+func (l Labels) clone() Labels {
+	if l == nil {
+		return nil
+	}
+	c := make(Labels, len(l))
+	for k, v := range l {
+		c[k] = v
+	}
+	return c
+}
+
+// SeriesKey retourne la clé d'une série dans un MetricsSnapshot. Sans label,
+// c'est le nom de la métrique.
+func SeriesKey(name string, labels Labels) string {
+	return name + labels.String()
+}
+
+// MetricGroup est le résultat d'un regroupement de séries par labels
+type MetricGroup struct {
+	Labels Labels     `json:"labels"`
+	Type   MetricType `json:"type"`
+	Value  float64    `json:"value"`
+	Count  int64      `json:"count"`
+	Series int        `json:"series"` // nombre de séries fusionnées
+}
+
+// GetMetricWithLabels récupère ou crée la série name{labels}. Si la famille
+// name a atteint sa limite de cardinalité, une nouvelle combinaison de labels
+// est redirigée vers la série __other__. Dans le delta d'un shard, c'est le
+// snapshot cible qui décide: la série y est réservée (ou redirigée) dès
+// l'enregistrement, pour que le delta ne dépasse pas la limite entre deux
+// fusions et que chaque mise à jour redirigée soit comptée.
+func (ms *MetricsSnapshot) GetMetricWithLabels(name string, labels Labels, metricType MetricType) *Metric {
+	key := SeriesKey(name, labels)
+
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if metric, exists := ms.Metrics[key]; exists {
+		return metric
+	}
+
+	if len(labels) > 0 && !labels.isOverflow() && ms.target != nil {
+		labels = ms.target.GetMetricWithLabels(name, labels, metricType).Labels
+		key = SeriesKey(name, labels)
+		if metric, exists := ms.Metrics[key]; exists {
+			return metric
+		}
+		if !labels.isOverflow() {
+			ms.families[name]++
+		}
+	} else if len(labels) > 0 && !labels.isOverflow() {
+		if limit := ms.opts.Cardinality.Limit(name); limit > 0 && ms.families[name] >= limit {
+			labels = overflowLabels(labels)
+			key = SeriesKey(name, labels)
+			if ms.opts.OnOverflow != nil {
+				ms.opts.OnOverflow(name)
+			}
+			if metric, exists := ms.Metrics[key]; exists {
+				return metric
+			}
+		} else {
+			ms.families[name]++
+		}
+	}
+
+	metric := newMetric(name, metricType, ms.opts)
+	metric.Labels = labels.clone()
+	ms.Metrics[key] = metric
+	return metric
+}
+
+// Query retourne les séries de la métrique name dont les labels correspondent
+// à match (toutes si match est vide), triées par clé de série
+func (ms *MetricsSnapshot) Query(name string, match Labels) []*Metric {
+	ms.mu.RLock()
+	keys := make([]string, 0)
+	for key, metric := range ms.Metrics {
+		if metric.Name == name && metric.Labels.Matches(match) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	series := make([]*Metric, 0, len(keys))
+	for _, key := range keys {
+		series = append(series, ms.Metrics[key])
+	}
+	ms.mu.RUnlock()
+	return series
+}
+
+// GroupBy fusionne les séries de name qui correspondent à match selon les
+// valeurs des labels by (compteurs additionnés, gauges à la dernière valeur,
+// histogrammes et sets fusionnés). Les séries sans un des labels by sont ignorées.
+func (ms *MetricsSnapshot) GroupBy(name string, by []string, match Labels) []MetricGroup {
+	groups := make(map[string]*Metric)
+	counts := make(map[string]int)
+	order := make([]string, 0)
+
+	for _, metric := range ms.Query(name, match) {
+		labels := make(Labels, len(by))
+		complete := true
+		for _, label := range by {
+			value, ok := metric.Labels[label]
+			if !ok {
+				complete = false
+				break
+			}
+			labels[label] = value
+		}
+		if !complete {
+			continue
+		}
+
+		key := labels.String()
+		acc, ok := groups[key]
+		if !ok {
+			acc = newMetric(name, metric.Type, ms.opts)
+			acc.Labels = labels
+			acc.Timestamp = time.Time{} // la première série fixe la valeur des gauges
+			groups[key] = acc
+			order = append(order, key)
+		}
+		if err := acc.Merge(metric); err != nil {
+			continue
+		}
+		counts[key]++
+	}
+
+	sort.Strings(order)
+	result := make([]MetricGroup, 0, len(order))
+	for _, key := range order {
+		acc := groups[key]
+		summary := acc.Summary()
+		result = append(result, MetricGroup{
+			Labels: acc.Labels,
+			Type:   acc.Type,
+			Value:  summary.Value,
+			Count:  summary.Count,
+			Series: counts[key],
+		})
+	}
+	return result
+}
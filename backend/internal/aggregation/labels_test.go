@@ -0,0 +1,109 @@
+package aggregation
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TestLabelsString teste la forme canonique des labels (ordre indépendant)
+func TestLabelsString(t *testing.T) {
+	a := Labels{"page": "/home", "country": "FR"}
+	b := Labels{"country": "FR", "page": "/home"}
+	if a.String() != `{country="FR",page="/home"}` || a.String() != b.String() {
+		t.Errorf("Unexpected canonical form %s / %s", a.String(), b.String())
+	}
+	if SeriesKey("pageviews", nil) != "pageviews" {
+		t.Errorf("Expected unlabeled series key to be the name, got %s", SeriesKey("pageviews", nil))
+	}
+}
+
+// TestMetricsSnapshotQueryGroupBy teste le filtrage et le regroupement par label
+func TestMetricsSnapshotQueryGroupBy(t *testing.T) {
+	ms := NewMetricsSnapshot()
+	views := []Labels{
+		{"page": "/home", "country": "FR"},
+		{"page": "/home", "country": "FR"},
+		{"page": "/home", "country": "US"},
+		{"page": "/cart", "country": "FR"},
+	}
+	for _, labels := range views {
+		ms.GetMetricWithLabels("page_views", labels, MetricTypeCounter).Increment()
+	}
+	ms.GetMetric("page_views", MetricTypeCounter).Increment() // série sans label
+
+	if series := ms.Query("page_views", nil); len(series) != 4 {
+		t.Errorf("Expected 4 series, got %d", len(series))
+	}
+	fr := ms.Query("page_views", Labels{"country": "FR"})
+	if len(fr) != 2 {
+		t.Fatalf("Expected 2 series for country=FR, got %d", len(fr))
+	}
+	for _, metric := range fr {
+		if metric.Name != "page_views" || metric.Labels["country"] != "FR" {
+			t.Errorf("Unexpected series %s%s", metric.Name, metric.Labels)
+		}
+	}
+
+	groups := ms.GroupBy("page_views", []string{"page"}, nil)
+	if len(groups) != 2 {
+		t.Fatalf("Expected 2 groups, got %d", len(groups))
+	}
+	expected := map[string]float64{"/cart": 1, "/home": 3}
+	for _, group := range groups {
+		if group.Value != expected[group.Labels["page"]] {
+			t.Errorf("page=%s: expected %.0f, got %.0f", group.Labels["page"], expected[group.Labels["page"]], group.Value)
+		}
+	}
+	if groups[1].Series != 2 {
+		t.Errorf("Expected /home to merge 2 series, got %d", groups[1].Series)
+	}
+}
+
+// TestMetricsSnapshotGroupBySets teste l'union des sets lors d'un regroupement
+func TestMetricsSnapshotGroupBySets(t *testing.T) {
+	ms := NewMetricsSnapshot()
+	ms.GetMetricWithLabels("buyers", Labels{"country": "FR", "plan": "free"}, MetricTypeSet).AddUnique("user_1")
+	ms.GetMetricWithLabels("buyers", Labels{"country": "FR", "plan": "pro"}, MetricTypeSet).AddUnique("user_1")
+	ms.GetMetricWithLabels("buyers", Labels{"country": "FR", "plan": "pro"}, MetricTypeSet).AddUnique("user_2")
+
+	groups := ms.GroupBy("buyers", []string{"country"}, nil)
+	if len(groups) != 1 || groups[0].Count != 2 {
+		t.Errorf("Expected 2 distinct buyers in FR, got %+v", groups)
+	}
+}
+
+// TestAggregatorLabels teste les labels des métriques produites par l'agrégateur
+func TestAggregatorLabels(t *testing.T) {
+	logger := zap.NewNop()
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	agg := NewAggregatorWithOptions(Options{
+		Windows:       []WindowSpec{{Kind: WindowKindTumbling, Size: 1 * time.Minute}},
+		FlushInterval: 10 * time.Second,
+		Clock:         NewManualClock(start),
+	}, logger)
+
+	agg.ProcessEvent(Event{Type: "pageview", Timestamp: start, Properties: map[string]interface{}{"page": "/home"}})
+	agg.ProcessEvent(Event{Type: "pageview", Timestamp: start, Properties: map[string]interface{}{"page": "/home"}})
+	agg.ProcessEvent(Event{Type: "click", Timestamp: start, Properties: map[string]interface{}{"element": "buy-btn"}})
+
+	byType := agg.QueryGlobalMetrics("events_by_type", Labels{"type": "pageview"})
+	if len(byType) != 1 || byType[0].Value != 2 {
+		t.Fatalf("Expected events_by_type{type=pageview} = 2, got %d series", len(byType))
+	}
+
+	pages := agg.GroupGlobalMetrics("page_views", []string{"page"}, nil)
+	if len(pages) != 1 || pages[0].Labels["page"] != "/home" || pages[0].Value != 2 {
+		t.Errorf("Expected page_views grouped by page /home = 2, got %+v", pages)
+	}
+
+	window := agg.GetActiveWindows()[0]
+	clicks := window.Metrics.Query("events", Labels{"type": "click"})
+	if len(clicks) != 1 || clicks[0].Value != 1 {
+		t.Errorf("Expected window events{type=click} = 1, got %d series", len(clicks))
+	}
+	if total, _ := window.Metrics.GetMetricValue("events"); total != 3 {
+		t.Errorf("Expected unlabeled window events = 3, got %.0f", total)
+	}
+}
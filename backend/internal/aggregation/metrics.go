@@ -1,6 +1,8 @@
 package aggregation
 
 import (
+    "encoding/json"
+    "fmt"
     "sync"
     "time"
 )
@@ -12,6 +14,7 @@ const (
     MetricTypeGauge     MetricType = "gauge"     // valeur instantanée
     MetricTypeHistogram MetricType = "histogram" // distribution des valeurs
     MetricTypeSet       MetricType = "set"       // valeurs uniques
+    MetricTypeTopK      MetricType = "topk"      // valeurs les plus fréquentes
 )
 
 type Metric struct {
@@ -20,22 +23,76 @@ type Metric struct {
     Value     float64             `json:"value"`
     Count     int64               `json:"count"`
     Timestamp time.Time           `json:"timestamp"`
-    Tags      map[string]string   `json:"tags,omitempty"`
-    Values    []float64           `json:"values,omitempty"` // pour histogramme
+    Labels    Labels              `json:"labels,omitempty"`   // identité de la série avec Name
+    Sketch    *DDSketch           `json:"-"`                // pour histogramme (quantiles, mémoire bornée)
     UniqueSet map[string]struct{} `json:"-"`                // pour set
+    HLL       *HyperLogLog        `json:"-"`                // pour set en mode hll/auto
+    TopK      *TopK               `json:"-"`                // pour topk
+    Gauge     *GaugeStats         `json:"-"`                // pour gauge (min/max/last/moyenne)
+    opts      SnapshotOptions                              // horloge et stockage des sets
+    stale     bool                                         // Count ne reflète plus l'estimation du HLL
     mu        sync.RWMutex                                 // protège les champs ci-dessus
 }
 
+// SetMode choisit le stockage des métriques de type set
+type SetMode string
+
+const (
+    SetModeExact SetMode = "exact" // garde chaque valeur (exact, mémoire non bornée)
+    SetModeHLL   SetMode = "hll"   // HyperLogLog dès la première valeur
+    SetModeAuto  SetMode = "auto"  // exact jusqu'à ExactThreshold valeurs, puis HyperLogLog
+)
+
+// DefaultSetExactThreshold est le seuil de bascule du mode auto
+const DefaultSetExactThreshold = 1000
+
+// SetOptions configure le stockage des métriques de type set
+type SetOptions struct {
+    Mode           SetMode
+    Precision      uint8 // précision HyperLogLog (4..18)
+    ExactThreshold int   // mode auto uniquement
+}
+
+// SnapshotOptions configure les métriques créées par un MetricsSnapshot
+type SnapshotOptions struct {
+    Clock       Clock // nil: horloge murale
+    Sets        SetOptions
+    Cardinality CardinalityLimits
+    TopKCapacity int // compteurs par métrique topk (0: DefaultTopKCapacity)
+
+    // OnOverflow est appelé (sous le verrou du snapshot) à chaque mise à jour
+    // redirigée vers la série __other__ d'une famille saturée
+    OnOverflow func(family string)
+}
+
   // NewMetric crée une nouvelle métrique
   func NewMetric(name string, metricType MetricType) *Metric {
-    return &Metric{
-        Name:      name,
-        Type:      metricType,
-        Timestamp: time.Now(),
-        Tags:      make(map[string]string),
-        Values:    make([]float64, 0),
-        UniqueSet: make(map[string]struct{}),
-    }
+    return newMetric(name, metricType, SnapshotOptions{})
+  }
+
+// newMetric crée une métrique configurée par opts
+func newMetric(name string, metricType MetricType, opts SnapshotOptions) *Metric {
+	opts.Clock = orSystemClock(opts.Clock)
+	m := &Metric{
+		Name:      name,
+		Type:      metricType,
+		Timestamp: opts.Clock.Now(),
+		UniqueSet: make(map[string]struct{}),
+		opts:      opts,
+	}
+	if metricType == MetricTypeSet && opts.Sets.Mode == SetModeHLL {
+		m.HLL = NewHyperLogLog(opts.Sets.Precision)
+		m.UniqueSet = nil
+	}
+	return m
+}
+
+// now retourne l'heure de l'horloge de la métrique
+func (m *Metric) now() time.Time {
+	if m.opts.Clock == nil {
+		return time.Now()
+	}
+	return m.opts.Clock.Now()
 }
   // Increment augmente une métrique compteur de 1
   func (m *Metric) Increment() {
@@ -43,7 +100,7 @@ type Metric struct {
     defer m.mu.Unlock()
     m.Count++
     m.Value += 1
-    m.Timestamp = time.Now()
+    m.Timestamp = m.now()
   }
 
   // IncrementBy augmente une métrique compteur d'une valeur donnée
@@ -53,74 +110,452 @@ type Metric struct {
 	defer m.mu.Unlock()
 	m.Value += value
 	m.Count++
-	m.Timestamp = time.Now()
+	m.Timestamp = m.now()
 }
 
-// Set définit une valeur pour une gauge
+// Set définit une valeur pour une gauge (Value est la dernière valeur)
 func (m *Metric) Set(value float64) {
     m.mu.Lock()
     defer m.mu.Unlock()
+    if m.Gauge == nil {
+        m.Gauge = &GaugeStats{}
+    }
+    m.Gauge.Add(value)
     m.Value = value
-    m.Timestamp = time.Now()
+    m.Count = m.Gauge.Count
+    m.Timestamp = m.now()
 }
 
 // Observe ajoute une valeur à un histogramme
 func (m *Metric) Observe(value float64) {
     m.mu.Lock()
     defer m.mu.Unlock()
-    m.Values = append(m.Values, value)
+    if m.Sketch == nil {
+        m.Sketch = NewDDSketch(DefaultSketchRelativeAccuracy, DefaultSketchMaxBins)
+    }
+    m.Sketch.Add(value)
     m.Count++
     m.Value += value
-    m.Timestamp = time.Now()
+    m.Timestamp = m.now()
 }
 
 // add unique value to a set
 func (m *Metric) AddUnique(value string) {
     m.mu.Lock()
     defer m.mu.Unlock()
-    m.UniqueSet[value] = struct{}{}
-    m.Count = int64(len(m.UniqueSet))
-    m.Timestamp = time.Now()
+    if m.HLL != nil {
+        m.HLL.Add(value)
+        m.stale = true
+    } else {
+        if m.UniqueSet == nil {
+            m.UniqueSet = make(map[string]struct{})
+        }
+        m.UniqueSet[value] = struct{}{}
+        m.Count = int64(len(m.UniqueSet))
+        m.maybeSwitchToHLLLocked()
+    }
+    m.Timestamp = m.now()
+}
+
+// maybeSwitchToHLLLocked bascule un set exact vers HyperLogLog en mode auto
+// une fois le seuil dépassé (m.mu doit être tenu)
+func (m *Metric) maybeSwitchToHLLLocked() {
+	if m.opts.Sets.Mode != SetModeAuto {
+		return
+	}
+	threshold := m.opts.Sets.ExactThreshold
+	if threshold <= 0 {
+		threshold = DefaultSetExactThreshold
+	}
+	if len(m.UniqueSet) <= threshold {
+		return
+	}
+	m.HLL = m.toHLLLocked(m.opts.Sets.Precision)
+	m.UniqueSet = nil
+	m.stale = true
+}
+
+// countLocked retourne Count, en estimant la cardinalité d'un HyperLogLog
+// modifié depuis la dernière lecture: l'estimation parcourt tous les
+// registres, elle est donc faite à la lecture plutôt qu'à chaque ajout
+// (m.mu doit être tenu)
+func (m *Metric) countLocked() int64 {
+	if m.stale {
+		return m.HLL.Estimate()
+	}
+	return m.Count
+}
+
+// refreshCount met à jour Count d'un set HyperLogLog modifié
+func (m *Metric) refreshCount() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stale {
+		m.Count = m.HLL.Estimate()
+		m.stale = false
+	}
+}
+
+// toHLLLocked retourne le contenu du set sous forme de HyperLogLog (m.mu doit être tenu)
+func (m *Metric) toHLLLocked(precision uint8) *HyperLogLog {
+	if m.HLL != nil {
+		return m.HLL.Clone()
+	}
+	hll := NewHyperLogLog(precision)
+	for value := range m.UniqueSet {
+		hll.Add(value)
+	}
+	return hll
+}
+
+// MergeSet ajoute à m les valeurs uniques d'un autre set (union). Deux sets
+// exacts restent exacts; dès qu'un des deux est un HyperLogLog, le résultat
+// en est un aussi.
+func (m *Metric) MergeSet(other *Metric) error {
+	if other == m {
+		return nil
+	}
+	other.mu.RLock()
+	timestamp := other.Timestamp
+	otherSet := make([]string, 0, len(other.UniqueSet))
+	for value := range other.UniqueSet {
+		otherSet = append(otherSet, value)
+	}
+	var otherHLL *HyperLogLog
+	if other.HLL != nil {
+		otherHLL = other.HLL.Clone()
+	}
+	other.mu.RUnlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if (m.Count == 0 && !m.stale) || timestamp.After(m.Timestamp) {
+		m.Timestamp = timestamp
+	}
+	if otherHLL == nil && m.HLL == nil {
+		if m.UniqueSet == nil {
+			m.UniqueSet = make(map[string]struct{}, len(otherSet))
+		}
+		for _, value := range otherSet {
+			m.UniqueSet[value] = struct{}{}
+		}
+		m.Count = int64(len(m.UniqueSet))
+		m.maybeSwitchToHLLLocked()
+		return nil
+	}
+
+	precision := m.opts.Sets.Precision
+	if otherHLL != nil {
+		precision = otherHLL.Precision
+	}
+	if m.HLL == nil {
+		m.HLL = m.toHLLLocked(precision)
+		m.UniqueSet = nil
+	}
+	if otherHLL == nil {
+		otherHLL = NewHyperLogLog(m.HLL.Precision)
+		for _, value := range otherSet {
+			otherHLL.Add(value)
+		}
+	}
+	if err := m.HLL.Merge(otherHLL); err != nil {
+		return err
+	}
+	m.stale = true
+	return nil
+}
+
+// Merge ajoute les données d'une autre métrique de même type: les compteurs
+// s'additionnent, une gauge garde la valeur la plus récente, les histogrammes
+// fusionnent leurs sketches et les sets font l'union.
+func (m *Metric) Merge(other *Metric) error {
+	if other == m {
+		return nil
+	}
+	if m.Type != other.Type {
+		return fmt.Errorf("cannot merge metric %s of type %s into %s", other.Name, other.Type, m.Type)
+	}
+	if m.Type == MetricTypeSet {
+		return m.MergeSet(other)
+	}
+
+	other.mu.RLock()
+	value, count, timestamp := other.Value, other.Count, other.Timestamp
+	var gauge GaugeStats
+	if other.Gauge != nil {
+		gauge = *other.Gauge
+	}
+	var sketch *DDSketch
+	if other.Sketch != nil {
+		sketch = other.Sketch.Clone()
+	}
+	var topK *TopK
+	if other.TopK != nil {
+		topK = other.TopK.Clone()
+	}
+	other.mu.RUnlock()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Une métrique vide prend l'horodatage (et la valeur de gauge) de other
+	empty := m.Count == 0
+	switch m.Type {
+	case MetricTypeGauge:
+		newer := empty || !timestamp.Before(m.Timestamp)
+		if m.Gauge == nil {
+			m.Gauge = &GaugeStats{}
+		}
+		m.Gauge.Merge(gauge, newer)
+		m.Count = m.Gauge.Count
+		if !newer {
+			return nil
+		}
+		m.Value = value
+	case MetricTypeHistogram:
+		if sketch != nil {
+			if m.Sketch == nil {
+				m.Sketch = sketch
+			} else if err := m.Sketch.Merge(sketch); err != nil {
+				return err
+			}
+		}
+		m.Value += value
+		m.Count += count
+	case MetricTypeTopK:
+		if topK != nil {
+			if m.TopK == nil {
+				m.TopK = topK
+			} else {
+				m.TopK.Merge(topK)
+			}
+		}
+		m.Count += count
+	default:
+		m.Value += value
+		m.Count += count
+	}
+	if empty || timestamp.After(m.Timestamp) {
+		m.Timestamp = timestamp
+	}
+	return nil
+}
+
+// AddTopK compte une occurrence de value dans une métrique topk
+func (m *Metric) AddTopK(value string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.TopK == nil {
+		m.TopK = NewTopK(m.opts.TopKCapacity)
+	}
+	m.TopK.Add(value, 1)
+	m.Count++
+	m.Timestamp = m.now()
+}
+
+// Top retourne les k valeurs les plus fréquentes d'une métrique topk (nil sinon)
+func (m *Metric) Top(k int) []TopKEntry {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.TopK == nil {
+		return nil
+	}
+	return m.TopK.Top(k)
+}
+
+// IsExact indique si un set garde ses valeurs exactes (pas de HyperLogLog)
+func (m *Metric) IsExact() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.HLL == nil
 }
 
 func (m *Metric) Average() float64 {
     m.mu.RLock()
     defer m.mu.RUnlock()
+    if m.Gauge != nil {
+        return m.Gauge.Average()
+    }
     if m.Count == 0 {
         return 0
     }
     return m.Value / float64(m.Count)
 }
 
+// Quantile retourne une estimation du quantile q (0..1) d'un histogramme
+func (m *Metric) Quantile(q float64) float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.Sketch == nil {
+		return 0
+	}
+	return m.Sketch.Quantile(q)
+}
+
+// Min retourne la plus petite valeur observée d'un histogramme ou d'une gauge
+func (m *Metric) Min() float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.Gauge != nil {
+		return m.Gauge.Min
+	}
+	if m.Sketch == nil {
+		return 0
+	}
+	return m.Sketch.Min
+}
+
+// Max retourne la plus grande valeur observée d'un histogramme ou d'une gauge
+func (m *Metric) Max() float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.Gauge != nil {
+		return m.Gauge.Max
+	}
+	if m.Sketch == nil {
+		return 0
+	}
+	return m.Sketch.Max
+}
+
+// Quantiles exposés par l'API pour les histogrammes
+var exposedQuantiles = []struct {
+	Name string
+	Q    float64
+}{
+	{"p50", 0.50},
+	{"p90", 0.90},
+	{"p95", 0.95},
+	{"p99", 0.99},
+}
+
+// Percentiles retourne p50/p90/p95/p99 d'un histogramme (nil si vide)
+func (m *Metric) Percentiles() map[string]float64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.percentilesLocked()
+}
+
+// percentilesLocked calcule les percentiles exposés (m.mu doit être tenu)
+func (m *Metric) percentilesLocked() map[string]float64 {
+	if m.Sketch == nil || m.Sketch.Count == 0 {
+		return nil
+	}
+	result := make(map[string]float64, len(exposedQuantiles))
+	for _, eq := range exposedQuantiles {
+		result[eq.Name] = m.Sketch.Quantile(eq.Q)
+	}
+	return result
+}
+
+// metricJSON évite la récursion de MarshalJSON
+type metricJSON Metric
+
+// exposedTopK est le nombre de valeurs exposées en JSON pour une métrique topk
+const exposedTopK = 10
+
+// MarshalJSON sérialise la métrique sous verrou, avec min/max/percentiles pour
+// les histogrammes, min/max/avg pour les gauges et les valeurs les plus
+// fréquentes pour les topk
+func (m *Metric) MarshalJSON() ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	out := struct {
+		*metricJSON
+		Count       int64              `json:"count"`
+		Min         *float64           `json:"min,omitempty"`
+		Max         *float64           `json:"max,omitempty"`
+		Percentiles map[string]float64 `json:"percentiles,omitempty"`
+		Avg         *float64           `json:"avg,omitempty"`
+		Top         []TopKEntry        `json:"top,omitempty"`
+	}{metricJSON: (*metricJSON)(m), Count: m.countLocked()}
+
+	if m.Gauge != nil && m.Gauge.Count > 0 {
+		min, max, avg := m.Gauge.Min, m.Gauge.Max, m.Gauge.Average()
+		out.Min = &min
+		out.Max = &max
+		out.Avg = &avg
+	}
+
+	if m.TopK != nil {
+		out.Top = m.TopK.Top(exposedTopK)
+	}
+
+	if percentiles := m.percentilesLocked(); percentiles != nil {
+		min, max := m.Sketch.Min, m.Sketch.Max
+		out.Min = &min
+		out.Max = &max
+		out.Percentiles = percentiles
+	}
+	return json.Marshal(out)
+}
+
+// MetricSummary est une copie cohérente des valeurs d'une métrique, avec les
+// statistiques propres à son type (nil quand elles ne s'appliquent pas)
+type MetricSummary struct {
+	Value       float64
+	Count       int64
+	Min         *float64
+	Max         *float64
+	Avg         *float64 // gauges
+	Percentiles map[string]float64
+	Top         []TopKEntry
+}
+
+// Summary retourne les valeurs de la métrique lues sous un même verrou
+func (m *Metric) Summary() MetricSummary {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	summary := MetricSummary{Value: m.Value, Count: m.countLocked()}
+	if m.Gauge != nil && m.Gauge.Count > 0 {
+		min, max, avg := m.Gauge.Min, m.Gauge.Max, m.Gauge.Average()
+		summary.Min, summary.Max, summary.Avg = &min, &max, &avg
+	}
+	if percentiles := m.percentilesLocked(); percentiles != nil {
+		min, max := m.Sketch.Min, m.Sketch.Max
+		summary.Min, summary.Max = &min, &max
+		summary.Percentiles = percentiles
+	}
+	if m.TopK != nil {
+		summary.Top = m.TopK.Top(exposedTopK)
+	}
+	return summary
+}
+
 // MetricsSnapshot represente un ensemble de métriques à un instant donné
 type MetricsSnapshot struct {
     Metrics   map[string]*Metric `json:"metrics"`
     Timestamp time.Time          `json:"timestamp"`
+    opts      SnapshotOptions
+    families  map[string]int // séries labellisées par famille (limites de cardinalité)
+    target    *MetricsSnapshot // delta d'un shard: snapshot où il sera fusionné, qui admet ses séries
     mu        sync.RWMutex
 }
 
 func NewMetricsSnapshot() *MetricsSnapshot {
-    return &MetricsSnapshot{
-        Timestamp: time.Now(),
-        Metrics:   make(map[string]*Metric),
-    }
+    return NewMetricsSnapshotWithOptions(SnapshotOptions{})
 }
 
-// GetMetric récupère ou crée une métrique par nom et type
-func (ms *MetricsSnapshot) GetMetric(name string, metricType MetricType) *Metric {
-	ms.mu.Lock()
-	defer ms.mu.Unlock()
- 
-	if metric, exists := ms.Metrics[name]; exists {
-		return metric
+// NewMetricsSnapshotWithOptions crée un snapshot dont les métriques suivent opts
+func NewMetricsSnapshotWithOptions(opts SnapshotOptions) *MetricsSnapshot {
+	opts.Clock = orSystemClock(opts.Clock)
+	return &MetricsSnapshot{
+		Timestamp: opts.Clock.Now(),
+		Metrics:   make(map[string]*Metric),
+		opts:      opts,
+		families:  make(map[string]int),
 	}
+}
 
-	metric := NewMetric(name, metricType)
-	ms.Metrics[name] = metric
-	return metric
+// GetMetric récupère ou crée une métrique par nom et type
+func (ms *MetricsSnapshot) GetMetric(name string, metricType MetricType) *Metric {
+	return ms.GetMetricWithLabels(name, nil, metricType)
 }
 
-// GetMetricValue recupere la valeur d'une métrique
+// GetMetricValue recupere la valeur d'une métrique (par clé de série, voir SeriesKey)
 func (ms *MetricsSnapshot) GetMetricValue(name string) (float64, bool) {
 	ms.mu.RLock()
 	defer ms.mu.RUnlock()
@@ -131,42 +566,71 @@ func (ms *MetricsSnapshot) GetMetricValue(name string) (float64, bool) {
 	return 0, false
 }
 
-// GetAllMetrics retourne toutes les métriques (copie superficielle du map)
+// GetAllMetrics retourne toutes les métriques (copie superficielle du map),
+// avec le Count des sets HyperLogLog à jour
 func (ms *MetricsSnapshot) GetAllMetrics() map[string]*Metric {
 	ms.mu.RLock()
 	defer ms.mu.RUnlock()
  
 	result := make(map[string]*Metric, len(ms.Metrics))
 	for name, metric := range ms.Metrics {
+		if metric.Type == MetricTypeSet {
+			metric.refreshCount()
+		}
 		result[name] = metric
 	}
 	return result
 }
 
+// Merge fusionne les séries d'un autre snapshot (voir Metric.Merge). Les
+// limites de cardinalité de ms s'appliquent aux séries qu'il ne connaît pas.
+// Retourne la première erreur de fusion (types incompatibles).
+func (ms *MetricsSnapshot) Merge(other *MetricsSnapshot) error {
+	var firstErr error
+	for _, metric := range other.GetAllMetrics() {
+		target := ms.GetMetricWithLabels(metric.Name, metric.Labels, metric.Type)
+		if err := target.Merge(metric); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
 func (ms *MetricsSnapshot) Reset() {
     ms.mu.Lock()
     defer ms.mu.Unlock()
  
     ms.Metrics = make(map[string]*Metric)
-    ms.Timestamp = time.Now()
+    ms.families = make(map[string]int)
+    ms.Timestamp = orSystemClock(ms.opts.Clock).Now()
 }
 
 // TimeWindow represente une fenetre de temps pour l'agrégation des métriques
 type TimeWindow struct {
+    Spec      string         `json:"spec"` // nom de la WindowSpec qui a produit la fenêtre
+    Key       string         `json:"key,omitempty"` // clé de session (fenêtres de session uniquement)
     StartTime time.Time      `json:"start_time"`
     EndTime  time.Time      `json:"end_time"`
     Duration time.Duration   `json:"duration"`
     Metrics  *MetricsSnapshot `json:"metrics"`
     Closed   bool            `json:"closed"`
+    Revision int             `json:"revision"` // 0 à la première émission, +1 par amendement tardif
+    amended  bool            // mise à jour tardive en attente de réémission
+    session  *sessionSummary // résumé d'une fenêtre de session fermée (métrique engagement)
 }
 
 // NewTimeWindow crée une nouvelle fenêtre de temps
 func NewTimeWindow(startTime time.Time, duration time.Duration) *TimeWindow {
+	return newTimeWindow(startTime, duration, SnapshotOptions{})
+}
+
+// newTimeWindow crée une fenêtre dont les métriques suivent opts
+func newTimeWindow(startTime time.Time, duration time.Duration, opts SnapshotOptions) *TimeWindow {
 	return &TimeWindow{
 		StartTime: startTime,
 		EndTime:   startTime.Add(duration),
 		Duration:  duration,
-		Metrics:   NewMetricsSnapshot(),
+		Metrics:   NewMetricsSnapshotWithOptions(opts),
 		Closed:    false,
 	}
 }
@@ -188,49 +652,116 @@ func (tw *TimeWindow) Close() {
 
 // WindowManager gere les fenêtres de temps
 type WindowManager struct {
-	Windows  []*TimeWindow
-	duration time.Duration
-	mu       sync.RWMutex
+	Windows   []*TimeWindow
+	spec      WindowSpec
+	duration  time.Duration
+	watermark time.Time // temps d'événement en deçà duquel les fenêtres sont fermées
+	clock     Clock
+	metrics   SnapshotOptions // options des métriques des fenêtres créées
+	mu        sync.RWMutex
 }
 
 // NewWindowManager crée un nouveau gestionnaire de fenêtres de temps
-func NewWindowManager(duration time.Duration) *WindowManager {
+// (clock nil: horloge murale)
+func NewWindowManager(duration time.Duration, clock Clock) *WindowManager {
+	return NewWindowManagerForSpec(WindowSpec{Kind: WindowKindTumbling, Size: duration}, clock)
+}
+
+// NewWindowManagerForSpec crée un gestionnaire de fenêtres pour une spec donnée
+func NewWindowManagerForSpec(spec WindowSpec, clock Clock) *WindowManager {
+	return newWindowManager(spec, SnapshotOptions{Clock: clock})
+}
+
+// newWindowManager crée un gestionnaire dont les fenêtres suivent opts
+func newWindowManager(spec WindowSpec, opts SnapshotOptions) *WindowManager {
+	spec = spec.normalize()
+	opts.Clock = orSystemClock(opts.Clock)
 	return &WindowManager{
 		Windows:  make([]*TimeWindow, 0),
-		duration: duration,
+		spec:     spec,
+		duration: spec.Size,
+		clock:    opts.Clock,
+		metrics:  opts,
 	}
 }
 
+// Spec retourne la spec gérée par ce gestionnaire
+func (wm *WindowManager) Spec() WindowSpec {
+	return wm.spec
+}
+
 // GetOrCreateWindow récupère ou crée une fenêtre de temps active
+// Pour une spec sliding, retourne la plus récente des fenêtres qui contiennent t.
+// Retourne nil si la fenêtre a dépassé la période de retard autorisé.
 func (wm *WindowManager) GetOrCreateWindow(t time.Time) *TimeWindow {
 	wm.mu.Lock()
 	defer wm.mu.Unlock()
- 
+
 	// Arrondir le temps au début de la fenêtre
-	windowStart := t.Truncate(wm.duration)
- 
+	windowStart := wm.windowStarts(t)[0]
+	if !wm.acceptsLocked(windowStart) {
+		return nil
+	}
+	return wm.findOrCreateLocked(windowStart)
+}
+
+// GetOrCreateWindows récupère ou crée toutes les fenêtres qui contiennent t
+// (une seule en tumbling, size/step en sliding), hors fenêtres trop anciennes
+func (wm *WindowManager) GetOrCreateWindows(t time.Time) []*TimeWindow {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	starts := wm.windowStarts(t)
+	windows := make([]*TimeWindow, 0, len(starts))
+	for _, start := range starts {
+		if wm.acceptsLocked(start) {
+			windows = append(windows, wm.findOrCreateLocked(start))
+		}
+	}
+	return windows
+}
+
+// windowStarts calcule les débuts des fenêtres contenant t, du plus récent au plus ancien
+func (wm *WindowManager) windowStarts(t time.Time) []time.Time {
+	if wm.spec.Kind != WindowKindSliding || wm.spec.Step <= 0 {
+		return []time.Time{t.Truncate(wm.duration)}
+	}
+
+	step := wm.spec.Step
+	starts := make([]time.Time, 0, int(wm.duration/step)+1)
+	for start := t.Truncate(step); start.Add(wm.duration).After(t); start = start.Add(-step) {
+		starts = append(starts, start)
+	}
+	return starts
+}
+
+// findOrCreateLocked retourne la fenêtre qui commence à start (wm.mu doit être tenu)
+// Une fenêtre déjà fermée est réutilisée: on ne crée jamais de doublon
+func (wm *WindowManager) findOrCreateLocked(windowStart time.Time) *TimeWindow {
 	//chercher une fenêtre existante
 	for _, window := range wm.Windows {
-		if window.StartTime.Equal(windowStart) && !window.Closed {
+		if window.StartTime.Equal(windowStart) {
 			return window
 		}
 	}
- 
+
 	//créer une nouvelle fenêtre
-	window := NewTimeWindow(windowStart, wm.duration)
+	window := newTimeWindow(windowStart, wm.duration, wm.metrics)
+	window.Spec = wm.spec.Name
 	wm.Windows = append(wm.Windows, window)
 	return window
 }
 
-// CloseExpiredWindows ferme les fenêtres expirées
+// CloseExpiredWindows avance le watermark jusqu'à t et ferme les fenêtres expirées
 func (wm *WindowManager) CloseExpiredWindows(t time.Time) []*TimeWindow {
 	wm.mu.Lock()
 	defer wm.mu.Unlock()
- 
+
+	wm.advanceWatermarkLocked(t)
 	closedWindows := make([]*TimeWindow, 0)
- 
+
 	for _, window := range wm.Windows {
-		if window.ShouldClose(t) {
+		if window.ShouldClose(wm.watermark) {
 			window.Close()
 			closedWindows = append(closedWindows, window)
 		}
@@ -239,11 +770,15 @@ func (wm *WindowManager) CloseExpiredWindows(t time.Time) []*TimeWindow {
 }
 
 // Cleanup nettoie les anciennes fenêtres fermées
+// Les fenêtres sont toujours conservées pendant la période de retard autorisé
 func (wm *WindowManager) Cleanup(keepDuration time.Duration) {
 	wm.mu.Lock()
 	defer wm.mu.Unlock()
- 
-	now := time.Now()
+
+	if keepDuration < wm.spec.AllowedLateness {
+		keepDuration = wm.spec.AllowedLateness
+	}
+	now := wm.clock.Now()
 	activeWindows := make([]*TimeWindow, 0)
  
 	for _, window := range wm.Windows {
@@ -255,6 +790,28 @@ func (wm *WindowManager) Cleanup(keepDuration time.Duration) {
 	wm.Windows = activeWindows
 }
 
+// LatestWindow retourne la dernière fenêtre fermée (complète), ou à défaut la
+// fenêtre active la plus récente; nil si le gestionnaire n'a aucune fenêtre
+func (wm *WindowManager) LatestWindow() *TimeWindow {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+
+	var latestClosed, latestActive *TimeWindow
+	for _, window := range wm.Windows {
+		if window.Closed {
+			if latestClosed == nil || window.StartTime.After(latestClosed.StartTime) {
+				latestClosed = window
+			}
+		} else if latestActive == nil || window.StartTime.After(latestActive.StartTime) {
+			latestActive = window
+		}
+	}
+	if latestClosed != nil {
+		return latestClosed
+	}
+	return latestActive
+}
+
 // GetActiveWindows retourne toutes les fenêtres actives
 func (wm *WindowManager) GetActiveWindows() []*TimeWindow {
 	wm.mu.RLock()
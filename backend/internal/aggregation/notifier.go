@@ -0,0 +1,119 @@
+package aggregation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// Notifier reçoit les transitions des alertes (firing, resolved). Notify doit
+// respecter l'échéance du contexte et supporter des appels concurrents.
+type Notifier interface {
+	Notify(ctx context.Context, notification AlertNotification) error
+}
+
+// LogNotifier journalise les notifications
+type LogNotifier struct {
+	logger *zap.Logger
+}
+
+// NewLogNotifier crée un notifier qui journalise avec logger
+func NewLogNotifier(logger *zap.Logger) *LogNotifier {
+	return &LogNotifier{logger: logger}
+}
+
+// Notify journalise une alerte déclenchée en warn, une alerte résolue en info
+func (n *LogNotifier) Notify(ctx context.Context, notification AlertNotification) error {
+	fields := []zap.Field{
+		zap.String("rule", notification.Rule),
+		zap.String("severity", notification.Severity),
+		zap.String("spec", notification.Spec),
+		zap.String("series", notification.Series),
+		zap.String("condition", fmt.Sprintf("%s %g", notification.Op, notification.Threshold)),
+		zap.Float64("value", notification.Value),
+		zap.Time("window_start", notification.WindowStart),
+	}
+	if notification.State == AlertFiring {
+		n.logger.Warn("alert firing", fields...)
+	} else {
+		n.logger.Info("alert resolved", fields...)
+	}
+	return nil
+}
+
+// FileNotifier ajoute chaque notification en JSON, une par ligne, à un fichier
+type FileNotifier struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewFileNotifier crée un notifier qui écrit dans path (créé si besoin)
+func NewFileNotifier(path string) *FileNotifier {
+	return &FileNotifier{path: path}
+}
+
+// Notify ajoute la notification à la fin du fichier
+func (n *FileNotifier) Notify(ctx context.Context, notification AlertNotification) error {
+	line, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	file, err := os.OpenFile(n.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open alert file: %w", err)
+	}
+	if _, err := file.Write(line); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write alert file: %w", err)
+	}
+	return file.Close()
+}
+
+// WebhookNotifier envoie chaque notification en JSON par POST à une URL
+type WebhookNotifier struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+// NewWebhookNotifier crée un notifier webhook; headers est ajouté à chaque requête
+func NewWebhookNotifier(url string, headers map[string]string) *WebhookNotifier {
+	return &WebhookNotifier{url: url, headers: headers, client: &http.Client{}}
+}
+
+// Notify poste la notification; une réponse hors 2xx est une erreur
+func (n *WebhookNotifier) Notify(ctx context.Context, notification AlertNotification) error {
+	body, err := json.Marshal(notification)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range n.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post alert webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("alert webhook returned %s", resp.Status)
+	}
+	return nil
+}
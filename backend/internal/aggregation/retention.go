@@ -0,0 +1,388 @@
+package aggregation
+
+import (
+	"fmt"
+	"math/bits"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RetentionPeriod est la granularité des cohortes de rétention
+type RetentionPeriod string
+
+const (
+	RetentionDay  RetentionPeriod = "day"  // cohortes par jour UTC, rétention jour N
+	RetentionWeek RetentionPeriod = "week" // cohortes par semaine (lundi UTC), rétention semaine N
+)
+
+// Horizons par défaut de la rétention
+const (
+	DefaultRetentionDays  = 30
+	DefaultRetentionWeeks = 12
+)
+
+// retentionStripes est le nombre de verrous de l'activité des utilisateurs
+const retentionStripes = 32
+
+// RetentionOptions configure le suivi de rétention
+type RetentionOptions struct {
+	// Days est le dernier jour N suivi (0: DefaultRetentionDays)
+	Days int
+	// Weeks est la dernière semaine N suivie (0: DefaultRetentionWeeks)
+	Weeks int
+	// Clock borne les colonnes observables des matrices (nil: horloge murale)
+	Clock Clock
+}
+
+// userActivity retient le premier jour d'un utilisateur et ses jours d'activité,
+// en bits par écart au premier jour
+type userActivity struct {
+	first time.Time
+	days  []uint64
+}
+
+// has indique si l'utilisateur était actif offset jours après son premier jour
+func (u *userActivity) has(offset int) bool {
+	return u.days[offset/64]&(1<<(offset%64)) != 0
+}
+
+// set marque l'activité offset jours après le premier jour
+func (u *userActivity) set(offset int) {
+	u.days[offset/64] |= 1 << (offset % 64)
+}
+
+// Retention construit les matrices de rétention par cohorte: parmi les
+// utilisateurs vus pour la première fois le jour D (ou la semaine W), combien
+// sont revenus le jour D+N (la semaine W+N).
+//
+// Le premier jour et les jours d'activité de chaque utilisateur sont gardés
+// en mémoire (un bit par jour sur l'horizon suivi), et les matrices sont
+// mises à jour à la première activité d'un utilisateur dans un jour: le coût
+// par événement est une recherche dans une table. Un événement antérieur au
+// premier jour connu déplace l'utilisateur vers la cohorte plus ancienne.
+// Un utilisateur dont l'horizon est dépassé est oublié (voir expire).
+type Retention struct {
+	days    int
+	weeks   int
+	horizon int // jours d'activité suivis après le premier jour
+	clock   Clock
+
+	stripes [retentionStripes]struct {
+		mu    sync.Mutex
+		users map[string]*userActivity
+	}
+
+	// Comptes par cohorte: retained[N] utilisateurs actifs N périodes après
+	// leur première activité (retained[0] est la taille de la cohorte)
+	mu          sync.RWMutex
+	dayCohorts  map[time.Time][]int64
+	weekCohorts map[time.Time][]int64
+	expired     time.Time // dernier jour où les utilisateurs ont été expirés
+}
+
+// NewRetention crée un suivi de rétention vide
+func NewRetention(opts RetentionOptions) *Retention {
+	if opts.Days <= 0 {
+		opts.Days = DefaultRetentionDays
+	}
+	if opts.Weeks <= 0 {
+		opts.Weeks = DefaultRetentionWeeks
+	}
+
+	// La semaine N d'une cohorte finit au plus 7N+6 jours après le premier jour
+	horizon := opts.Days
+	if weekDays := 7*opts.Weeks + 6; weekDays > horizon {
+		horizon = weekDays
+	}
+
+	r := &Retention{
+		days:        opts.Days,
+		weeks:       opts.Weeks,
+		horizon:     horizon,
+		clock:       orSystemClock(opts.Clock),
+		dayCohorts:  make(map[time.Time][]int64),
+		weekCohorts: make(map[time.Time][]int64),
+	}
+	for i := range r.stripes {
+		r.stripes[i].users = make(map[string]*userActivity)
+	}
+	return r
+}
+
+// retentionDay retourne le début du jour UTC de t
+func retentionDay(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// retentionWeek retourne le lundi UTC de la semaine de t
+func retentionWeek(t time.Time) time.Time {
+	day := retentionDay(t)
+	return day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+}
+
+// daysBetween retourne le nombre de jours entre deux débuts de jour UTC
+func daysBetween(from, to time.Time) int {
+	return int(to.Sub(from).Hours() / 24)
+}
+
+// Track enregistre l'activité d'un événement (utilisateurs identifiés seulement)
+func (r *Retention) Track(event Event) {
+	r.Record(event.UserID, event.Timestamp)
+}
+
+// Record enregistre une activité de userID à t. Sert aussi à reconstruire
+// l'état à partir de l'historique (table events).
+func (r *Retention) Record(userID string, t time.Time) {
+	if userID == "" {
+		return
+	}
+	day := retentionDay(t)
+
+	stripe := &r.stripes[hashString(userID)%retentionStripes]
+	stripe.mu.Lock()
+	defer stripe.mu.Unlock()
+
+	user := stripe.users[userID]
+	switch {
+	case user == nil:
+		user = &userActivity{first: day, days: make([]uint64, (r.horizon+64)/64)}
+		user.set(0)
+		stripe.users[userID] = user
+		r.mu.Lock()
+		r.contribute(user, 1)
+		r.mu.Unlock()
+
+	case day.Before(user.first):
+		// Première activité plus ancienne: changer de cohorte
+		r.mu.Lock()
+		r.contribute(user, -1)
+		r.rebase(user, day)
+		r.contribute(user, 1)
+		r.mu.Unlock()
+
+	default:
+		offset := daysBetween(user.first, day)
+		if offset > r.horizon || user.has(offset) {
+			return
+		}
+		r.mu.Lock()
+		r.addDay(user, offset)
+		r.mu.Unlock()
+	}
+}
+
+// rebase déplace le premier jour de user à first (antérieur), en décalant ses
+// jours d'activité; ceux qui sortent de l'horizon sont oubliés
+func (r *Retention) rebase(user *userActivity, first time.Time) {
+	shift := daysBetween(first, user.first)
+	previous := user.days
+	user.days = make([]uint64, len(previous))
+	user.first = first
+	user.set(0)
+	for offset := 0; offset <= r.horizon; offset++ {
+		if offset+shift > r.horizon {
+			break
+		}
+		if previous[offset/64]&(1<<(offset%64)) != 0 {
+			user.set(offset + shift)
+		}
+	}
+}
+
+// addDay marque un nouveau jour d'activité et met à jour les cohortes
+// (r.mu doit être tenu)
+func (r *Retention) addDay(user *userActivity, offset int) {
+	week := r.weekOffset(user, offset)
+	newWeek := true
+	for other := 0; other <= r.horizon; other++ {
+		if user.has(other) && r.weekOffset(user, other) == week {
+			newWeek = false
+			break
+		}
+	}
+
+	user.set(offset)
+	if offset <= r.days {
+		r.cohort(r.dayCohorts, user.first, r.days)[offset]++
+	}
+	if newWeek && week <= r.weeks {
+		r.cohort(r.weekCohorts, retentionWeek(user.first), r.weeks)[week]++
+	}
+}
+
+// contribute ajoute (sign=1) ou retire (sign=-1) toute l'activité de user
+// des cohortes (r.mu doit être tenu)
+func (r *Retention) contribute(user *userActivity, sign int64) {
+	dayCohort := r.cohort(r.dayCohorts, user.first, r.days)
+	weekStart := retentionWeek(user.first)
+	weekCohort := r.cohort(r.weekCohorts, weekStart, r.weeks)
+
+	seenWeeks := make(map[int]bool)
+	for i, word := range user.days {
+		for word != 0 {
+			offset := i*64 + bits.TrailingZeros64(word)
+			word &= word - 1
+			if offset <= r.days {
+				dayCohort[offset] += sign
+			}
+			if week := r.weekOffset(user, offset); week <= r.weeks && !seenWeeks[week] {
+				seenWeeks[week] = true
+				weekCohort[week] += sign
+			}
+		}
+	}
+
+	// Une cohorte vidée par un changement de cohorte disparaît
+	if dayCohort[0] == 0 {
+		delete(r.dayCohorts, user.first)
+	}
+	if weekCohort[0] == 0 {
+		delete(r.weekCohorts, weekStart)
+	}
+}
+
+// weekOffset retourne la semaine N d'un jour d'activité de user
+func (r *Retention) weekOffset(user *userActivity, offset int) int {
+	cohortWeek := retentionWeek(user.first)
+	return daysBetween(cohortWeek, retentionWeek(user.first.AddDate(0, 0, offset))) / 7
+}
+
+// cohort retourne les comptes d'une cohorte, créés au besoin
+func (r *Retention) cohort(cohorts map[time.Time][]int64, start time.Time, periods int) []int64 {
+	counts, ok := cohorts[start]
+	if !ok {
+		counts = make([]int64, periods+1)
+		cohorts[start] = counts
+	}
+	return counts
+}
+
+// RetentionCohort est une ligne de matrice de rétention
+type RetentionCohort struct {
+	Start    time.Time `json:"cohort"`
+	Users    int64     `json:"users"`
+	Retained []int64   `json:"retained"` // retained[N]: utilisateurs actifs N périodes plus tard
+	Rates    []float64 `json:"rates"`    // retained[N] / users
+}
+
+// RetentionMatrix est une matrice de rétention par cohorte
+type RetentionMatrix struct {
+	Period  RetentionPeriod   `json:"period"`
+	Periods int               `json:"periods"` // dernière période N suivie
+	Cohorts []RetentionCohort `json:"cohorts"` // triées par début
+}
+
+// Matrix retourne la matrice de rétention des cohortes commençant dans
+// [from, to) (bornes nulles: toutes les cohortes). Les périodes qui ne sont
+// pas encore commencées selon l'horloge sont omises de chaque ligne.
+func (r *Retention) Matrix(period RetentionPeriod, from, to time.Time) (RetentionMatrix, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var cohorts map[time.Time][]int64
+	var periods int
+	var current time.Time
+	now := r.clock.Now()
+	switch period {
+	case RetentionDay:
+		cohorts, periods, current = r.dayCohorts, r.days, retentionDay(now)
+	case RetentionWeek:
+		cohorts, periods, current = r.weekCohorts, r.weeks, retentionWeek(now)
+	default:
+		return RetentionMatrix{}, fmt.Errorf("unknown retention period %q", period)
+	}
+
+	matrix := RetentionMatrix{Period: period, Periods: periods, Cohorts: make([]RetentionCohort, 0)}
+	for start, counts := range cohorts {
+		if (!from.IsZero() && start.Before(from)) || (!to.IsZero() && !start.Before(to)) {
+			continue
+		}
+
+		observed := daysBetween(start, current) + 1
+		if period == RetentionWeek {
+			observed = daysBetween(start, current)/7 + 1
+		}
+		if observed > len(counts) {
+			observed = len(counts)
+		}
+		if observed < 1 {
+			observed = 1 // cohorte alimentée par des événements en avance sur l'horloge
+		}
+
+		row := RetentionCohort{
+			Start:    start,
+			Users:    counts[0],
+			Retained: append([]int64(nil), counts[:observed]...),
+			Rates:    make([]float64, observed),
+		}
+		for n, retained := range row.Retained {
+			if row.Users > 0 {
+				row.Rates[n] = float64(retained) / float64(row.Users)
+			}
+		}
+		matrix.Cohorts = append(matrix.Cohorts, row)
+	}
+	sort.Slice(matrix.Cohorts, func(i, j int) bool {
+		return matrix.Cohorts[i].Start.Before(matrix.Cohorts[j].Start)
+	})
+	return matrix, nil
+}
+
+// expire oublie les utilisateurs dont le premier jour plus l'horizon suivi
+// est antérieur au jour de now: aucune activité ne peut plus changer leurs
+// cohortes, dont les comptes restent. Un utilisateur oublié qui revient
+// ouvre une nouvelle cohorte. Les utilisateurs sont parcourus au plus une
+// fois par jour; retourne le nombre d'utilisateurs oubliés.
+func (r *Retention) expire(now time.Time) int {
+	today := retentionDay(now)
+	r.mu.Lock()
+	if !today.After(r.expired) {
+		r.mu.Unlock()
+		return 0
+	}
+	r.expired = today
+	r.mu.Unlock()
+
+	removed := 0
+	for i := range r.stripes {
+		stripe := &r.stripes[i]
+		stripe.mu.Lock()
+		for userID, user := range stripe.users {
+			if user.first.AddDate(0, 0, r.horizon).Before(today) {
+				delete(stripe.users, userID)
+				removed++
+			}
+		}
+		stripe.mu.Unlock()
+	}
+	return removed
+}
+
+// reset oublie tous les utilisateurs et toutes les cohortes
+func (r *Retention) reset() {
+	for i := range r.stripes {
+		stripe := &r.stripes[i]
+		stripe.mu.Lock()
+		stripe.users = make(map[string]*userActivity)
+		stripe.mu.Unlock()
+	}
+	r.mu.Lock()
+	r.dayCohorts = make(map[time.Time][]int64)
+	r.weekCohorts = make(map[time.Time][]int64)
+	r.expired = time.Time{}
+	r.mu.Unlock()
+}
+
+// Users retourne le nombre d'utilisateurs suivis
+func (r *Retention) Users() int {
+	count := 0
+	for i := range r.stripes {
+		stripe := &r.stripes[i]
+		stripe.mu.Lock()
+		count += len(stripe.users)
+		stripe.mu.Unlock()
+	}
+	return count
+}
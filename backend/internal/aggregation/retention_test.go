@@ -0,0 +1,169 @@
+package aggregation
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TestRetentionDailyAndWeekly teste les matrices jour N et semaine N
+func TestRetentionDailyAndWeekly(t *testing.T) {
+	monday := time.Date(2025, 1, 6, 9, 0, 0, 0, time.UTC)
+	clock := NewManualClock(monday.AddDate(0, 0, 8))
+	retention := NewRetention(RetentionOptions{Days: 7, Weeks: 2, Clock: clock})
+
+	// Cohorte du lundi: 10 utilisateurs, 5 reviennent le lendemain, 2 le jour 7
+	for i := 0; i < 10; i++ {
+		user := fmt.Sprintf("user_%d", i)
+		retention.Record(user, monday)
+		retention.Record(user, monday.Add(2*time.Hour)) // même jour: compté une fois
+		if i < 5 {
+			retention.Record(user, monday.AddDate(0, 0, 1))
+		}
+		if i < 2 {
+			retention.Record(user, monday.AddDate(0, 0, 7))
+		}
+	}
+	// Cohorte du mardi: 4 utilisateurs, un revient le jeudi
+	for i := 0; i < 4; i++ {
+		retention.Record(fmt.Sprintf("late_%d", i), monday.AddDate(0, 0, 1))
+	}
+	retention.Record("late_0", monday.AddDate(0, 0, 3))
+
+	daily, err := retention.Matrix(RetentionDay, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(daily.Cohorts) != 2 {
+		t.Fatalf("Expected 2 daily cohorts, got %d", len(daily.Cohorts))
+	}
+	first := daily.Cohorts[0]
+	if !first.Start.Equal(retentionDay(monday)) || first.Users != 10 {
+		t.Fatalf("Unexpected first cohort %s with %d users", first.Start, first.Users)
+	}
+	// Jours 0 à 7 observables au jour 8
+	if len(first.Retained) != 8 || first.Retained[1] != 5 || first.Retained[2] != 0 || first.Retained[7] != 2 {
+		t.Errorf("Unexpected day N retention %v", first.Retained)
+	}
+	if first.Rates[1] != 0.5 || first.Rates[0] != 1 {
+		t.Errorf("Unexpected day N rates %v", first.Rates)
+	}
+	if second := daily.Cohorts[1]; second.Users != 4 || second.Retained[2] != 1 {
+		t.Errorf("Unexpected tuesday cohort %+v", second)
+	}
+
+	// Semaines: les deux cohortes tombent dans la semaine du lundi 6 janvier
+	weekly, err := retention.Matrix(RetentionWeek, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(weekly.Cohorts) != 1 || weekly.Cohorts[0].Users != 14 {
+		t.Fatalf("Expected one weekly cohort of 14 users, got %+v", weekly.Cohorts)
+	}
+	if retained := weekly.Cohorts[0].Retained; len(retained) != 2 || retained[1] != 2 {
+		t.Errorf("Expected 2 users back in week 1, got %v", retained)
+	}
+
+	// Filtre par début de cohorte
+	filtered, _ := retention.Matrix(RetentionDay, monday.AddDate(0, 0, 1).Truncate(24*time.Hour), time.Time{})
+	if len(filtered.Cohorts) != 1 || filtered.Cohorts[0].Users != 4 {
+		t.Errorf("Expected only the tuesday cohort, got %+v", filtered.Cohorts)
+	}
+	if _, err := retention.Matrix("month", time.Time{}, time.Time{}); err == nil {
+		t.Error("Expected an error for an unknown period")
+	}
+}
+
+// TestRetentionEarlierFirstSeen teste le changement de cohorte d'une activité plus ancienne
+func TestRetentionEarlierFirstSeen(t *testing.T) {
+	start := time.Date(2025, 1, 6, 12, 0, 0, 0, time.UTC)
+	retention := NewRetention(RetentionOptions{Days: 7, Clock: NewManualClock(start.AddDate(0, 0, 7))})
+
+	// L'historique arrive dans le désordre
+	retention.Record("u1", start.AddDate(0, 0, 2))
+	retention.Record("u1", start.AddDate(0, 0, 3))
+	retention.Record("u1", start)
+
+	matrix, _ := retention.Matrix(RetentionDay, time.Time{}, time.Time{})
+	if len(matrix.Cohorts) != 1 {
+		t.Fatalf("Expected the later cohort to be removed, got %+v", matrix.Cohorts)
+	}
+	cohort := matrix.Cohorts[0]
+	if !cohort.Start.Equal(retentionDay(start)) || cohort.Users != 1 ||
+		cohort.Retained[2] != 1 || cohort.Retained[3] != 1 || cohort.Retained[1] != 0 {
+		t.Errorf("Unexpected rebased cohort %+v", cohort)
+	}
+	if retention.Users() != 1 {
+		t.Errorf("Expected 1 tracked user, got %d", retention.Users())
+	}
+}
+
+// TestRetentionExpire teste l'oubli des utilisateurs sortis de l'horizon
+func TestRetentionExpire(t *testing.T) {
+	start := time.Date(2025, 1, 6, 12, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	retention := NewRetention(RetentionOptions{Days: 7, Weeks: 1, Clock: clock})
+
+	// Horizon de 13 jours (semaine 1 finie au plus 7+6 jours après le premier jour)
+	retention.Record("old", start)
+	retention.Record("old", start.AddDate(0, 0, 1))
+	retention.Record("recent", start.AddDate(0, 0, 1))
+
+	if removed := retention.expire(start.AddDate(0, 0, 13)); removed != 0 || retention.Users() != 2 {
+		t.Fatalf("Expected no user to expire within the horizon, got %d removed", removed)
+	}
+	// Déjà balayé ce jour: rien à faire
+	if removed := retention.expire(start.AddDate(0, 0, 13).Add(time.Hour)); removed != 0 {
+		t.Errorf("Expected a single sweep per day, got %d removed", removed)
+	}
+	if removed := retention.expire(start.AddDate(0, 0, 14)); removed != 1 || retention.Users() != 1 {
+		t.Fatalf("Expected the oldest user to expire, got %d removed and %d left", removed, retention.Users())
+	}
+
+	// Les cohortes restent; un utilisateur oublié qui revient ouvre une nouvelle cohorte
+	retention.Record("old", start.AddDate(0, 0, 14))
+	clock.Set(start.AddDate(0, 0, 14))
+	matrix, _ := retention.Matrix(RetentionDay, time.Time{}, time.Time{})
+	if len(matrix.Cohorts) != 3 || matrix.Cohorts[0].Users != 1 || matrix.Cohorts[0].Retained[1] != 1 {
+		t.Errorf("Unexpected cohorts after expiry %+v", matrix.Cohorts)
+	}
+}
+
+// TestAggregatorRetention teste le suivi de rétention depuis le flux d'événements
+func TestAggregatorRetention(t *testing.T) {
+	start := time.Date(2025, 1, 6, 12, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start.AddDate(0, 0, 1))
+	agg := NewAggregatorWithOptions(Options{
+		Windows:       []WindowSpec{{Kind: WindowKindTumbling, Size: time.Minute}},
+		FlushInterval: 10 * time.Second,
+		Clock:         clock,
+		Retention:     &RetentionOptions{},
+	}, zap.NewNop())
+
+	for i := 0; i < 3; i++ {
+		agg.ProcessEvent(Event{Type: "pageview", UserID: fmt.Sprintf("user_%d", i), Timestamp: start})
+	}
+	agg.ProcessEvent(Event{Type: "pageview", UserID: "user_0", Timestamp: start.AddDate(0, 0, 1)})
+	agg.ProcessEvent(Event{Type: "pageview", SessionID: "anonymous", Timestamp: start})
+
+	matrix, err := agg.Retention().Matrix(RetentionDay, time.Time{}, time.Time{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(matrix.Cohorts) != 1 || matrix.Cohorts[0].Users != 3 || matrix.Cohorts[0].Retained[1] != 1 {
+		t.Errorf("Unexpected cohorts %+v", matrix.Cohorts)
+	}
+	if users := agg.GetStats()["retention_users"]; users != 3 {
+		t.Errorf("Expected 3 tracked users, got %v", users)
+	}
+
+	agg.Reset()
+	if agg.Retention().Users() != 0 {
+		t.Error("Expected reset to forget tracked users")
+	}
+	if NewAggregator(time.Minute, time.Second, zap.NewNop()).Retention() != nil {
+		t.Error("Expected retention to be disabled by default")
+	}
+}
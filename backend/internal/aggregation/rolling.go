@@ -0,0 +1,301 @@
+package aggregation
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultRollingRanges sont les durées glissantes servies par défaut
+var DefaultRollingRanges = []time.Duration{time.Hour, 24 * time.Hour}
+
+// RollingOptions configure les agrégats glissants ("dernière heure",
+// "dernières 24 heures") des métriques globales, plutôt que depuis le
+// démarrage comme les totaux
+type RollingOptions struct {
+	// Spec est la spec tumbling dont la taille fixe la résolution de
+	// l'anneau ("": la plus petite)
+	Spec string
+	// Ranges liste les durées servies (nil: DefaultRollingRanges)
+	Ranges []time.Duration
+}
+
+// RollingMetrics est l'agrégat des métriques globales d'une durée glissante
+type RollingMetrics struct {
+	Range   time.Duration    `json:"range"`
+	From    time.Time        `json:"from"`    // début de la période couverte
+	To      time.Time        `json:"to"`      // fin de la période couverte (maintenant)
+	Windows int              `json:"windows"` // périodes fusionnées
+	Metrics *MetricsSnapshot `json:"metrics"`
+}
+
+// rollingBucket est le delta des métriques globales d'une période de l'anneau
+type rollingBucket struct {
+	start   time.Time
+	metrics *MetricsSnapshot
+}
+
+// rollingCache est l'agrégat courant des périodes fermées d'une durée, de
+// first (inclus) à open (exclu, période en cours)
+type rollingCache struct {
+	first   time.Time
+	open    time.Time
+	windows int
+	metrics *MetricsSnapshot
+}
+
+// Rolling garde dans un anneau les métriques globales (mêmes noms que les
+// totaux: total_events, unique_users, règles globales, étapes des funnels...)
+// par période de la taille de sa spec, sur la plus longue durée servie. Les
+// shards y fusionnent leurs deltas par période de l'heure des événements, y
+// compris la période en cours et les événements en retard. L'agrégat d'une
+// durée fusionne les périodes qui ont commencé depuis moins de cette durée.
+// Celui de ses périodes fermées est gardé en cache et tenu à jour par les
+// fusions (événements en retard compris), puis recalculé quand une période se
+// ferme; une lecture n'y ajoute que la période en cours. L'anneau fait partie
+// des checkpoints.
+type Rolling struct {
+	spec    WindowSpec
+	ranges  []time.Duration
+	longest time.Duration
+	opts    SnapshotOptions
+	clock   Clock
+
+	buckets []*rollingBucket // triés par début
+	since   time.Time        // début de la première période observée
+	cache   map[time.Duration]*rollingCache
+	mu      sync.Mutex
+}
+
+// newRolling crée les agrégats glissants, par période de la taille de spec
+func newRolling(spec WindowSpec, ranges []time.Duration, opts SnapshotOptions) *Rolling {
+	if len(ranges) == 0 {
+		ranges = DefaultRollingRanges
+	}
+	r := &Rolling{
+		spec:  spec,
+		clock: orSystemClock(opts.Clock),
+		cache: make(map[time.Duration]*rollingCache),
+	}
+	// Les débordements de cardinalité sont déjà comptés par les métriques globales
+	opts.OnOverflow = nil
+	r.opts = opts
+	for _, rng := range ranges {
+		if rng <= 0 {
+			continue
+		}
+		r.ranges = append(r.ranges, rng)
+		if rng > r.longest {
+			r.longest = rng
+		}
+	}
+	sort.Slice(r.ranges, func(i, j int) bool { return r.ranges[i] < r.ranges[j] })
+	return r
+}
+
+// Spec retourne le nom de la spec qui fixe la résolution
+func (r *Rolling) Spec() string {
+	return r.spec.Name
+}
+
+// Ranges retourne les durées servies, croissantes
+func (r *Rolling) Ranges() []time.Duration {
+	return append([]time.Duration(nil), r.ranges...)
+}
+
+// period retourne le début de la période de l'anneau qui contient t
+func (r *Rolling) period(t time.Time) time.Time {
+	return t.Truncate(r.spec.Size)
+}
+
+// merge fusionne des deltas des métriques globales, indexés par début de
+// période, dans l'anneau et dans les agrégats en cache qui couvrent leur
+// période; les périodes déjà sorties de l'anneau sont ignorées
+func (r *Rolling) merge(deltas map[time.Time]*MetricsSnapshot) error {
+	if len(deltas) == 0 {
+		return nil
+	}
+	oldest := r.period(r.clock.Now().Add(-r.longest))
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var mergeErr error
+	for start, delta := range deltas {
+		if start.Before(oldest) {
+			continue
+		}
+		if r.since.IsZero() || start.Before(r.since) {
+			r.since = start
+		}
+		i := sort.Search(len(r.buckets), func(i int) bool {
+			return !r.buckets[i].start.Before(start)
+		})
+		created := i == len(r.buckets) || !r.buckets[i].start.Equal(start)
+		if created {
+			r.buckets = append(r.buckets, nil)
+			copy(r.buckets[i+1:], r.buckets[i:])
+			r.buckets[i] = &rollingBucket{start: start, metrics: NewMetricsSnapshotWithOptions(r.opts)}
+		}
+		if err := r.buckets[i].metrics.Merge(delta); err != nil && mergeErr == nil {
+			mergeErr = err
+		}
+		for _, cached := range r.cache {
+			if start.Before(cached.first) || !start.Before(cached.open) {
+				continue
+			}
+			if created {
+				cached.windows++
+			}
+			if err := cached.metrics.Merge(delta); err != nil && mergeErr == nil {
+				mergeErr = err
+			}
+		}
+	}
+	r.expireLocked(oldest)
+	return mergeErr
+}
+
+// expireLocked oublie les périodes antérieures à oldest (r.mu doit être tenu)
+func (r *Rolling) expireLocked(oldest time.Time) {
+	expired := 0
+	for expired < len(r.buckets) && r.buckets[expired].start.Before(oldest) {
+		expired++
+	}
+	r.buckets = r.buckets[expired:]
+}
+
+// Metrics retourne l'agrégat de la durée rng (false si elle n'est pas servie).
+// From est le début de la première période de la durée, ou de la première
+// période observée si l'anneau est plus récent que la durée.
+func (r *Rolling) Metrics(rng time.Duration) (*RollingMetrics, bool) {
+	served := false
+	for _, candidate := range r.ranges {
+		served = served || candidate == rng
+	}
+	if !served {
+		return nil, false
+	}
+
+	now := r.clock.Now()
+	cutoff := r.period(now.Add(-rng))
+	if cutoff.Before(now.Add(-rng)) {
+		cutoff = cutoff.Add(r.spec.Size)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	result := &RollingMetrics{Range: rng}
+	if !r.since.IsZero() {
+		result.From, result.To = cutoff, now
+		if r.since.After(cutoff) {
+			result.From = r.since
+		}
+	}
+
+	// Agrégat des périodes fermées, recalculé quand la durée ne commence plus
+	// ou ne se ferme plus à la même période
+	open := r.period(now)
+	cached, ok := r.cache[rng]
+	if !ok || !cached.first.Equal(cutoff) || !cached.open.Equal(open) {
+		cached = &rollingCache{first: cutoff, open: open, metrics: NewMetricsSnapshotWithOptions(r.opts)}
+		for _, bucket := range r.buckets {
+			if bucket.start.Before(cutoff) || !bucket.start.Before(open) {
+				continue
+			}
+			cached.metrics.Merge(bucket.metrics)
+			cached.windows++
+		}
+		r.cache[rng] = cached
+	}
+
+	// La période en cours (et les suivantes) s'ajoute à une copie
+	result.Windows = cached.windows
+	result.Metrics = NewMetricsSnapshotWithOptions(r.opts)
+	result.Metrics.Merge(cached.metrics)
+	current := sort.Search(len(r.buckets), func(i int) bool {
+		return !r.buckets[i].start.Before(open)
+	})
+	for _, bucket := range r.buckets[current:] {
+		if bucket.start.Before(cutoff) {
+			continue
+		}
+		result.Metrics.Merge(bucket.metrics)
+		result.Windows++
+	}
+	return result, true
+}
+
+// RollingState est l'état sérialisable de l'anneau
+type RollingState struct {
+	Period  time.Duration        `json:"period"`
+	Since   time.Time            `json:"since"`
+	Buckets []RollingBucketState `json:"buckets"` // triées par début
+}
+
+// RollingBucketState est l'état d'une période de l'anneau
+type RollingBucketState struct {
+	Start   time.Time     `json:"start"`
+	Metrics SnapshotState `json:"metrics"`
+}
+
+// state retourne l'état de l'anneau
+func (r *Rolling) state() *RollingState {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	state := &RollingState{
+		Period:  r.spec.Size,
+		Since:   r.since,
+		Buckets: make([]RollingBucketState, 0, len(r.buckets)),
+	}
+	for _, bucket := range r.buckets {
+		state.Buckets = append(state.Buckets, RollingBucketState{Start: bucket.start, Metrics: bucket.metrics.State()})
+	}
+	return state
+}
+
+// bucketsFromState reconstruit les périodes d'un état de l'anneau; un état
+// absent ou d'une autre résolution n'en donne aucune
+func (r *Rolling) bucketsFromState(state *RollingState) ([]*rollingBucket, time.Time, error) {
+	if state == nil || state.Period != r.spec.Size {
+		return nil, time.Time{}, nil
+	}
+	buckets := make([]*rollingBucket, 0, len(state.Buckets))
+	for _, bucketState := range state.Buckets {
+		metrics, err := NewMetricsSnapshotFromState(bucketState.Metrics, r.opts)
+		if err != nil {
+			return nil, time.Time{}, fmt.Errorf("period at %s: %w", bucketState.Start, err)
+		}
+		buckets = append(buckets, &rollingBucket{start: bucketState.Start, metrics: metrics})
+	}
+	sort.Slice(buckets, func(i, j int) bool { return buckets[i].start.Before(buckets[j].start) })
+	return buckets, state.Since, nil
+}
+
+// replace remplace les périodes de l'anneau
+func (r *Rolling) replace(buckets []*rollingBucket, since time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buckets = buckets
+	r.since = since
+	r.cache = make(map[time.Duration]*rollingCache)
+}
+
+// count retourne le nombre de périodes gardées
+func (r *Rolling) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.buckets)
+}
+
+// reset oublie toutes les périodes
+func (r *Rolling) reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.buckets = nil
+	r.since = time.Time{}
+	r.cache = make(map[time.Duration]*rollingCache)
+}
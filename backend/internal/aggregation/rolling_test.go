@@ -0,0 +1,188 @@
+package aggregation
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TestRollingMetrics teste les agrégats glissants des métriques globales:
+// périodes incluses par durée, mêmes noms que les totaux, événements en
+// retard et période en cours, éviction des périodes trop anciennes
+func TestRollingMetrics(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	agg := NewAggregatorWithOptions(Options{
+		Windows: []WindowSpec{
+			{Kind: WindowKindTumbling, Size: time.Minute},
+			{Kind: WindowKindTumbling, Size: 5 * time.Minute},
+		},
+		FlushInterval: 10 * time.Second,
+		Clock:         clock,
+		Shards:        4,
+		Rolling:       &RollingOptions{Ranges: []time.Duration{time.Hour, 10 * time.Minute}},
+	}, zap.NewNop())
+
+	// 70 minutes de 2 achats (10 et 30) par un utilisateur différent chaque minute
+	for i := 0; i < 70; i++ {
+		at := start.Add(time.Duration(i) * time.Minute)
+		for _, amount := range []float64{10, 30} {
+			agg.ProcessEvent(Event{
+				Type:       "purchase",
+				UserID:     fmt.Sprintf("u%d", i),
+				Timestamp:  at,
+				Properties: map[string]interface{}{"amount": amount},
+			})
+		}
+		clock.Set(at.Add(time.Minute + time.Second))
+		agg.Flush()
+	}
+
+	if ranges := agg.RollingRanges(); len(ranges) != 2 || ranges[0] != 10*time.Minute {
+		t.Fatalf("Expected sorted ranges, got %v", ranges)
+	}
+	if _, ok := agg.RollingMetrics(2 * time.Hour); ok {
+		t.Error("Expected a range that is not served to be refused")
+	}
+
+	// 12:70:01 - 10m: les périodes de 61 à 69 (la plus petite spec, 1m)
+	now := start.Add(70*time.Minute + time.Second)
+	last10, ok := agg.RollingMetrics(10 * time.Minute)
+	if !ok || last10.Windows != 9 || !last10.From.Equal(start.Add(61*time.Minute)) || !last10.To.Equal(now) {
+		t.Fatalf("Unexpected 10m range %+v", last10)
+	}
+	checks := map[string]float64{
+		"total_events": 18,
+		"purchases":    18,
+		"revenue":      360,
+	}
+	for name, want := range checks {
+		if got, _ := last10.Metrics.GetMetricValue(name); got != want {
+			t.Errorf("Expected %s = %v over 10m, got %v", name, want, got)
+		}
+	}
+	if users := last10.Metrics.GetAllMetrics()["unique_users"]; users == nil || users.Count != 9 {
+		t.Errorf("Expected 9 unique users over 10m, got %+v", users)
+	}
+	if purchases := last10.Metrics.Query("events_by_type", Labels{"type": "purchase"}); len(purchases) != 1 || purchases[0].Value != 18 {
+		t.Errorf("Expected 18 purchases by type over 10m, got %+v", purchases)
+	}
+
+	// L'anneau ne garde qu'une heure de périodes (10 à 69)
+	lastHour, _ := agg.RollingMetrics(time.Hour)
+	if events, _ := lastHour.Metrics.GetMetricValue("total_events"); lastHour.Windows != 59 || events != 118 {
+		t.Errorf("Expected 59 periods and 118 events over 1h, got %d and %.0f", lastHour.Windows, events)
+	}
+	if stats := agg.GetStats(); stats["rolling_windows"] != 60 {
+		t.Errorf("Expected 60 periods in the ring, got %v", stats["rolling_windows"])
+	}
+
+	// Un événement en retard rejoint sa période, un événement de la minute en
+	// cours est servi sans attendre la fermeture de sa fenêtre
+	agg.ProcessEvent(Event{Type: "purchase", UserID: "u100", Timestamp: start.Add(69*time.Minute + 30*time.Second),
+		Properties: map[string]interface{}{"amount": 80.0}})
+	agg.ProcessEvent(Event{Type: "pageview", UserID: "u100", Timestamp: now})
+	last10, _ = agg.RollingMetrics(10 * time.Minute)
+	if revenue, _ := last10.Metrics.GetMetricValue("revenue"); revenue != 440 || last10.Windows != 10 {
+		t.Errorf("Expected 440 revenue over 10 periods, got %v over %d", revenue, last10.Windows)
+	}
+	if events, _ := last10.Metrics.GetMetricValue("total_events"); events != 20 {
+		t.Errorf("Expected 20 events over 10m, got %.0f", events)
+	}
+
+	// Sans nouvel événement, les plus anciennes sortent de la durée
+	clock.Set(start.Add(75*time.Minute + time.Second))
+	if last10, _ = agg.RollingMetrics(10 * time.Minute); last10.Windows != 5 {
+		t.Errorf("Expected 5 periods left in the last 10m, got %d", last10.Windows)
+	}
+}
+
+// TestRollingCheckpoint teste la période couverte d'un anneau plus récent que
+// la durée demandée et sa reprise depuis un checkpoint
+func TestRollingCheckpoint(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	newAggregator := func(clock Clock) *Aggregator {
+		return NewAggregatorWithOptions(Options{
+			Windows:       []WindowSpec{{Kind: WindowKindTumbling, Size: time.Minute}},
+			FlushInterval: 10 * time.Second,
+			Clock:         clock,
+			Rolling:       &RollingOptions{},
+		}, zap.NewNop())
+	}
+
+	clock := NewManualClock(start)
+	agg := newAggregator(clock)
+	for i := 0; i < 10; i++ {
+		agg.ProcessEvent(Event{Type: "pageview", UserID: fmt.Sprintf("u%d", i), Timestamp: start.Add(time.Duration(i) * time.Minute)})
+	}
+	clock.Set(start.Add(10 * time.Minute))
+
+	// Dix minutes d'événements: la dernière heure ne couvre que ces dix minutes
+	lastHour, _ := agg.RollingMetrics(time.Hour)
+	if !lastHour.From.Equal(start) || !lastHour.To.Equal(start.Add(10*time.Minute)) || lastHour.Windows != 10 {
+		t.Fatalf("Expected the covered span [12:00, 12:10), got %+v", lastHour)
+	}
+
+	data, err := EncodeCheckpoint(agg.Checkpoint())
+	if err != nil {
+		t.Fatalf("EncodeCheckpoint: %v", err)
+	}
+	checkpoint, err := DecodeCheckpoint(data)
+	if err != nil {
+		t.Fatalf("DecodeCheckpoint: %v", err)
+	}
+	restored := newAggregator(clock)
+	if err := restored.Restore(checkpoint); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+	restored.ProcessEvent(Event{Type: "pageview", UserID: "u10", Timestamp: start.Add(10 * time.Minute)})
+
+	lastHour, _ = restored.RollingMetrics(time.Hour)
+	if !lastHour.From.Equal(start) || lastHour.Windows != 11 {
+		t.Errorf("Expected the restored ring to cover 11 periods from 12:00, got %+v", lastHour)
+	}
+	if pageviews, _ := lastHour.Metrics.GetMetricValue("pageviews"); pageviews != 11 {
+		t.Errorf("Expected 11 pageviews over the last hour, got %v", pageviews)
+	}
+	if users := lastHour.Metrics.GetAllMetrics()["unique_users"]; users == nil || users.Count != 11 {
+		t.Errorf("Expected 11 unique users over the last hour, got %+v", users)
+	}
+}
+
+// BenchmarkRollingMetrics24h mesure la lecture des dernières 24 heures sur un
+// anneau plein (1440 périodes d'une minute) pendant que des événements de la
+// minute en cours continuent d'arriver
+func BenchmarkRollingMetrics24h(b *testing.B) {
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	agg := NewAggregatorWithOptions(Options{
+		Windows:       []WindowSpec{{Kind: WindowKindTumbling, Size: time.Minute}},
+		FlushInterval: 10 * time.Second,
+		Clock:         clock,
+		Rolling:       &RollingOptions{Ranges: []time.Duration{24 * time.Hour}},
+	}, zap.NewNop())
+	for i := 0; i < 24*60; i++ {
+		at := start.Add(time.Duration(i) * time.Minute)
+		for j := 0; j < 5; j++ {
+			agg.ProcessEvent(Event{
+				Type:       "purchase",
+				UserID:     fmt.Sprintf("u%d", (i*5+j)%2000),
+				Timestamp:  at,
+				Properties: map[string]interface{}{"amount": float64(j * 10)},
+			})
+		}
+	}
+	now := start.Add(24*time.Hour - 30*time.Second)
+	clock.Set(now)
+	if _, ok := agg.RollingMetrics(24 * time.Hour); !ok {
+		b.Fatal("Expected the 24h range to be served")
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		agg.ProcessEvent(Event{Type: "pageview", UserID: "u1", Timestamp: now})
+		agg.RollingMetrics(24 * time.Hour)
+	}
+}
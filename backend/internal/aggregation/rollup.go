@@ -0,0 +1,186 @@
+package aggregation
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// RollupLevel est un niveau d'agrégation des fenêtres fermées (1h, 1d...),
+// aligné sur des multiples de Size depuis l'époque (minuit UTC pour 1d)
+type RollupLevel struct {
+	Name string
+	Size time.Duration
+}
+
+// DefaultRollupLevels retourne les niveaux des tables metrics_1h et metrics_1d
+func DefaultRollupLevels() []RollupLevel {
+	return []RollupLevel{
+		{Name: "1h", Size: time.Hour},
+		{Name: "1d", Size: 24 * time.Hour},
+	}
+}
+
+// RollupResult est l'agrégat complet d'une période d'un niveau
+type RollupResult struct {
+	Level    string
+	Start    time.Time
+	Duration time.Duration
+	Metrics  *MetricsSnapshot
+	Children int // nombre de fenêtres (ou périodes du niveau inférieur) fusionnées
+}
+
+// rollupLevel suit les périodes ouvertes d'un niveau
+type rollupLevel struct {
+	RollupLevel
+	childSize time.Duration
+	// périodes ouvertes par début, avec l'agrégat de chaque enfant par début
+	buckets map[time.Time]map[time.Time]*MetricsSnapshot
+	// fin de la dernière période émise: ses enfants tardifs sont refusés
+	emitted time.Time
+}
+
+// Rollup agrège les fenêtres fermées d'une durée fixe (1 minute) en périodes
+// plus longues, chaque niveau alimentant le suivant (minutes -> heures ->
+// jours). Les compteurs s'additionnent, les min/max, sets et sketches
+// fusionnent (voir MetricsSnapshot.Merge).
+//
+// Une période est calculée à partir de ses enfants indexés par leur début:
+// une fenêtre amendée ou rejouée remplace son agrégat précédent au lieu de
+// s'y ajouter. Recalculer une période à partir des mêmes enfants (après un
+// redémarrage par exemple) donne donc le même résultat, sans double comptage.
+type Rollup struct {
+	levels []*rollupLevel
+	grace  time.Duration
+	opts   SnapshotOptions
+	mu     sync.Mutex
+}
+
+// NewRollup crée un moteur de rollup pour des fenêtres de durée input. Une
+// période est émise par Flush une fois sa fin dépassée de grace, le temps que
+// ses dernières fenêtres (et leurs amendements tardifs) arrivent.
+func NewRollup(input time.Duration, levels []RollupLevel, grace time.Duration, opts SnapshotOptions) (*Rollup, error) {
+	if input <= 0 {
+		return nil, fmt.Errorf("rollup input duration must be positive")
+	}
+	if grace < 0 {
+		return nil, fmt.Errorf("rollup grace must not be negative")
+	}
+
+	// Les agrégats sont des vues: les débordements ne sont pas recomptés
+	opts.OnOverflow = nil
+	r := &Rollup{grace: grace, opts: opts}
+	childSize := input
+	for _, level := range levels {
+		if level.Size <= childSize || level.Size%childSize != 0 {
+			return nil, fmt.Errorf("rollup level %s: size %s must be a multiple of %s", level.Name, level.Size, childSize)
+		}
+		r.levels = append(r.levels, &rollupLevel{
+			RollupLevel: level,
+			childSize:   childSize,
+			buckets:     make(map[time.Time]map[time.Time]*MetricsSnapshot),
+		})
+		childSize = level.Size
+	}
+	return r, nil
+}
+
+// AddWindow enregistre une fenêtre fermée (voir Add)
+func (r *Rollup) AddWindow(window *TimeWindow) bool {
+	return r.Add(window.StartTime, window.Duration, window.Metrics)
+}
+
+// Add enregistre l'agrégat de la période [start, start+size): une fenêtre
+// fermée, ou une période d'un niveau restaurée depuis le stockage. Les
+// métriques sont copiées. Retourne false si aucun niveau n'agrège des
+// périodes de cette durée ou si la période englobante a déjà été émise.
+func (r *Rollup) Add(start time.Time, size time.Duration, metrics *MetricsSnapshot) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, level := range r.levels {
+		if level.childSize != size {
+			continue
+		}
+		copied := NewMetricsSnapshotWithOptions(r.opts)
+		copied.Merge(metrics)
+		return r.addLocked(i, start, copied)
+	}
+	return false
+}
+
+// addLocked range un enfant dans la période du niveau i (r.mu doit être tenu)
+func (r *Rollup) addLocked(i int, start time.Time, metrics *MetricsSnapshot) bool {
+	level := r.levels[i]
+	start = start.UTC() // même clé quel que soit le fuseau de la fenêtre ou du stockage
+	bucket := start.Truncate(level.Size)
+	if bucket.Before(level.emitted) {
+		return false
+	}
+
+	children, ok := level.buckets[bucket]
+	if !ok {
+		children = make(map[time.Time]*MetricsSnapshot)
+		level.buckets[bucket] = children
+	}
+	children[start] = metrics
+	return true
+}
+
+// Flush émet, du niveau le plus fin au plus large, les périodes dont la fin
+// plus grace est atteinte à now. Chaque période émise alimente le niveau
+// suivant; les résultats sont triés par niveau puis par début.
+func (r *Rollup) Flush(now time.Time) []RollupResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	results := make([]RollupResult, 0)
+	for i, level := range r.levels {
+		starts := make([]time.Time, 0)
+		for start := range level.buckets {
+			if !now.Before(start.Add(level.Size).Add(r.grace)) {
+				starts = append(starts, start)
+			}
+		}
+		sort.Slice(starts, func(a, b int) bool { return starts[a].Before(starts[b]) })
+
+		for _, start := range starts {
+			children := level.buckets[start]
+			delete(level.buckets, start)
+
+			merged := NewMetricsSnapshotWithOptions(r.opts)
+			merged.Timestamp = start
+			for _, child := range children {
+				merged.Merge(child)
+			}
+			if end := start.Add(level.Size); end.After(level.emitted) {
+				level.emitted = end
+			}
+
+			results = append(results, RollupResult{
+				Level:    level.Name,
+				Start:    start,
+				Duration: level.Size,
+				Metrics:  merged,
+				Children: len(children),
+			})
+			if i+1 < len(r.levels) {
+				r.addLocked(i+1, start, merged)
+			}
+		}
+	}
+	return results
+}
+
+// Pending retourne le nombre de périodes ouvertes par niveau
+func (r *Rollup) Pending() map[string]int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	pending := make(map[string]int, len(r.levels))
+	for _, level := range r.levels {
+		pending[level.Name] = len(level.buckets)
+	}
+	return pending
+}
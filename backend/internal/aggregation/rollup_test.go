@@ -0,0 +1,109 @@
+package aggregation
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// rollupMinute crée l'agrégat d'une minute: un événement, un utilisateur et un montant
+func rollupMinute(start time.Time, minute int) *TimeWindow {
+	window := newTimeWindow(start.Add(time.Duration(minute)*time.Minute), time.Minute, SnapshotOptions{})
+	window.Metrics.GetMetric("events", MetricTypeCounter).Increment()
+	window.Metrics.GetMetric("active_users", MetricTypeSet).AddUnique(fmt.Sprintf("user_%d", minute%90))
+	window.Metrics.GetMetric("amount_histogram", MetricTypeHistogram).Observe(float64(minute))
+	window.Close()
+	return window
+}
+
+// TestRollupHoursAndDays teste l'agrégation des minutes en heures puis en jour
+func TestRollupHoursAndDays(t *testing.T) {
+	day := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	rollup, err := NewRollup(time.Minute, DefaultRollupLevels(), 2*time.Minute, SnapshotOptions{})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for minute := 0; minute < 24*60; minute++ {
+		rollup.AddWindow(rollupMinute(day, minute))
+	}
+
+	// La première heure attend la période de grâce
+	if results := rollup.Flush(day.Add(time.Hour + time.Minute)); len(results) != 0 {
+		t.Fatalf("Expected no rollup before the grace period, got %d", len(results))
+	}
+	results := rollup.Flush(day.Add(2*time.Hour + 2*time.Minute))
+	if len(results) != 2 || results[0].Level != "1h" || !results[0].Start.Equal(day) || !results[1].Start.Equal(day.Add(time.Hour)) {
+		t.Fatalf("Expected the first two hours, got %+v", results)
+	}
+	first := results[0].Metrics.GetAllMetrics()
+	if first["events"].Value != 60 || results[0].Children != 60 {
+		t.Errorf("Expected 60 events from 60 minutes, got %.0f from %d", first["events"].Value, results[0].Children)
+	}
+	if first["amount_histogram"].Min() != 0 || first["amount_histogram"].Max() != 59 {
+		t.Errorf("Expected amounts in [0, 59], got [%.0f, %.0f]", first["amount_histogram"].Min(), first["amount_histogram"].Max())
+	}
+
+	// Fin de journée: les 22 heures restantes puis le jour
+	results = rollup.Flush(day.Add(24*time.Hour + 2*time.Minute))
+	if len(results) != 23 {
+		t.Fatalf("Expected 22 hours and 1 day, got %d results", len(results))
+	}
+	daily := results[22]
+	if daily.Level != "1d" || !daily.Start.Equal(day) || daily.Children != 24 {
+		t.Fatalf("Expected the daily rollup of 24 hours, got %s %s (%d)", daily.Level, daily.Start, daily.Children)
+	}
+	metrics := daily.Metrics.GetAllMetrics()
+	if metrics["events"].Value != 1440 || metrics["active_users"].Count != 90 {
+		t.Errorf("Expected 1440 events and 90 users, got %.0f and %d", metrics["events"].Value, metrics["active_users"].Count)
+	}
+	if p50 := metrics["amount_histogram"].Quantile(0.5); p50 < 700 || p50 > 740 {
+		t.Errorf("Expected daily median near 720, got %.1f", p50)
+	}
+	if pending := rollup.Pending(); pending["1h"] != 0 || pending["1d"] != 0 {
+		t.Errorf("Expected nothing pending, got %v", pending)
+	}
+}
+
+// TestRollupIdempotent teste qu'une fenêtre amendée ou rejouée remplace son agrégat
+func TestRollupIdempotent(t *testing.T) {
+	hour := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	levels := []RollupLevel{{Name: "1h", Size: time.Hour}}
+
+	run := func(replays int) float64 {
+		rollup, err := NewRollup(time.Minute, levels, 0, SnapshotOptions{})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		for r := 0; r < replays; r++ {
+			for minute := 0; minute < 60; minute++ {
+				rollup.AddWindow(rollupMinute(hour, minute))
+			}
+		}
+		// Révision amendée de la minute 5: deux événements au lieu d'un
+		amended := rollupMinute(hour, 5)
+		amended.Metrics.GetMetric("events", MetricTypeCounter).Increment()
+		amended.Revision = 1
+		rollup.AddWindow(amended)
+
+		results := rollup.Flush(hour.Add(time.Hour))
+		if len(results) != 1 {
+			t.Fatalf("Expected 1 hourly rollup, got %d", len(results))
+		}
+
+		// Une fenêtre de l'heure déjà émise est refusée
+		if rollup.AddWindow(rollupMinute(hour, 30)) {
+			t.Error("Expected a window of an emitted hour to be rejected")
+		}
+		events, _ := results[0].Metrics.GetMetricValue("events")
+		return events
+	}
+
+	if once, replayed := run(1), run(3); once != 61 || replayed != 61 {
+		t.Errorf("Expected 61 events whatever the replays, got %.0f and %.0f", once, replayed)
+	}
+
+	if _, err := NewRollup(time.Minute, []RollupLevel{{Name: "7m", Size: 90 * time.Second}}, 0, SnapshotOptions{}); err == nil {
+		t.Error("Expected an error for a level that is not a multiple of its input")
+	}
+}
@@ -0,0 +1,213 @@
+package aggregation
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// RuleType est l'opération qu'une règle applique à sa métrique
+type RuleType string
+
+const (
+	RuleTypeCounter   RuleType = "counter"   // +1 par événement
+	RuleTypeSum       RuleType = "sum"       // + valeur de Property
+	RuleTypeHistogram RuleType = "histogram" // observe la valeur de Property
+	RuleTypeSet       RuleType = "set"       // ajoute Property aux valeurs uniques
+	RuleTypeGauge     RuleType = "gauge"     // dernière valeur de Property
+	RuleTypeTopK      RuleType = "topk"      // valeurs de Property les plus fréquentes
+)
+
+// RuleScope indique où une règle est évaluée
+type RuleScope string
+
+const (
+	RuleScopeAll    RuleScope = "all"    // métriques globales et de fenêtre
+	RuleScopeGlobal RuleScope = "global" // métriques globales seulement
+	RuleScopeWindow RuleScope = "window" // métriques de fenêtre seulement
+)
+
+// RuleCondition filtre les événements sur un champ: égalité si Equals est
+// renseigné, présence du champ sinon
+type RuleCondition struct {
+	Property string `json:"property"`
+	Equals   string `json:"equals,omitempty"`
+}
+
+// MetricRule décrit déclarativement une métrique dérivée des événements.
+// Property et les dimensions désignent une propriété de l'événement ou l'un
+// des champs type, user_id et session_id. Chaque dimension devient un label
+// de la série: page_views + [page] donne page_views{page="/home"}.
+type MetricRule struct {
+	Name       string          `json:"name"`
+	EventType  string          `json:"event_type,omitempty"` // vide ou "*": tous les types
+	Where      []RuleCondition `json:"where,omitempty"`
+	Property   string          `json:"property,omitempty"`
+	Type       RuleType        `json:"type"`
+	Dimensions []string        `json:"dimensions,omitempty"`
+	Scope      RuleScope       `json:"scope,omitempty"` // défaut: all
+}
+
+// DefaultMetricRules reproduit les métriques globales historiques
+// (pageviews, clicks, purchases) et suit les pages et éléments les plus
+// fréquents, globalement et par fenêtre
+func DefaultMetricRules() []MetricRule {
+	return []MetricRule{
+		{Name: "pageviews", EventType: "pageview", Type: RuleTypeCounter, Scope: RuleScopeGlobal},
+		{Name: "unique_pages", EventType: "pageview", Property: "page", Type: RuleTypeSet, Scope: RuleScopeGlobal},
+		{Name: "page_views", EventType: "pageview", Type: RuleTypeCounter, Dimensions: []string{"page"}, Scope: RuleScopeGlobal},
+		{Name: "clicks", EventType: "click", Type: RuleTypeCounter, Scope: RuleScopeGlobal},
+		{Name: "clicks", EventType: "click", Type: RuleTypeCounter, Dimensions: []string{"element"}, Scope: RuleScopeGlobal},
+		{Name: "purchases", EventType: "purchase", Type: RuleTypeCounter, Scope: RuleScopeGlobal},
+		{Name: "revenue", EventType: "purchase", Property: "amount", Type: RuleTypeSum, Scope: RuleScopeGlobal},
+		{Name: "revenue_histogram", EventType: "purchase", Property: "amount", Type: RuleTypeHistogram, Scope: RuleScopeGlobal},
+		{Name: "top_pages", EventType: "pageview", Property: "page", Type: RuleTypeTopK},
+		{Name: "top_elements", EventType: "click", Property: "element", Type: RuleTypeTopK},
+	}
+}
+
+// Validate vérifie qu'une règle est applicable
+func (r MetricRule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("metric rule: name is required")
+	}
+	switch r.Type {
+	case RuleTypeCounter:
+	case RuleTypeSum, RuleTypeHistogram, RuleTypeSet, RuleTypeGauge, RuleTypeTopK:
+		if r.Property == "" {
+			return fmt.Errorf("metric rule %s: property is required for type %s", r.Name, r.Type)
+		}
+	default:
+		return fmt.Errorf("metric rule %s: unknown type %q", r.Name, r.Type)
+	}
+	switch r.Scope {
+	case "", RuleScopeAll, RuleScopeGlobal, RuleScopeWindow:
+	default:
+		return fmt.Errorf("metric rule %s: unknown scope %q", r.Name, r.Scope)
+	}
+	for _, cond := range r.Where {
+		if cond.Property == "" {
+			return fmt.Errorf("metric rule %s: condition without property", r.Name)
+		}
+	}
+	return nil
+}
+
+// appliesTo indique si la règle est évaluée dans le scope donné
+func (r MetricRule) appliesTo(scope RuleScope) bool {
+	return r.Scope == "" || r.Scope == RuleScopeAll || r.Scope == scope
+}
+
+// matches indique si l'événement satisfait le type et les conditions de la règle
+func (r MetricRule) matches(event Event) bool {
+	if r.EventType != "" && r.EventType != "*" && r.EventType != event.Type {
+		return false
+	}
+	for _, cond := range r.Where {
+		value, ok := eventField(event, cond.Property)
+		if !ok {
+			return false
+		}
+		if cond.Equals != "" && fieldString(value) != cond.Equals {
+			return false
+		}
+	}
+	return true
+}
+
+// Apply met à jour la métrique de la règle dans snapshot si l'événement
+// correspond. Les événements sans la propriété ou une dimension sont ignorés.
+func (r MetricRule) Apply(snapshot *MetricsSnapshot, event Event) {
+	if !r.matches(event) {
+		return
+	}
+
+	var labels Labels
+	if len(r.Dimensions) > 0 {
+		labels = make(Labels, len(r.Dimensions))
+	}
+	for _, dim := range r.Dimensions {
+		value, ok := eventField(event, dim)
+		if !ok {
+			return
+		}
+		labels[dim] = fieldString(value)
+	}
+
+	switch r.Type {
+	case RuleTypeCounter:
+		snapshot.GetMetricWithLabels(r.Name, labels, MetricTypeCounter).Increment()
+	case RuleTypeSet:
+		if value, ok := eventField(event, r.Property); ok {
+			snapshot.GetMetricWithLabels(r.Name, labels, MetricTypeSet).AddUnique(fieldString(value))
+		}
+	case RuleTypeTopK:
+		if value, ok := eventField(event, r.Property); ok {
+			snapshot.GetMetricWithLabels(r.Name, labels, MetricTypeTopK).AddTopK(fieldString(value))
+		}
+	default:
+		value, ok := eventNumber(event, r.Property)
+		if !ok {
+			return
+		}
+		switch r.Type {
+		case RuleTypeSum:
+			snapshot.GetMetricWithLabels(r.Name, labels, MetricTypeCounter).IncrementBy(value)
+		case RuleTypeHistogram:
+			snapshot.GetMetricWithLabels(r.Name, labels, MetricTypeHistogram).Observe(value)
+		case RuleTypeGauge:
+			snapshot.GetMetricWithLabels(r.Name, labels, MetricTypeGauge).Set(value)
+		}
+	}
+}
+
+// eventField retourne un champ de l'événement ou une de ses propriétés
+func eventField(event Event, name string) (interface{}, bool) {
+	switch name {
+	case "type":
+		return event.Type, event.Type != ""
+	case "user_id":
+		return event.UserID, event.UserID != ""
+	case "session_id":
+		return event.SessionID, event.SessionID != ""
+	}
+	value, ok := event.Properties[name]
+	if !ok || value == nil {
+		return nil, false
+	}
+	return value, true
+}
+
+// eventNumber retourne une propriété numérique (nombre JSON ou chaîne numérique)
+func eventNumber(event Event, name string) (float64, bool) {
+	value, ok := eventField(event, name)
+	if !ok {
+		return 0, false
+	}
+	switch v := value.(type) {
+	case float64:
+		return v, true
+	case float32:
+		return float64(v), true
+	case int:
+		return float64(v), true
+	case int64:
+		return float64(v), true
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// fieldString formate une valeur de propriété pour un nom de métrique ou un set
+func fieldString(value interface{}) string {
+	switch v := value.(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,116 @@
+package aggregation
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TestMetricRuleApply teste l'évaluation d'une règle: type, conditions et dimensions
+func TestMetricRuleApply(t *testing.T) {
+	snapshot := NewMetricsSnapshot()
+	rule := MetricRule{
+		Name:       "signups",
+		EventType:  "signup",
+		Where:      []RuleCondition{{Property: "plan", Equals: "pro"}},
+		Type:       RuleTypeCounter,
+		Dimensions: []string{"country"},
+	}
+
+	events := []Event{
+		{Type: "signup", Properties: map[string]interface{}{"plan": "pro", "country": "FR"}},
+		{Type: "signup", Properties: map[string]interface{}{"plan": "pro", "country": "FR"}},
+		{Type: "signup", Properties: map[string]interface{}{"plan": "free", "country": "FR"}},
+		{Type: "signup", Properties: map[string]interface{}{"plan": "pro"}}, // sans dimension
+		{Type: "pageview", Properties: map[string]interface{}{"plan": "pro", "country": "FR"}},
+	}
+	for _, event := range events {
+		rule.Apply(snapshot, event)
+	}
+
+	if value, _ := snapshot.GetMetricValue(SeriesKey("signups", Labels{"country": "FR"})); value != 2 {
+		t.Errorf("Expected 2 pro signups from FR, got %.0f", value)
+	}
+	if len(snapshot.GetAllMetrics()) != 1 {
+		t.Errorf("Expected a single metric, got %d", len(snapshot.GetAllMetrics()))
+	}
+}
+
+// TestMetricRuleValidate teste le refus des règles incomplètes
+func TestMetricRuleValidate(t *testing.T) {
+	invalid := []MetricRule{
+		{Type: RuleTypeCounter},
+		{Name: "revenue", Type: RuleTypeSum},
+		{Name: "revenue", Type: "average", Property: "amount"},
+		{Name: "revenue", Type: RuleTypeSum, Property: "amount", Scope: "everywhere"},
+	}
+	for _, rule := range invalid {
+		if err := rule.Validate(); err == nil {
+			t.Errorf("Expected rule %+v to be invalid", rule)
+		}
+	}
+	for _, rule := range DefaultMetricRules() {
+		if err := rule.Validate(); err != nil {
+			t.Errorf("Unexpected invalid default rule: %v", err)
+		}
+	}
+}
+
+// TestAggregatorRules teste des règles configurées sur les métriques globales et de fenêtre
+func TestAggregatorRules(t *testing.T) {
+	logger := zap.NewNop()
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	agg := NewAggregatorWithOptions(Options{
+		Windows:       []WindowSpec{{Kind: WindowKindTumbling, Size: 1 * time.Minute}},
+		FlushInterval: 10 * time.Second,
+		Clock:         clock,
+		Rules: []MetricRule{
+			{Name: "cart_size", EventType: "add_to_cart", Property: "cart_size", Type: RuleTypeGauge, Scope: RuleScopeGlobal},
+			{Name: "cart_value", EventType: "add_to_cart", Property: "price", Type: RuleTypeSum},
+			{Name: "buyers", EventType: "add_to_cart", Property: "user_id", Type: RuleTypeSet, Scope: RuleScopeWindow},
+			{Name: "broken", Type: RuleTypeSum}, // ignorée: pas de propriété
+		},
+	}, logger)
+
+	var closed *TimeWindow
+	agg.SetWindowClosedCallback(func(window *TimeWindow) {
+		closed = window
+	})
+
+	agg.ProcessEvent(Event{Type: "add_to_cart", Timestamp: start, UserID: "user_1",
+		Properties: map[string]interface{}{"cart_size": 1.0, "price": 20.0}})
+	agg.ProcessEvent(Event{Type: "add_to_cart", Timestamp: start.Add(time.Second), UserID: "user_1",
+		Properties: map[string]interface{}{"cart_size": 2.0, "price": 15.5}})
+	agg.ProcessEvent(Event{Type: "pageview", Timestamp: start.Add(2 * time.Second), UserID: "user_2",
+		Properties: map[string]interface{}{"page": "/home"}})
+
+	if value, _ := agg.GetGlobalMetricValue("cart_size"); value != 2 {
+		t.Errorf("Expected last cart_size 2, got %.0f", value)
+	}
+	if value, _ := agg.GetGlobalMetricValue("cart_value"); value != 35.5 {
+		t.Errorf("Expected global cart_value 35.5, got %.1f", value)
+	}
+	if _, ok := agg.GetGlobalMetricValue("buyers"); ok {
+		t.Error("Expected window-scoped rule not to produce a global metric")
+	}
+	if _, ok := agg.GetGlobalMetricValue("pageviews"); ok {
+		t.Error("Expected configured rules to replace the default ones")
+	}
+
+	clock.Advance(2 * time.Minute)
+	agg.Flush()
+	if closed == nil {
+		t.Fatal("Expected window to be closed")
+	}
+	if value, _ := closed.Metrics.GetMetricValue("cart_value"); value != 35.5 {
+		t.Errorf("Expected window cart_value 35.5, got %.1f", value)
+	}
+	if buyers, ok := closed.Metrics.GetAllMetrics()["buyers"]; !ok || buyers.Count != 1 {
+		t.Error("Expected 1 buyer in window")
+	}
+	if _, ok := closed.Metrics.GetMetricValue("cart_size"); ok {
+		t.Error("Expected global-scoped rule not to produce a window metric")
+	}
+}
@@ -0,0 +1,155 @@
+package aggregation
+
+import (
+	"sync"
+	"time"
+)
+
+// Métriques émises pour chaque session fermée
+const (
+	SessionMetricDuration        = "session_duration_seconds"
+	SessionMetricEvents          = "events"
+	SessionMetricPagesViewed     = "pages_viewed"
+	SessionMetricPurchases       = "purchases"
+	SessionMetricEndedInPurchase = "ended_in_purchase" // 1 si le dernier événement est un achat
+)
+
+// sessionState suit une session ouverte
+type sessionState struct {
+	window        *TimeWindow
+	lastSeen      time.Time
+	lastEventType string
+	summary       sessionSummary // métriques d'engagement des fenêtres temporelles
+}
+
+// SessionManager gere les fenêtres de session (fermées après une période d'inactivité)
+type SessionManager struct {
+	sessions map[string]*sessionState
+	spec     WindowSpec
+	gap      time.Duration
+	metrics  SnapshotOptions // options des métriques des sessions créées
+	mu       sync.Mutex
+}
+
+// NewSessionManager crée un gestionnaire de sessions pour une spec de type session
+// (clock nil: horloge murale)
+func NewSessionManager(spec WindowSpec, clock Clock) *SessionManager {
+	return newSessionManager(spec, SnapshotOptions{Clock: clock})
+}
+
+// newSessionManager crée un gestionnaire dont les sessions suivent opts
+func newSessionManager(spec WindowSpec, opts SnapshotOptions) *SessionManager {
+	spec = spec.normalize()
+	opts.Clock = orSystemClock(opts.Clock)
+	return &SessionManager{
+		sessions: make(map[string]*sessionState),
+		spec:     spec,
+		gap:      spec.Gap,
+		metrics:  opts,
+	}
+}
+
+// Spec retourne la spec gérée par ce gestionnaire
+func (sm *SessionManager) Spec() WindowSpec {
+	return sm.spec
+}
+
+// SessionKey retourne la clé de session d'un événement (SessionID, sinon UserID)
+func SessionKey(event Event) string {
+	if event.SessionID != "" {
+		return event.SessionID
+	}
+	return event.UserID
+}
+
+// Track rattache un événement à sa session, met à jour ses métriques et
+// retourne la fenêtre de session.
+// Si l'événement arrive après la période d'inactivité, l'ancienne session est
+// fermée et retournée dans expired pour être émise.
+func (sm *SessionManager) Track(key string, event Event) (window *TimeWindow, expired *TimeWindow) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	t := event.Timestamp
+	state, exists := sm.sessions[key]
+	if exists && t.Sub(state.lastSeen) > sm.gap {
+		expired = sm.closeLocked(key, state)
+		exists = false
+	}
+
+	if !exists {
+		window := newTimeWindow(t, 0, sm.metrics)
+		window.Spec = sm.spec.Name
+		window.Key = key
+		state = &sessionState{window: window, lastSeen: t}
+		sm.sessions[key] = state
+	}
+
+	// Les événements en retard peuvent étendre la session vers le passé
+	if t.Before(state.window.StartTime) {
+		state.window.StartTime = t
+	}
+	if !t.Before(state.lastSeen) {
+		state.lastSeen = t
+		state.lastEventType = event.Type
+	}
+	state.window.EndTime = state.lastSeen.Add(sm.gap)
+	updateSessionMetrics(state.window, event)
+	state.summary.add(event)
+
+	return state.window, expired
+}
+
+// CloseExpiredSessions ferme les sessions inactives depuis plus que la période configurée
+func (sm *SessionManager) CloseExpiredSessions(t time.Time) []*TimeWindow {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	closed := make([]*TimeWindow, 0)
+	for key, state := range sm.sessions {
+		if t.Sub(state.lastSeen) > sm.gap {
+			closed = append(closed, sm.closeLocked(key, state))
+		}
+	}
+	return closed
+}
+
+// closeLocked finalise une session et la retire des sessions ouvertes (sm.mu doit être tenu)
+func (sm *SessionManager) closeLocked(key string, state *sessionState) *TimeWindow {
+	delete(sm.sessions, key)
+
+	window := state.window
+	window.EndTime = state.lastSeen
+	window.Duration = state.lastSeen.Sub(window.StartTime)
+
+	window.Metrics.GetMetric(SessionMetricDuration, MetricTypeGauge).Set(window.Duration.Seconds())
+	endedInPurchase := 0.0
+	if state.lastEventType == "purchase" {
+		endedInPurchase = 1
+	}
+	window.Metrics.GetMetric(SessionMetricEndedInPurchase, MetricTypeGauge).Set(endedInPurchase)
+
+	summary := state.summary
+	window.session = &summary
+	window.Close()
+	return window
+}
+
+// ActiveSessions retourne le nombre de sessions ouvertes
+func (sm *SessionManager) ActiveSessions() int {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return len(sm.sessions)
+}
+
+// updateSessionMetrics met à jour les métriques d'une session
+func updateSessionMetrics(window *TimeWindow, event Event) {
+	window.Metrics.GetMetric(SessionMetricEvents, MetricTypeCounter).Increment()
+
+	switch event.Type {
+	case "pageview":
+		window.Metrics.GetMetric(SessionMetricPagesViewed, MetricTypeCounter).Increment()
+	case "purchase":
+		window.Metrics.GetMetric(SessionMetricPurchases, MetricTypeCounter).Increment()
+	}
+}
@@ -0,0 +1,106 @@
+package aggregation
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TestSessionManagerGap teste la fermeture d'une session après inactivité
+func TestSessionManagerGap(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	sm := NewSessionManager(WindowSpec{Kind: WindowKindSession, Gap: 30 * time.Minute}, NewManualClock(start))
+	if sm.Spec().Name != "session_30m" {
+		t.Errorf("Expected spec name session_30m, got %s", sm.Spec().Name)
+	}
+
+	events := []Event{
+		{Type: "pageview", Timestamp: start, SessionID: "s1"},
+		{Type: "pageview", Timestamp: start.Add(5 * time.Minute), SessionID: "s1"},
+		{Type: "click", Timestamp: start.Add(10 * time.Minute), SessionID: "s1"},
+		{Type: "purchase", Timestamp: start.Add(12 * time.Minute), SessionID: "s1"},
+	}
+	for _, event := range events {
+		if _, expired := sm.Track(SessionKey(event), event); expired != nil {
+			t.Fatal("Expected no expired session")
+		}
+	}
+
+	if closed := sm.CloseExpiredSessions(start.Add(40 * time.Minute)); len(closed) != 0 {
+		t.Fatalf("Expected session still open, got %d closed", len(closed))
+	}
+
+	closed := sm.CloseExpiredSessions(start.Add(43 * time.Minute))
+	if len(closed) != 1 {
+		t.Fatalf("Expected 1 closed session, got %d", len(closed))
+	}
+
+	session := closed[0]
+	if session.Key != "s1" || session.Spec != "session_30m" {
+		t.Errorf("Unexpected session identity %s/%s", session.Spec, session.Key)
+	}
+
+	checks := map[string]float64{
+		SessionMetricDuration:        (12 * time.Minute).Seconds(),
+		SessionMetricEvents:          4,
+		SessionMetricPagesViewed:     2,
+		SessionMetricEndedInPurchase: 1,
+	}
+	for name, expected := range checks {
+		if value, _ := session.Metrics.GetMetricValue(name); value != expected {
+			t.Errorf("%s: expected %.0f, got %.0f", name, expected, value)
+		}
+	}
+
+	if sm.ActiveSessions() != 0 {
+		t.Errorf("Expected no open session, got %d", sm.ActiveSessions())
+	}
+}
+
+// TestAggregatorSessionCallback teste l'émission des sessions via le callback de fenêtre
+func TestAggregatorSessionCallback(t *testing.T) {
+	logger := zap.NewNop()
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	agg := NewAggregatorWithOptions(Options{
+		Windows: []WindowSpec{
+			{Kind: WindowKindTumbling, Size: 1 * time.Minute},
+			{Kind: WindowKindSession, Gap: 10 * time.Minute},
+		},
+		FlushInterval: 10 * time.Second,
+		Clock:         clock,
+	}, logger)
+
+	var sessions []*TimeWindow
+	agg.SetWindowClosedCallback(func(window *TimeWindow) {
+		if window.Key != "" {
+			sessions = append(sessions, window)
+		}
+	})
+
+	// Pas de SessionID: la session est rattachée à l'utilisateur
+	agg.ProcessEvent(Event{Type: "pageview", Timestamp: start, UserID: "user_1"})
+	agg.ProcessEvent(Event{Type: "pageview", Timestamp: start.Add(time.Minute), UserID: "user_1"})
+
+	// Un événement après la période d'inactivité ouvre une nouvelle session
+	agg.ProcessEvent(Event{Type: "pageview", Timestamp: start.Add(30 * time.Minute), UserID: "user_1"})
+	if len(sessions) != 1 {
+		t.Fatalf("Expected first session emitted on gap, got %d", len(sessions))
+	}
+	if pages, _ := sessions[0].Metrics.GetMetricValue(SessionMetricPagesViewed); pages != 2 {
+		t.Errorf("Expected 2 pages viewed, got %.0f", pages)
+	}
+	if ended, _ := sessions[0].Metrics.GetMetricValue(SessionMetricEndedInPurchase); ended != 0 {
+		t.Errorf("Expected session not ended in purchase, got %.0f", ended)
+	}
+
+	clock.Set(start.Add(41 * time.Minute))
+	agg.Flush()
+	if len(sessions) != 2 {
+		t.Fatalf("Expected second session emitted on flush, got %d", len(sessions))
+	}
+	if sessions[1].Key != "user_1" {
+		t.Errorf("Expected session key user_1, got %s", sessions[1].Key)
+	}
+}
@@ -0,0 +1,205 @@
+package aggregation
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// aggregatorShard accumule localement les métriques d'une partie des
+// événements. ProcessEvent ne verrouille que le shard de l'événement; les
+// deltas sont fusionnés dans la vue partagée de l'agrégateur au flush et
+// avant chaque lecture (voir Aggregator.drain).
+type aggregatorShard struct {
+	// Delta des métriques globales depuis le dernier drain
+	global *MetricsSnapshot
+
+	// Deltas des fenêtres temporelles touchées depuis le dernier drain
+	windows map[*TimeWindow]*windowDelta
+
+	// Deltas des métriques globales par période de l'anneau glissant
+	rolling map[time.Time]*MetricsSnapshot
+
+	// Sessions des clés rattachées à ce shard (un par spec de session)
+	sessions []*SessionManager
+
+	// Options des deltas: les débordements sont comptés par les snapshots
+	// cibles (voir newDeltaSnapshot)
+	opts SnapshotOptions
+
+	mu sync.Mutex
+}
+
+// windowDelta est le delta d'une fenêtre avec son gestionnaire
+type windowDelta struct {
+	manager *WindowManager
+	metrics *MetricsSnapshot
+}
+
+// newAggregatorShards crée count shards (0: runtime.GOMAXPROCS) dont les
+// deltas globaux sont fusionnés dans global
+func newAggregatorShards(count int, specs []WindowSpec, opts SnapshotOptions, global *MetricsSnapshot) []*aggregatorShard {
+	if count <= 0 {
+		count = runtime.GOMAXPROCS(0)
+	}
+	deltaOpts := opts
+	deltaOpts.OnOverflow = nil
+
+	shards := make([]*aggregatorShard, count)
+	for i := range shards {
+		shards[i] = &aggregatorShard{
+			global:   newDeltaSnapshot(deltaOpts, global),
+			windows:  make(map[*TimeWindow]*windowDelta),
+			rolling:  make(map[time.Time]*MetricsSnapshot),
+			sessions: newSessionManagers(specs, opts),
+			opts:     deltaOpts,
+		}
+	}
+	return shards
+}
+
+// newDeltaSnapshot crée un delta fusionné plus tard dans target. Ses nouvelles
+// séries labellisées sont admises par target, qui applique sa limite de
+// cardinalité et compte les débordements à l'enregistrement; sans target
+// (périodes de l'anneau glissant), le delta applique seul la limite de opts.
+func newDeltaSnapshot(opts SnapshotOptions, target *MetricsSnapshot) *MetricsSnapshot {
+	delta := NewMetricsSnapshotWithOptions(opts)
+	delta.target = target
+	return delta
+}
+
+// windowDelta retourne le delta d'une fenêtre, créé au premier événement (shard.mu doit être tenu)
+func (s *aggregatorShard) windowDelta(wm *WindowManager, window *TimeWindow) *windowDelta {
+	delta, ok := s.windows[window]
+	if !ok {
+		delta = &windowDelta{
+			manager: wm,
+			metrics: newDeltaSnapshot(s.opts, window.Metrics),
+		}
+		s.windows[window] = delta
+	}
+	return delta
+}
+
+// rollingDelta retourne le delta global d'une période de l'anneau glissant,
+// créé au premier événement (shard.mu doit être tenu)
+func (s *aggregatorShard) rollingDelta(start time.Time) *MetricsSnapshot {
+	delta, ok := s.rolling[start]
+	if !ok {
+		delta = newDeltaSnapshot(s.opts, nil)
+		s.rolling[start] = delta
+	}
+	return delta
+}
+
+// takeLocked retourne les deltas accumulés et repart de deltas vides, le
+// delta global visant target; global est nil si le shard n'a reçu aucun
+// événement (shard.mu doit être tenu)
+func (s *aggregatorShard) takeLocked(target *MetricsSnapshot) (*MetricsSnapshot, map[*TimeWindow]*windowDelta, map[time.Time]*MetricsSnapshot) {
+	if s.global.target != target {
+		s.global = newDeltaSnapshot(s.opts, target)
+	}
+	if len(s.global.Metrics) == 0 && len(s.windows) == 0 {
+		return nil, nil, nil
+	}
+	global, windows, rolling := s.global, s.windows, s.rolling
+	s.global = newDeltaSnapshot(s.opts, target)
+	s.windows = make(map[*TimeWindow]*windowDelta, len(windows))
+	s.rolling = make(map[time.Time]*MetricsSnapshot, len(rolling))
+	return global, windows, rolling
+}
+
+// shardFor choisit le shard d'un événement. Les événements d'une même session
+// vont toujours au même shard (les sessions y sont suivies); les autres sont
+// répartis par ID, ou à tour de rôle sans ID.
+func (a *Aggregator) shardFor(event Event) *aggregatorShard {
+	if len(a.shards) == 1 {
+		return a.shards[0]
+	}
+
+	key := SessionKey(event)
+	if key == "" {
+		key = event.ID
+	}
+	if key == "" {
+		next := atomic.AddUint64(&a.nextShard, 1)
+		return a.shards[next%uint64(len(a.shards))]
+	}
+	return a.shards[hashString(key)%uint64(len(a.shards))]
+}
+
+// lockShards verrouille tous les shards, toujours dans le même ordre
+func (a *Aggregator) lockShards() {
+	for _, shard := range a.shards {
+		shard.mu.Lock()
+	}
+}
+
+// unlockShards déverrouille tous les shards
+func (a *Aggregator) unlockShards() {
+	for _, shard := range a.shards {
+		shard.mu.Unlock()
+	}
+}
+
+// drain fusionne les deltas de tous les shards dans les métriques globales et
+// les fenêtres. whileLocked (optionnel) s'exécute pendant que les shards sont
+// verrouillés, après la fusion des fenêtres: le flush y ferme les fenêtres
+// expirées, pour qu'aucun événement déjà accepté ne les amende après coup.
+func (a *Aggregator) drain(whileLocked func()) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.lockShards()
+	globals := a.takeShardsLocked()
+	if whileLocked != nil {
+		whileLocked()
+	}
+	a.unlockShards()
+
+	for _, global := range globals {
+		if err := a.globalMetrics.Merge(global); err != nil {
+			a.logger.Error("failed to merge shard metrics", zap.Error(err))
+		}
+	}
+}
+
+// takeShardsLocked vide les shards: les deltas de fenêtres sont fusionnés
+// dans leurs fenêtres et ceux des périodes dans l'anneau glissant, les deltas
+// globaux sont retournés pour être fusionnés par l'appelant (a.mu et les
+// shards doivent être verrouillés)
+func (a *Aggregator) takeShardsLocked() []*MetricsSnapshot {
+	globals := make([]*MetricsSnapshot, 0, len(a.shards))
+	for _, shard := range a.shards {
+		global, windows, rolling := shard.takeLocked(a.globalMetrics)
+		if global == nil {
+			continue
+		}
+		if a.rolling != nil {
+			if err := a.rolling.merge(rolling); err != nil {
+				a.logger.Error("failed to merge shard rolling metrics", zap.Error(err))
+			}
+		}
+		globals = append(globals, global)
+		for window, delta := range windows {
+			if err := delta.manager.MergeWindow(window, delta.metrics); err != nil {
+				a.logger.Error("failed to merge shard window metrics", zap.Error(err))
+			}
+		}
+	}
+	return globals
+}
+
+// sessionManagers retourne les gestionnaires de sessions de tous les shards
+func (a *Aggregator) sessionManagers() []*SessionManager {
+	managers := make([]*SessionManager, 0, len(a.shards))
+	for _, shard := range a.shards {
+		shard.mu.Lock()
+		managers = append(managers, shard.sessions...)
+		shard.mu.Unlock()
+	}
+	return managers
+}
@@ -0,0 +1,173 @@
+package aggregation
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// shardTestEvents génère des événements répartis sur 3 minutes, 20 utilisateurs et 5 pages
+func shardTestEvents(start time.Time, n int) []Event {
+	events := make([]Event, 0, n)
+	for i := 0; i < n; i++ {
+		eventType := "pageview"
+		properties := map[string]interface{}{"page": fmt.Sprintf("/page/%d", i%5)}
+		if i%7 == 0 {
+			eventType = "purchase"
+			properties = map[string]interface{}{"amount": float64(i%50 + 1)}
+		}
+		events = append(events, Event{
+			ID:         fmt.Sprintf("evt_%d", i),
+			Type:       eventType,
+			Timestamp:  start.Add(time.Duration(i%180) * time.Second),
+			UserID:     fmt.Sprintf("user_%d", i%20),
+			SessionID:  fmt.Sprintf("session_%d", i%20),
+			Properties: properties,
+		})
+	}
+	return events
+}
+
+// TestAggregatorShardsMatchSingleShard teste que des workers concurrents sur
+// plusieurs shards produisent les mêmes métriques qu'un shard unique
+func TestAggregatorShardsMatchSingleShard(t *testing.T) {
+	logger := zap.NewNop()
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	events := shardTestEvents(start, 2000)
+
+	run := func(shards, workers int) (*Aggregator, map[time.Time]float64, int32) {
+		clock := NewManualClock(start.Add(3 * time.Minute))
+		agg := NewAggregatorWithOptions(Options{
+			Windows:         []WindowSpec{{Kind: WindowKindTumbling, Size: 1 * time.Minute}},
+			FlushInterval:   10 * time.Second,
+			AllowedLateness: 10 * time.Minute, // les workers livrent dans le désordre
+			Clock:           clock,
+			Shards:          shards,
+		}, logger)
+
+		windows := make(map[time.Time]float64)
+		var amended int32
+		agg.SetWindowClosedCallback(func(window *TimeWindow) {
+			if window.Revision > 0 {
+				atomic.AddInt32(&amended, 1)
+			}
+			events, _ := window.Metrics.GetMetricValue("events")
+			windows[window.StartTime] = events
+		})
+
+		var wg sync.WaitGroup
+		for w := 0; w < workers; w++ {
+			wg.Add(1)
+			go func(w int) {
+				defer wg.Done()
+				for i := w; i < len(events); i += workers {
+					agg.ProcessEvent(events[i])
+				}
+			}(w)
+		}
+		wg.Wait()
+
+		clock.Advance(2 * time.Minute)
+		agg.Flush()
+		return agg, windows, amended
+	}
+
+	single, singleWindows, _ := run(1, 1)
+	sharded, shardedWindows, amended := run(8, 8)
+
+	for _, name := range []string{"total_events", "revenue", SeriesKey("events_by_type", Labels{"type": "purchase"})} {
+		want, _ := single.GetGlobalMetricValue(name)
+		got, _ := sharded.GetGlobalMetricValue(name)
+		if got != want || want == 0 {
+			t.Errorf("%s: expected %.0f, got %.0f", name, want, got)
+		}
+	}
+	if users := sharded.GetGlobalMetrics()["unique_users"].Count; users != 20 {
+		t.Errorf("Expected 20 unique users, got %d", users)
+	}
+
+	want := single.GlobalTopK("top_pages", nil, 5)
+	got := sharded.GlobalTopK("top_pages", nil, 5)
+	if len(got) != len(want) || got[0] != want[0] {
+		t.Errorf("Expected top pages %+v, got %+v", want, got)
+	}
+
+	if len(shardedWindows) != 3 {
+		t.Fatalf("Expected 3 closed windows, got %d", len(shardedWindows))
+	}
+	for start, events := range singleWindows {
+		if shardedWindows[start] != events {
+			t.Errorf("Window %s: expected %.0f events, got %.0f", start, events, shardedWindows[start])
+		}
+	}
+	// Les événements acceptés avant le flush font partie de la première émission
+	if amended != 0 {
+		t.Errorf("Expected no amended window, got %d", amended)
+	}
+	if shards := sharded.GetStats()["shards"]; shards != 8 {
+		t.Errorf("Expected 8 shards in stats, got %v", shards)
+	}
+}
+
+// TestAggregatorShardsReadDrains teste qu'une lecture voit les événements pas encore fusionnés
+func TestAggregatorShardsReadDrains(t *testing.T) {
+	logger := zap.NewNop()
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	agg := NewAggregatorWithOptions(Options{
+		Windows:       []WindowSpec{{Kind: WindowKindTumbling, Size: 1 * time.Minute}},
+		FlushInterval: 10 * time.Second,
+		Clock:         NewManualClock(start),
+		Shards:        4,
+	}, logger)
+
+	for i := 0; i < 10; i++ {
+		agg.ProcessEvent(Event{Type: "click", Timestamp: start, UserID: fmt.Sprintf("user_%d", i)})
+	}
+
+	if total, _ := agg.GetGlobalMetricValue("total_events"); total != 10 {
+		t.Errorf("Expected 10 events before any flush, got %.0f", total)
+	}
+	active := agg.GetActiveWindows()
+	if len(active) != 1 {
+		t.Fatalf("Expected 1 active window, got %d", len(active))
+	}
+	if users := active[0].Metrics.GetAllMetrics()["active_users"]; users == nil || users.Count != 10 {
+		t.Errorf("Expected 10 active users in the window, got %+v", users)
+	}
+}
+
+// BenchmarkAggregatorProcessEventParallel mesure le débit de workers
+// concurrents selon le nombre de shards (shards=1 sérialise tous les
+// événements comme un verrou global). Le gain suit le nombre de cœurs:
+// go test -bench ProcessEventParallel -cpu 1,4,8
+func BenchmarkAggregatorProcessEventParallel(b *testing.B) {
+	for _, shards := range []int{1, 2, 4, 8, 16} {
+		b.Run(fmt.Sprintf("shards=%d", shards), func(b *testing.B) {
+			agg := NewAggregatorWithOptions(Options{
+				Windows:       []WindowSpec{{Kind: WindowKindTumbling, Size: 1 * time.Minute}},
+				FlushInterval: 10 * time.Second,
+				Shards:        shards,
+			}, zap.NewNop())
+
+			var workers int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				worker := atomic.AddInt64(&workers, 1)
+				event := Event{
+					Type:       "pageview",
+					Timestamp:  time.Now(),
+					UserID:     fmt.Sprintf("user_%d", worker),
+					SessionID:  fmt.Sprintf("session_%d", worker),
+					Properties: map[string]interface{}{"page": "/home"},
+				}
+				for pb.Next() {
+					agg.ProcessEvent(event)
+				}
+			})
+		})
+	}
+}
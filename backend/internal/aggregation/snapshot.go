@@ -0,0 +1,253 @@
+package aggregation
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// SnapshotStateVersion est la version courante de SnapshotState
+const SnapshotStateVersion = 1
+
+// MetricState est la forme sérialisable complète d'une métrique: contrairement
+// au JSON d'API de Metric, elle garde les structures internes (valeurs d'un
+// set exact, registres HyperLogLog, buckets DDSketch, compteurs TopK, stats
+// de gauge) pour qu'une métrique restaurée reste fusionnable et modifiable.
+type MetricState struct {
+	Name      string       `json:"name"`
+	Type      MetricType   `json:"type"`
+	Labels    Labels       `json:"labels,omitempty"`
+	Value     float64      `json:"value"`
+	Count     int64        `json:"count"`
+	Timestamp time.Time    `json:"timestamp"`
+	Set       []string     `json:"set,omitempty"` // set exact, trié
+	HLL       *HyperLogLog `json:"hll,omitempty"`
+	Sketch    *DDSketch    `json:"sketch,omitempty"`
+	TopK      *TopK        `json:"topk,omitempty"`
+	Gauge     *GaugeStats  `json:"gauge,omitempty"`
+}
+
+// SnapshotState est la forme sérialisable d'un MetricsSnapshot (JSON)
+type SnapshotState struct {
+	Version   int           `json:"version"`
+	Timestamp time.Time     `json:"timestamp"`
+	Metrics   []MetricState `json:"metrics"` // triées par clé de série
+}
+
+// State retourne une copie indépendante de l'état de la métrique
+func (m *Metric) State() MetricState {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	state := MetricState{
+		Name:      m.Name,
+		Type:      m.Type,
+		Labels:    m.Labels.clone(),
+		Value:     m.Value,
+		Count:     m.countLocked(),
+		Timestamp: m.Timestamp,
+	}
+	if m.UniqueSet != nil {
+		state.Set = make([]string, 0, len(m.UniqueSet))
+		for value := range m.UniqueSet {
+			state.Set = append(state.Set, value)
+		}
+		sort.Strings(state.Set)
+	}
+	if m.HLL != nil {
+		state.HLL = m.HLL.Clone()
+	}
+	if m.Sketch != nil {
+		state.Sketch = m.Sketch.Clone()
+	}
+	if m.TopK != nil {
+		state.TopK = m.TopK.Clone()
+	}
+	if m.Gauge != nil {
+		gauge := *m.Gauge
+		state.Gauge = &gauge
+	}
+	return state
+}
+
+// metricFromState reconstruit une métrique suivant opts à partir de son état
+func metricFromState(state MetricState, opts SnapshotOptions) (*Metric, error) {
+	switch state.Type {
+	case MetricTypeCounter, MetricTypeGauge, MetricTypeHistogram, MetricTypeSet, MetricTypeTopK:
+	default:
+		return nil, fmt.Errorf("metric %s has unknown type %q", state.Name, state.Type)
+	}
+	if hll := state.HLL; hll != nil {
+		if hll.Precision < MinHLLPrecision || hll.Precision > MaxHLLPrecision || len(hll.Registers) != 1<<hll.Precision {
+			return nil, fmt.Errorf("metric %s has %d HyperLogLog registers for precision %d",
+				state.Name, len(hll.Registers), hll.Precision)
+		}
+	}
+
+	metric := newMetric(state.Name, state.Type, opts)
+	metric.Labels = state.Labels.clone()
+	metric.Value = state.Value
+	metric.Count = state.Count
+	metric.Timestamp = state.Timestamp
+	metric.Sketch = state.Sketch
+	metric.TopK = state.TopK
+	metric.Gauge = state.Gauge
+	if state.Type == MetricTypeSet {
+		metric.HLL = state.HLL
+		metric.UniqueSet = nil
+		if metric.HLL == nil {
+			metric.UniqueSet = make(map[string]struct{}, len(state.Set))
+			for _, value := range state.Set {
+				metric.UniqueSet[value] = struct{}{}
+			}
+		}
+	}
+	return metric, nil
+}
+
+// State retourne l'état sérialisable du snapshot (voir SnapshotState)
+func (ms *MetricsSnapshot) State() SnapshotState {
+	ms.mu.RLock()
+	timestamp := ms.Timestamp
+	keys := make([]string, 0, len(ms.Metrics))
+	for key := range ms.Metrics {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	metrics := make([]*Metric, 0, len(keys))
+	for _, key := range keys {
+		metrics = append(metrics, ms.Metrics[key])
+	}
+	ms.mu.RUnlock()
+
+	state := SnapshotState{
+		Version:   SnapshotStateVersion,
+		Timestamp: timestamp,
+		Metrics:   make([]MetricState, 0, len(metrics)),
+	}
+	for _, metric := range metrics {
+		state.Metrics = append(state.Metrics, metric.State())
+	}
+	return state
+}
+
+// NewMetricsSnapshotFromState restaure un snapshot dont les métriques suivent
+// opts. Les séries sont fusionnées dans un snapshot vide: les limites de
+// cardinalité de opts s'appliquent comme pour des mises à jour.
+func NewMetricsSnapshotFromState(state SnapshotState, opts SnapshotOptions) (*MetricsSnapshot, error) {
+	if state.Version != SnapshotStateVersion {
+		return nil, fmt.Errorf("unsupported snapshot state version %d (expected %d)", state.Version, SnapshotStateVersion)
+	}
+
+	ms := NewMetricsSnapshotWithOptions(opts)
+	if !state.Timestamp.IsZero() {
+		ms.Timestamp = state.Timestamp
+	}
+	for _, metricState := range state.Metrics {
+		metric, err := metricFromState(metricState, ms.opts)
+		if err != nil {
+			return nil, err
+		}
+		target := ms.GetMetricWithLabels(metric.Name, metric.Labels, metric.Type)
+		if err := target.Merge(metric); err != nil {
+			return nil, err
+		}
+	}
+	return ms, nil
+}
+
+// MergeWindows construit une fenêtre couvrant toutes les fenêtres données
+// (par ex. une vue 5 minutes à partir de cinq fenêtres d'une minute) en
+// fusionnant leurs métriques. Les gauges dérivées à la fermeture que portent
+// les fenêtres (amount_avg, amount_p95, events_rate, engagement, conversions
+// des funnels) sont recalculées sur la fenêtre fusionnée.
+func MergeWindows(windows ...*TimeWindow) (*TimeWindow, error) {
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("no window to merge")
+	}
+
+	start, end := windows[0].StartTime, windows[0].EndTime
+	for _, window := range windows[1:] {
+		if window.StartTime.Before(start) {
+			start = window.StartTime
+		}
+		if window.EndTime.After(end) {
+			end = window.EndTime
+		}
+	}
+
+	// Vue ad hoc: les débordements de cardinalité ne sont pas comptés dans l'agrégateur
+	opts := windows[0].Metrics.opts
+	opts.OnOverflow = nil
+	merged := newTimeWindow(start, end.Sub(start), opts)
+	merged.Spec = windows[0].Spec
+	merged.Closed = true
+	for _, window := range windows {
+		if window.Spec != merged.Spec {
+			merged.Spec = ""
+		}
+		if !window.Closed {
+			merged.Closed = false
+		}
+		if err := merged.Metrics.Merge(window.Metrics); err != nil {
+			return nil, err
+		}
+	}
+	deriveMergedWindow(merged)
+	return merged, nil
+}
+
+// mergedDerivedMetrics associe les métriques de spec à la série qui révèle
+// qu'une fenêtre les calculait
+var mergedDerivedMetrics = map[string]string{
+	WindowMetricAvg:        "amount_avg",
+	WindowMetricP95:        "amount_p95",
+	WindowMetricP99:        "amount_p99",
+	WindowMetricRate:       "events_rate",
+	WindowMetricEngagement: EngagementMetricSessions,
+}
+
+// deriveMergedWindow recalcule les gauges dérivées d'une fenêtre fusionnée.
+// Les specs et funnels des fenêtres sources ne sont pas connus: ils sont
+// déduits des séries présentes.
+func deriveMergedWindow(window *TimeWindow) {
+	metrics := window.Metrics.GetAllMetrics()
+	spec := WindowSpec{Name: window.Spec}
+	for metric, series := range mergedDerivedMetrics {
+		if _, ok := metrics[series]; ok {
+			spec.Metrics = append(spec.Metrics, metric)
+		}
+	}
+
+	deriveWindowMetrics(window.Metrics, spec, window.Duration)
+	if spec.HasMetric(WindowMetricEngagement) {
+		deriveEngagementRates(window.Metrics)
+	}
+	finalizeFunnels(window, mergedFunnels(metrics))
+}
+
+// mergedFunnels retourne les funnels dont metrics contient des étapes, avec
+// autant d'étapes (anonymes) que la plus haute étape atteinte
+func mergedFunnels(metrics map[string]*Metric) []Funnel {
+	steps := make(map[string]int)
+	for _, metric := range metrics {
+		if metric.Name != FunnelMetricStep {
+			continue
+		}
+		step, err := strconv.Atoi(metric.Labels["step"])
+		if err != nil {
+			continue
+		}
+		if name := metric.Labels["funnel"]; step > steps[name] {
+			steps[name] = step
+		}
+	}
+
+	funnels := make([]Funnel, 0, len(steps))
+	for name, count := range steps {
+		funnels = append(funnels, Funnel{Name: name, Steps: make([]FunnelStep, count)})
+	}
+	sort.Slice(funnels, func(i, j int) bool { return funnels[i].Name < funnels[j].Name })
+	return funnels
+}
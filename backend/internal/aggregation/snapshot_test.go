@@ -0,0 +1,179 @@
+package aggregation
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// fillSnapshot ajoute une métrique de chaque type à un snapshot
+func fillSnapshot(ms *MetricsSnapshot, offset int) {
+	for i := 0; i < 10; i++ {
+		ms.GetMetric("events", MetricTypeCounter).Increment()
+		ms.GetMetricWithLabels("page_views", Labels{"page": fmt.Sprintf("/page/%d", i%2)}, MetricTypeCounter).Increment()
+		ms.GetMetric("latency", MetricTypeHistogram).Observe(float64(offset + i))
+		ms.GetMetric("active_users", MetricTypeSet).AddUnique(fmt.Sprintf("user_%d", offset+i))
+		ms.GetMetric("top_pages", MetricTypeTopK).AddTopK(fmt.Sprintf("/page/%d", i%3))
+		ms.GetMetric("cart_size", MetricTypeGauge).Set(float64(offset + i))
+	}
+}
+
+// TestMergeWindowsAllTypes teste la vue 5 minutes construite à partir de cinq fenêtres d'une minute
+func TestMergeWindowsAllTypes(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+
+	windows := make([]*TimeWindow, 0, 5)
+	for i := 0; i < 5; i++ {
+		window := newTimeWindow(start.Add(time.Duration(i)*time.Minute), time.Minute, SnapshotOptions{Clock: clock})
+		window.Spec = "tumbling_1m"
+		window.Close()
+		fillSnapshot(window.Metrics, i*5) // utilisateurs user_0..user_29 avec recouvrements
+		clock.Advance(time.Minute)
+		windows = append(windows, window)
+	}
+
+	merged, err := MergeWindows(windows...)
+	if err != nil {
+		t.Fatalf("Unexpected merge error: %v", err)
+	}
+	if !merged.StartTime.Equal(start) || merged.Duration != 5*time.Minute || !merged.Closed || merged.Spec != "tumbling_1m" {
+		t.Errorf("Unexpected merged window %s +%s closed=%v spec=%q", merged.StartTime, merged.Duration, merged.Closed, merged.Spec)
+	}
+
+	metrics := merged.Metrics.GetAllMetrics()
+	if metrics["events"].Value != 50 {
+		t.Errorf("Expected counters to add up to 50, got %.0f", metrics["events"].Value)
+	}
+	if value, _ := merged.Metrics.GetMetricValue(SeriesKey("page_views", Labels{"page": "/page/0"})); value != 25 {
+		t.Errorf("Expected labeled counter 25, got %.0f", value)
+	}
+	if metrics["active_users"].Count != 30 {
+		t.Errorf("Expected union of 30 users, got %d", metrics["active_users"].Count)
+	}
+	if latency := metrics["latency"]; latency.Count != 50 || latency.Min() != 0 || latency.Max() != 29 {
+		t.Errorf("Expected merged histogram of 50 values in [0, 29], got count=%d [%.0f, %.0f]", latency.Count, latency.Min(), latency.Max())
+	}
+	if top := metrics["top_pages"].Top(1); len(top) != 1 || top[0].Value != "/page/0" || top[0].Count != 20 {
+		t.Errorf("Expected /page/0=20 on top, got %+v", top)
+	}
+	// La gauge garde la dernière valeur de la fenêtre la plus récente
+	if cart := metrics["cart_size"]; cart.Value != 29 || cart.Min() != 0 || cart.Max() != 29 || cart.Count != 50 {
+		t.Errorf("Expected gauge last=29 in [0, 29] over 50 values, got %.0f [%.0f, %.0f] %d", cart.Value, cart.Min(), cart.Max(), cart.Count)
+	}
+
+	if _, err := MergeWindows(); err == nil {
+		t.Error("Expected an error without windows")
+	}
+}
+
+// TestMergeWindowsDerivedGauges teste le recalcul des gauges dérivées
+// (montant moyen, débit, conversions) sur la fenêtre fusionnée
+func TestMergeWindowsDerivedGauges(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	spec := WindowSpec{Name: "tumbling_1m", Metrics: []string{WindowMetricCount, WindowMetricAvg, WindowMetricRate}}
+	funnel := Funnel{Name: "signup", Steps: []FunnelStep{{EventType: "visit"}, {EventType: "signup"}}}
+
+	windows := make([]*TimeWindow, 0, 2)
+	for i, amounts := range [][]float64{{10}, {30, 30}} {
+		window := newTimeWindow(start.Add(time.Duration(i)*time.Minute), time.Minute, SnapshotOptions{})
+		window.Spec = spec.Name
+		for _, amount := range amounts {
+			window.Metrics.GetMetric("events", MetricTypeCounter).Increment()
+			window.Metrics.GetMetric("amount", MetricTypeCounter).IncrementBy(amount)
+		}
+		// 2 visites, puis 1 inscription dans la première minute et 2 dans la seconde
+		window.Metrics.GetMetricWithLabels(FunnelMetricStep, funnelLabels("signup", 1), MetricTypeCounter).IncrementBy(2)
+		window.Metrics.GetMetricWithLabels(FunnelMetricStep, funnelLabels("signup", 2), MetricTypeCounter).IncrementBy(float64(i + 1))
+		deriveWindowMetrics(window.Metrics, spec, window.Duration)
+		finalizeFunnels(window, []Funnel{funnel})
+		window.Close()
+		windows = append(windows, window)
+	}
+
+	merged, err := MergeWindows(windows...)
+	if err != nil {
+		t.Fatalf("Unexpected merge error: %v", err)
+	}
+	checks := map[string]float64{
+		"amount_avg":  70.0 / 3,
+		"events_rate": 3.0 / 120,
+		SeriesKey(FunnelMetricConversion, funnelLabels("signup", 2)): 0.75,
+	}
+	for key, want := range checks {
+		if got, _ := merged.Metrics.GetMetricValue(key); got != want {
+			t.Errorf("Expected %s = %v over the merged window, got %v", key, want, got)
+		}
+	}
+	if _, ok := merged.Metrics.GetAllMetrics()["amount_p95"]; ok {
+		t.Error("Expected no gauge the source windows did not compute")
+	}
+}
+
+// TestSnapshotStateRoundTrip teste l'aller-retour JSON complet d'un snapshot
+func TestSnapshotStateRoundTrip(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	for _, mode := range []SetMode{SetModeExact, SetModeHLL} {
+		opts := SnapshotOptions{Clock: clock, Sets: SetOptions{Mode: mode, Precision: 12}}
+		original := NewMetricsSnapshotWithOptions(opts)
+		fillSnapshot(original, 0)
+
+		data, err := json.Marshal(original.State())
+		if err != nil {
+			t.Fatalf("%s: unexpected marshal error: %v", mode, err)
+		}
+		var state SnapshotState
+		if err := json.Unmarshal(data, &state); err != nil {
+			t.Fatalf("%s: unexpected unmarshal error: %v", mode, err)
+		}
+		restored, err := NewMetricsSnapshotFromState(state, opts)
+		if err != nil {
+			t.Fatalf("%s: unexpected restore error: %v", mode, err)
+		}
+
+		want, got := original.GetAllMetrics(), restored.GetAllMetrics()
+		if len(got) != len(want) {
+			t.Fatalf("%s: expected %d series, got %d", mode, len(want), len(got))
+		}
+		for key, metric := range want {
+			restoredMetric := got[key]
+			if restoredMetric == nil {
+				t.Errorf("%s: missing series %s", mode, key)
+				continue
+			}
+			if restoredMetric.Value != metric.Value || restoredMetric.Count != metric.Count || !restoredMetric.Timestamp.Equal(metric.Timestamp) {
+				t.Errorf("%s: %s restored as value=%.2f count=%d, expected value=%.2f count=%d",
+					mode, key, restoredMetric.Value, restoredMetric.Count, metric.Value, metric.Count)
+			}
+		}
+		if got["latency"].Quantile(0.5) != want["latency"].Quantile(0.5) {
+			t.Errorf("%s: expected same median after restore", mode)
+		}
+		if got["active_users"].IsExact() != (mode == SetModeExact) {
+			t.Errorf("%s: set representation not preserved", mode)
+		}
+
+		// Les structures restaurées restent modifiables et fusionnables
+		restored.GetMetric("active_users", MetricTypeSet).AddUnique("user_new")
+		restored.GetMetric("top_pages", MetricTypeTopK).AddTopK("/page/1")
+		if err := restored.Merge(original); err != nil {
+			t.Fatalf("%s: unexpected merge error: %v", mode, err)
+		}
+		if users := restored.GetAllMetrics()["active_users"].Count; users != 11 {
+			t.Errorf("%s: expected 11 users after update and merge, got %d", mode, users)
+		}
+		if top := restored.GetAllMetrics()["top_pages"].Top(1); top[0].Value != "/page/0" || top[0].Count != 8 {
+			t.Errorf("%s: unexpected top after merge %+v", mode, top)
+		}
+	}
+
+	if _, err := NewMetricsSnapshotFromState(SnapshotState{Version: 99}, SnapshotOptions{}); err == nil {
+		t.Error("Expected an error for an unknown state version")
+	}
+	bad := SnapshotState{Version: SnapshotStateVersion, Metrics: []MetricState{{Name: "x", Type: "bogus"}}}
+	if _, err := NewMetricsSnapshotFromState(bad, SnapshotOptions{}); err == nil {
+		t.Error("Expected an error for an unknown metric type")
+	}
+}
@@ -0,0 +1,122 @@
+package aggregation
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// WindowKind identifie la stratégie de découpage d'une fenêtre
+type WindowKind string
+
+const (
+	WindowKindTumbling WindowKind = "tumbling" // fenêtres fixes, sans chevauchement
+	WindowKindSliding  WindowKind = "sliding"  // fenêtres de taille Size, une nouvelle tous les Step
+	WindowKindSession  WindowKind = "session"  // une fenêtre par session, fermée après Gap d'inactivité
+)
+
+// Métriques de fenêtre configurables (window.*.metrics dans config.yaml)
+const (
+	WindowMetricCount  = "count"  // events, events{type}
+	WindowMetricSum    = "sum"    // amount (somme des montants, voir Options.AmountProperty)
+	WindowMetricAvg    = "avg"    // amount_avg
+	WindowMetricP95    = "p95"    // amount_p95
+	WindowMetricP99    = "p99"    // amount_p99
+	WindowMetricRate   = "rate"   // events_rate (événements par seconde)
+	WindowMetricUnique = "unique" // active_users
+
+	// engagement des sessions: sessions, session_duration_*, pages_per_session*,
+	// bounces, bounce_rate, entry_pages, exit_pages
+	WindowMetricEngagement = "engagement"
+)
+
+// DefaultAmountProperty est la propriété des montants par défaut
+const DefaultAmountProperty = "amount"
+
+// DefaultWindowMetrics est utilisé quand une spec ne liste aucune métrique
+var DefaultWindowMetrics = []string{WindowMetricCount, WindowMetricUnique}
+
+var knownWindowMetrics = map[string]bool{
+	WindowMetricCount:  true,
+	WindowMetricSum:    true,
+	WindowMetricAvg:    true,
+	WindowMetricP95:    true,
+	WindowMetricP99:    true,
+	WindowMetricRate:   true,
+	WindowMetricUnique: true,
+
+	WindowMetricEngagement: true,
+}
+
+// IsKnownWindowMetric indique si le nom correspond à une métrique de fenêtre supportée
+func IsKnownWindowMetric(name string) bool {
+	return knownWindowMetrics[name]
+}
+
+// WindowSpec décrit une famille de fenêtres et les métriques à y calculer
+type WindowSpec struct {
+	Name    string        `json:"name"`
+	Kind    WindowKind    `json:"kind"`
+	Size    time.Duration `json:"size"`
+	Step    time.Duration `json:"step,omitempty"`
+	Gap     time.Duration `json:"gap,omitempty"`
+	Metrics []string      `json:"metrics"`
+
+	// AllowedLateness est la durée pendant laquelle une fenêtre fermée accepte
+	// encore des événements en retard (elle est alors réémise, amendée)
+	AllowedLateness time.Duration `json:"allowed_lateness,omitempty"`
+}
+
+// normalize complète les champs optionnels d'une spec
+func (s WindowSpec) normalize() WindowSpec {
+	if s.Kind == "" {
+		s.Kind = WindowKindTumbling
+	}
+	if len(s.Metrics) == 0 {
+		s.Metrics = DefaultWindowMetrics
+	}
+	if s.Kind == WindowKindSliding && (s.Step <= 0 || s.Step > s.Size) {
+		s.Step = s.Size
+	}
+	if s.Kind == WindowKindSession && s.Name == "" {
+		s.Name = fmt.Sprintf("%s_%s", s.Kind, shortDuration(s.Gap))
+	}
+	if s.Name == "" {
+		s.Name = fmt.Sprintf("%s_%s", s.Kind, shortDuration(s.Size))
+		if s.Kind == WindowKindSliding {
+			s.Name += "_" + shortDuration(s.Step)
+		}
+	}
+	return s
+}
+
+// HasMetric indique si la spec demande le calcul d'une métrique
+func (s WindowSpec) HasMetric(name string) bool {
+	for _, m := range s.Metrics {
+		if m == name {
+			return true
+		}
+	}
+	return false
+}
+
+// stride retourne l'écart entre les débuts de deux fenêtres consécutives de la
+// spec: Step pour une fenêtre glissante, Size sinon
+func (s WindowSpec) stride() time.Duration {
+	if s.Kind == WindowKindSliding {
+		return s.Step
+	}
+	return s.Size
+}
+
+// shortDuration formate une durée sans les zéros inutiles (1m0s -> 1m)
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = strings.TrimSuffix(s, "0s")
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = strings.TrimSuffix(s, "0m")
+	}
+	return s
+}
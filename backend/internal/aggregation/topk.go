@@ -0,0 +1,224 @@
+package aggregation
+
+import (
+	"container/heap"
+	"encoding/json"
+	"sort"
+)
+
+// DefaultTopKCapacity est le nombre de compteurs suivis par un TopK
+const DefaultTopKCapacity = 100
+
+// TopKEntry est une valeur fréquente avec son compte estimé. Le compte réel
+// est compris entre Count-Error et Count.
+type TopKEntry struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+	Error int64  `json:"error"`
+}
+
+// TopK suit les valeurs les plus fréquentes en mémoire bornée (Space-Saving,
+// Metwally et al. 2005): au plus Capacity compteurs; une nouvelle valeur
+// remplace le plus petit compteur et hérite de son compte comme erreur.
+// Toute valeur plus fréquente que Total/Capacity est garantie d'être suivie.
+// Comme DDSketch, il est verrouillé par la métrique qui le contient.
+type TopK struct {
+	Capacity int
+	Total    int64
+
+	counters map[string]*topKCounter
+	heap     topKHeap // tas min par compte
+}
+
+type topKCounter struct {
+	TopKEntry
+	index int
+}
+
+// NewTopK crée un TopK de capacity compteurs
+func NewTopK(capacity int) *TopK {
+	if capacity <= 0 {
+		capacity = DefaultTopKCapacity
+	}
+	return &TopK{
+		Capacity: capacity,
+		counters: make(map[string]*topKCounter),
+	}
+}
+
+// Add compte weight occurrences de value
+func (t *TopK) Add(value string, weight int64) {
+	if t.counters == nil {
+		t.counters = make(map[string]*topKCounter)
+	}
+	t.Total += weight
+
+	if c, ok := t.counters[value]; ok {
+		c.Count += weight
+		heap.Fix(&t.heap, c.index)
+		return
+	}
+
+	if len(t.counters) < t.Capacity {
+		c := &topKCounter{TopKEntry: TopKEntry{Value: value, Count: weight}}
+		t.counters[value] = c
+		heap.Push(&t.heap, c)
+		return
+	}
+
+	// Remplace le plus petit compteur
+	c := t.heap[0]
+	delete(t.counters, c.Value)
+	c.Value = value
+	c.Error = c.Count
+	c.Count += weight
+	t.counters[value] = c
+	heap.Fix(&t.heap, 0)
+}
+
+// Top retourne les k valeurs les plus fréquentes (toutes si k <= 0), par compte décroissant
+func (t *TopK) Top(k int) []TopKEntry {
+	entries := make([]TopKEntry, 0, len(t.counters))
+	for _, c := range t.counters {
+		entries = append(entries, c.TopKEntry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Value < entries[j].Value
+	})
+	if k > 0 && k < len(entries) {
+		entries = entries[:k]
+	}
+	return entries
+}
+
+// Merge fusionne un autre TopK. Une valeur absente d'un résumé plein y a
+// au plus le plus petit compte de ce résumé, ajouté au compte et à l'erreur.
+func (t *TopK) Merge(other *TopK) {
+	if other == nil || other.Total == 0 {
+		return
+	}
+
+	minSelf, minOther := t.minCount(), other.minCount()
+	merged := make(map[string]TopKEntry, len(t.counters)+len(other.counters))
+	for value, c := range t.counters {
+		entry := c.TopKEntry
+		if o, ok := other.counters[value]; ok {
+			entry.Count += o.Count
+			entry.Error += o.Error
+		} else {
+			entry.Count += minOther
+			entry.Error += minOther
+		}
+		merged[value] = entry
+	}
+	for value, o := range other.counters {
+		if _, ok := t.counters[value]; ok {
+			continue
+		}
+		entry := o.TopKEntry
+		entry.Count += minSelf
+		entry.Error += minSelf
+		merged[value] = entry
+	}
+
+	entries := make([]TopKEntry, 0, len(merged))
+	for _, entry := range merged {
+		entries = append(entries, entry)
+	}
+	t.reset(entries)
+	t.Total += other.Total
+}
+
+// Clone retourne une copie indépendante
+func (t *TopK) Clone() *TopK {
+	c := NewTopK(t.Capacity)
+	c.reset(t.Top(0))
+	c.Total = t.Total
+	return c
+}
+
+// minCount retourne le plus petit compte si le résumé est plein, 0 sinon
+// (une valeur absente d'un résumé incomplet n'a jamais été vue)
+func (t *TopK) minCount() int64 {
+	if len(t.counters) < t.Capacity || len(t.heap) == 0 {
+		return 0
+	}
+	return t.heap[0].Count
+}
+
+// reset remplace les compteurs par les Capacity entrées les plus fréquentes
+func (t *TopK) reset(entries []TopKEntry) {
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Value < entries[j].Value
+	})
+	if len(entries) > t.Capacity {
+		entries = entries[:t.Capacity]
+	}
+
+	t.counters = make(map[string]*topKCounter, len(entries))
+	t.heap = make(topKHeap, 0, len(entries))
+	for _, entry := range entries {
+		c := &topKCounter{TopKEntry: entry}
+		t.counters[entry.Value] = c
+		heap.Push(&t.heap, c)
+	}
+}
+
+// topKJSON est la forme sérialisée d'un TopK
+type topKJSON struct {
+	Capacity int         `json:"capacity"`
+	Total    int64       `json:"total"`
+	Entries  []TopKEntry `json:"entries"`
+}
+
+// MarshalJSON sérialise la capacité, le total et les compteurs
+func (t *TopK) MarshalJSON() ([]byte, error) {
+	return json.Marshal(topKJSON{Capacity: t.Capacity, Total: t.Total, Entries: t.Top(0)})
+}
+
+// UnmarshalJSON reconstruit un TopK sérialisé par MarshalJSON
+func (t *TopK) UnmarshalJSON(data []byte) error {
+	var decoded topKJSON
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	t.Capacity = decoded.Capacity
+	if t.Capacity <= 0 {
+		t.Capacity = DefaultTopKCapacity
+	}
+	t.reset(decoded.Entries)
+	t.Total = decoded.Total
+	return nil
+}
+
+// topKHeap est un tas min de compteurs (container/heap)
+type topKHeap []*topKCounter
+
+func (h topKHeap) Len() int { return len(h) }
+
+func (h topKHeap) Less(i, j int) bool { return h[i].Count < h[j].Count }
+
+func (h topKHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].index = i
+	h[j].index = j
+}
+
+func (h *topKHeap) Push(x interface{}) {
+	c := x.(*topKCounter)
+	c.index = len(*h)
+	*h = append(*h, c)
+}
+
+func (h *topKHeap) Pop() interface{} {
+	old := *h
+	c := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return c
+}
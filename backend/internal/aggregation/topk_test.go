@@ -0,0 +1,121 @@
+package aggregation
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TestTopKHeavyHitters teste que les valeurs fréquentes survivent à un flux de valeurs rares
+func TestTopKHeavyHitters(t *testing.T) {
+	topK := NewTopK(20)
+	for i := 0; i < 1000; i++ {
+		topK.Add("/home", 1)
+		if i%2 == 0 {
+			topK.Add("/cart", 1)
+		}
+		topK.Add(fmt.Sprintf("/crawler/%d", i), 1) // une seule fois chacune
+	}
+
+	top := topK.Top(2)
+	if len(top) != 2 || top[0].Value != "/home" || top[1].Value != "/cart" {
+		t.Fatalf("Expected /home then /cart, got %+v", top)
+	}
+	for _, entry := range top {
+		exact := map[string]int64{"/home": 1000, "/cart": 500}[entry.Value]
+		if entry.Count < exact || entry.Count-entry.Error > exact {
+			t.Errorf("%s: exact count %d outside [%d, %d]", entry.Value, exact, entry.Count-entry.Error, entry.Count)
+		}
+	}
+	if topK.Total != 2500 {
+		t.Errorf("Expected total 2500, got %d", topK.Total)
+	}
+	if len(topK.Top(0)) != 20 {
+		t.Errorf("Expected capacity to bound the counters, got %d", len(topK.Top(0)))
+	}
+}
+
+// TestTopKMergeAndJSON teste la fusion de deux résumés et l'aller-retour JSON
+func TestTopKMergeAndJSON(t *testing.T) {
+	a := NewTopK(10)
+	b := NewTopK(10)
+	a.Add("buy-btn", 30)
+	a.Add("nav", 5)
+	b.Add("buy-btn", 10)
+	b.Add("search", 20)
+
+	a.Merge(b)
+	top := a.Top(0)
+	if top[0].Value != "buy-btn" || top[0].Count != 40 || top[0].Error != 0 {
+		t.Errorf("Expected buy-btn=40 exact, got %+v", top[0])
+	}
+	if top[1].Value != "search" || top[1].Count != 20 {
+		t.Errorf("Expected search=20, got %+v", top[1])
+	}
+
+	data, err := json.Marshal(a)
+	if err != nil {
+		t.Fatalf("Unexpected marshal error: %v", err)
+	}
+	var decoded TopK
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatalf("Unexpected unmarshal error: %v", err)
+	}
+	if decoded.Total != a.Total || len(decoded.Top(0)) != 3 || decoded.Top(1)[0] != top[0] {
+		t.Errorf("Round trip mismatch: %+v", decoded.Top(0))
+	}
+
+	// Le résumé décodé reste utilisable
+	decoded.Add("search", 25)
+	if decoded.Top(1)[0].Value != "search" {
+		t.Errorf("Expected search to lead after update, got %+v", decoded.Top(1))
+	}
+}
+
+// TestAggregatorTopK teste les top-k globaux et par fenêtre des règles par défaut
+func TestAggregatorTopK(t *testing.T) {
+	logger := zap.NewNop()
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	agg := NewAggregatorWithOptions(Options{
+		Windows:       []WindowSpec{{Kind: WindowKindTumbling, Size: 1 * time.Minute}},
+		FlushInterval: 10 * time.Second,
+		Clock:         clock,
+	}, logger)
+
+	pageview := func(ts time.Time, page string) {
+		agg.ProcessEvent(Event{Type: "pageview", Timestamp: ts, Properties: map[string]interface{}{"page": page}})
+	}
+	for i := 0; i < 3; i++ {
+		pageview(start, "/home")
+	}
+	pageview(start, "/cart")
+
+	// Minute suivante: /cart domine
+	clock.Advance(time.Minute)
+	for i := 0; i < 5; i++ {
+		pageview(start.Add(time.Minute), "/cart")
+	}
+	clock.Advance(2 * time.Minute)
+	agg.Flush()
+
+	global := agg.GlobalTopK("top_pages", nil, 1)
+	if len(global) != 1 || global[0].Value != "/cart" || global[0].Count != 6 {
+		t.Errorf("Expected global top page /cart=6, got %+v", global)
+	}
+
+	window, top := agg.WindowTopK("tumbling_1m", "top_pages", nil, 10)
+	if window == nil || !window.StartTime.Equal(start.Add(time.Minute)) {
+		t.Fatalf("Expected latest closed window to start at %s", start.Add(time.Minute))
+	}
+	if len(top) != 1 || top[0].Value != "/cart" || top[0].Count != 5 {
+		t.Errorf("Expected window top /cart=5, got %+v", top)
+	}
+
+	if window, _ := agg.WindowTopK("sliding_5m_1m", "top_pages", nil, 10); window != nil {
+		t.Error("Expected no window for an unknown spec")
+	}
+}
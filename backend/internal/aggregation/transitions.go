@@ -0,0 +1,164 @@
+package aggregation
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// TransitionMetric compte les passages d'une page à la suivante dans une
+// session: page_transitions{from="/pricing",to="/signup"}
+const TransitionMetric = "page_transitions"
+
+// DefaultTransitionGap est l'inactivité après laquelle la dernière page d'une
+// session est oubliée
+const DefaultTransitionGap = 30 * time.Minute
+
+// transitionStripes est le nombre de verrous des dernières pages vues
+const transitionStripes = 32
+
+// TransitionOptions configure le suivi des transitions entre pages
+type TransitionOptions struct {
+	// Gap est l'inactivité qui termine une session (0: DefaultTransitionGap)
+	Gap time.Duration
+}
+
+// PageTransition est le nombre de passages d'une page à une autre
+type PageTransition struct {
+	From  string `json:"from"`
+	To    string `json:"to"`
+	Count int64  `json:"count"`
+}
+
+// eventPage retourne la page d'une page vue (propriété page)
+func eventPage(event Event) (string, bool) {
+	if event.Type != "pageview" {
+		return "", false
+	}
+	value, ok := eventField(event, "page")
+	if !ok {
+		return "", false
+	}
+	page := fieldString(value)
+	return page, page != ""
+}
+
+// lastPageview est la dernière page vue d'une session
+type lastPageview struct {
+	page string
+	at   time.Time
+}
+
+// transitionTracker retient la dernière page vue de chaque session
+// (SessionKey). Comme pour les funnels, les verrous sont répartis par clé.
+type transitionTracker struct {
+	gap     time.Duration
+	stripes [transitionStripes]struct {
+		mu    sync.Mutex
+		pages map[string]lastPageview
+	}
+}
+
+// newTransitionTracker crée un suivi des transitions vide
+func newTransitionTracker(opts TransitionOptions) *transitionTracker {
+	if opts.Gap <= 0 {
+		opts.Gap = DefaultTransitionGap
+	}
+	t := &transitionTracker{gap: opts.Gap}
+	for i := range t.stripes {
+		t.stripes[i].pages = make(map[string]lastPageview)
+	}
+	return t
+}
+
+// track retourne la transition d'une page vue depuis la page précédente de sa
+// session. Une page vue plus ancienne que la dernière est ignorée (l'ordre des
+// pages n'est pas connu), et un rechargement de la même page n'est pas une
+// transition.
+func (t *transitionTracker) track(event Event) (from, to string, ok bool) {
+	key := SessionKey(event)
+	page, isPage := eventPage(event)
+	if key == "" || !isPage {
+		return "", "", false
+	}
+
+	stripe := &t.stripes[hashString(key)%transitionStripes]
+	stripe.mu.Lock()
+	defer stripe.mu.Unlock()
+
+	last, exists := stripe.pages[key]
+	if exists && event.Timestamp.Before(last.at) {
+		return "", "", false
+	}
+	stripe.pages[key] = lastPageview{page: page, at: event.Timestamp}
+	if !exists || event.Timestamp.Sub(last.at) > t.gap || last.page == page {
+		return "", "", false
+	}
+	return last.page, page, true
+}
+
+// expire oublie les sessions inactives depuis plus que gap à now
+func (t *transitionTracker) expire(now time.Time) {
+	for i := range t.stripes {
+		stripe := &t.stripes[i]
+		stripe.mu.Lock()
+		for key, last := range stripe.pages {
+			if now.Sub(last.at) > t.gap {
+				delete(stripe.pages, key)
+			}
+		}
+		stripe.mu.Unlock()
+	}
+}
+
+// reset oublie les dernières pages de toutes les sessions
+func (t *transitionTracker) reset() {
+	for i := range t.stripes {
+		stripe := &t.stripes[i]
+		stripe.mu.Lock()
+		stripe.pages = make(map[string]lastPageview)
+		stripe.mu.Unlock()
+	}
+}
+
+// sessions retourne le nombre de sessions dont la dernière page est retenue
+func (t *transitionTracker) sessions() int {
+	count := 0
+	for i := range t.stripes {
+		stripe := &t.stripes[i]
+		stripe.mu.Lock()
+		count += len(stripe.pages)
+		stripe.mu.Unlock()
+	}
+	return count
+}
+
+// recordTransition compte une transition dans un snapshot
+func recordTransition(metrics *MetricsSnapshot, from, to string) {
+	metrics.GetMetricWithLabels(TransitionMetric, Labels{"from": from, "to": to}, MetricTypeCounter).Increment()
+}
+
+// pageTransitions convertit des séries page_transitions en transitions,
+// triées par compte décroissant puis par pages
+func pageTransitions(series []*Metric) []PageTransition {
+	transitions := make([]PageTransition, 0, len(series))
+	for _, metric := range series {
+		summary := metric.Summary()
+		transitions = append(transitions, PageTransition{
+			From:  metric.Labels["from"],
+			To:    metric.Labels["to"],
+			Count: int64(summary.Value),
+		})
+	}
+	sort.Slice(transitions, func(i, j int) bool {
+		a, b := transitions[i], transitions[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		if a.From != b.From {
+			return a.From < b.From
+		}
+		return a.To < b.To
+	})
+	return transitions
+}
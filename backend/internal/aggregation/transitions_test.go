@@ -0,0 +1,81 @@
+package aggregation
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TestAggregatorPageTransitions teste les transitions de pages par session, globales et par fenêtre
+func TestAggregatorPageTransitions(t *testing.T) {
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start.Add(time.Hour))
+	agg := NewAggregatorWithOptions(Options{
+		Windows:         []WindowSpec{{Kind: WindowKindTumbling, Size: time.Hour}},
+		FlushInterval:   10 * time.Second,
+		AllowedLateness: time.Hour,
+		Clock:           clock,
+		Shards:          4,
+		Transitions:     &TransitionOptions{Gap: 30 * time.Minute},
+	}, zap.NewNop())
+
+	pageview := func(session, page string, offset time.Duration) Event {
+		return Event{
+			Type:       "pageview",
+			SessionID:  session,
+			Timestamp:  start.Add(offset),
+			Properties: map[string]interface{}{"page": page},
+		}
+	}
+
+	// 5 sessions: /home -> /pricing, puis 3 vers /signup et 2 vers /docs
+	for i := 0; i < 5; i++ {
+		session := fmt.Sprintf("s%d", i)
+		next := "/signup"
+		if i >= 3 {
+			next = "/docs"
+		}
+		agg.ProcessEvent(pageview(session, "/home", 0))
+		agg.ProcessEvent(pageview(session, "/home", time.Second)) // rechargement: ignoré
+		agg.ProcessEvent(Event{Type: "click", SessionID: session, Timestamp: start.Add(2 * time.Second)})
+		agg.ProcessEvent(pageview(session, "/pricing", time.Minute))
+		agg.ProcessEvent(pageview(session, next, 2*time.Minute))
+	}
+	// Page vue plus ancienne que la dernière de la session: ordre inconnu, ignorée
+	agg.ProcessEvent(pageview("s0", "/about", 30*time.Second))
+	// Après l'inactivité, une nouvelle session commence sans transition
+	agg.ProcessEvent(pageview("s1", "/blog", 40*time.Minute))
+
+	next := agg.GlobalTransitions(Labels{"from": "/pricing"})
+	if len(next) != 2 || next[0] != (PageTransition{From: "/pricing", To: "/signup", Count: 3}) || next[1].To != "/docs" || next[1].Count != 2 {
+		t.Errorf("Unexpected next pages after /pricing: %+v", next)
+	}
+	previous := agg.GlobalTransitions(Labels{"to": "/pricing"})
+	if len(previous) != 1 || previous[0].From != "/home" || previous[0].Count != 5 {
+		t.Errorf("Unexpected previous pages of /pricing: %+v", previous)
+	}
+	if all := agg.GlobalTransitions(nil); len(all) != 3 {
+		t.Errorf("Expected 3 distinct transitions, got %+v", all)
+	}
+
+	window, inWindow := agg.WindowTransitions("tumbling_1h", Labels{"from": "/home"})
+	if window == nil || len(inWindow) != 1 || inWindow[0].Count != 5 {
+		t.Errorf("Unexpected window transitions %+v", inWindow)
+	}
+	if stats := agg.GetStats(); stats["transition_sessions"] != 5 {
+		t.Errorf("Expected 5 tracked sessions, got %v", stats["transition_sessions"])
+	}
+
+	clock.Advance(time.Hour)
+	agg.Flush()
+	if stats := agg.GetStats(); stats["transition_sessions"] != 0 {
+		t.Errorf("Expected idle sessions to be forgotten, got %v", stats["transition_sessions"])
+	}
+
+	disabled := NewAggregator(time.Minute, time.Second, zap.NewNop())
+	if disabled.TransitionsEnabled() || disabled.GlobalTransitions(nil) != nil {
+		t.Error("Expected transitions to be disabled by default")
+	}
+}
@@ -0,0 +1,121 @@
+package aggregation
+
+import (
+	"time"
+)
+
+// LateEvent est la sortie annexe des événements arrivés après la période de retard autorisé
+type LateEvent struct {
+	Event          Event     `json:"event"`
+	Spec           string    `json:"spec"`
+	Watermark      time.Time `json:"watermark"`
+	DroppedWindows int       `json:"dropped_windows"`
+}
+
+// AddEvent applique update à chaque fenêtre qui contient t, sous le verrou du gestionnaire.
+// Une fenêtre déjà fermée mais encore dans la période de retard autorisé est mise à
+// jour et marquée amendée. Retourne le nombre de fenêtres refusées car trop anciennes.
+func (wm *WindowManager) AddEvent(t time.Time, update func(*TimeWindow)) (dropped int) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	windows, dropped := wm.acceptLocked(t)
+	for _, window := range windows {
+		update(window)
+		if window.Closed {
+			window.amended = true
+		}
+	}
+	return dropped
+}
+
+// AcceptEvent retourne les fenêtres qui contiennent t, sans les mettre à jour:
+// l'appelant accumule ses métriques à part et les fusionne avec MergeWindow.
+// Retourne aussi le nombre de fenêtres refusées car trop anciennes.
+func (wm *WindowManager) AcceptEvent(t time.Time) (windows []*TimeWindow, dropped int) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	return wm.acceptLocked(t)
+}
+
+// MergeWindow fusionne des métriques accumulées pour une fenêtre retournée par
+// AcceptEvent. Une fenêtre fermée entre-temps est marquée amendée.
+func (wm *WindowManager) MergeWindow(window *TimeWindow, metrics *MetricsSnapshot) error {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	if window.Closed {
+		window.amended = true
+	}
+	return window.Metrics.Merge(metrics)
+}
+
+// acceptLocked crée au besoin les fenêtres qui contiennent t et avance le
+// watermark (wm.mu doit être tenu)
+func (wm *WindowManager) acceptLocked(t time.Time) (windows []*TimeWindow, dropped int) {
+	starts := wm.windowStarts(t)
+	windows = make([]*TimeWindow, 0, len(starts))
+	for _, start := range starts {
+		if !wm.acceptsLocked(start) {
+			dropped++
+			continue
+		}
+		windows = append(windows, wm.findOrCreateLocked(start))
+	}
+
+	// Le temps d'événement fait avancer le watermark, sans dépasser l'horloge
+	// locale pour qu'un client en avance ne rende pas tous les autres en retard
+	now := wm.clock.Now()
+	if t.After(now) {
+		t = now
+	}
+	wm.advanceWatermarkLocked(t)
+
+	return windows, dropped
+}
+
+// TakeAmendedWindows retourne les fenêtres fermées mises à jour depuis leur dernière
+// émission, avec leur numéro de révision incrémenté
+func (wm *WindowManager) TakeAmendedWindows() []*TimeWindow {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+
+	amended := make([]*TimeWindow, 0)
+	for _, window := range wm.Windows {
+		if window.amended {
+			window.amended = false
+			window.Revision++
+			amended = append(amended, window)
+		}
+	}
+	return amended
+}
+
+// AdvanceWatermark avance le watermark jusqu'à t (il ne recule jamais)
+func (wm *WindowManager) AdvanceWatermark(t time.Time) {
+	wm.mu.Lock()
+	defer wm.mu.Unlock()
+	wm.advanceWatermarkLocked(t)
+}
+
+// Watermark retourne le watermark courant
+func (wm *WindowManager) Watermark() time.Time {
+	wm.mu.RLock()
+	defer wm.mu.RUnlock()
+	return wm.watermark
+}
+
+// advanceWatermarkLocked avance le watermark (wm.mu doit être tenu)
+func (wm *WindowManager) advanceWatermarkLocked(t time.Time) {
+	if t.After(wm.watermark) {
+		wm.watermark = t
+	}
+}
+
+// acceptsLocked indique si la fenêtre commençant à start accepte encore des
+// événements, c'est-à-dire si le watermark n'a pas dépassé sa fin plus le
+// retard autorisé (wm.mu doit être tenu)
+func (wm *WindowManager) acceptsLocked(start time.Time) bool {
+	end := start.Add(wm.duration)
+	return end.Add(wm.spec.AllowedLateness).After(wm.watermark)
+}
@@ -0,0 +1,109 @@
+package aggregation
+
+import (
+	"testing"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// TestWindowManagerLateness teste le retard autorisé: pas de fenêtre fantôme
+func TestWindowManagerLateness(t *testing.T) {
+	base := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	wm := NewWindowManagerForSpec(WindowSpec{
+		Kind:            WindowKindTumbling,
+		Size:            1 * time.Minute,
+		AllowedLateness: 2 * time.Minute,
+	}, NewManualClock(base.Add(10*time.Minute)))
+	noop := func(*TimeWindow) {}
+
+	wm.AddEvent(base.Add(10*time.Second), noop)
+	if closed := wm.CloseExpiredWindows(base.Add(90 * time.Second)); len(closed) != 1 {
+		t.Fatalf("Expected 1 closed window, got %d", len(closed))
+	}
+
+	// En retard mais dans la période autorisée: la fenêtre d'origine est amendée
+	var updated *TimeWindow
+	if dropped := wm.AddEvent(base.Add(20*time.Second), func(w *TimeWindow) { updated = w }); dropped != 0 {
+		t.Fatalf("Expected late event to be accepted, %d windows dropped", dropped)
+	}
+	if len(wm.Windows) != 1 {
+		t.Fatalf("Expected no duplicate window, got %d windows", len(wm.Windows))
+	}
+	if updated != wm.Windows[0] {
+		t.Error("Expected the original window to be updated")
+	}
+
+	amended := wm.TakeAmendedWindows()
+	if len(amended) != 1 || amended[0].Revision != 1 {
+		t.Fatalf("Expected 1 amended window with revision 1, got %d", len(amended))
+	}
+	if len(wm.TakeAmendedWindows()) != 0 {
+		t.Error("Expected amendments to be consumed")
+	}
+
+	// Au-delà du retard autorisé: l'événement est refusé
+	wm.AdvanceWatermark(base.Add(3*time.Minute + time.Second))
+	if dropped := wm.AddEvent(base.Add(30*time.Second), noop); dropped != 1 {
+		t.Errorf("Expected too-late event to be dropped, got %d", dropped)
+	}
+	if len(wm.Windows) != 1 {
+		t.Errorf("Expected no phantom window, got %d windows", len(wm.Windows))
+	}
+}
+
+// TestAggregatorLateEvents teste la réémission amendée et la sortie annexe
+func TestAggregatorLateEvents(t *testing.T) {
+	logger := zap.NewNop()
+	start := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := NewManualClock(start)
+	agg := NewAggregatorWithOptions(Options{
+		Windows:         []WindowSpec{{Kind: WindowKindTumbling, Size: 1 * time.Minute}},
+		FlushInterval:   10 * time.Second,
+		AllowedLateness: 1 * time.Hour,
+		Clock:           clock,
+	}, logger)
+
+	var emitted []*TimeWindow
+	agg.SetWindowClosedCallback(func(window *TimeWindow) {
+		emitted = append(emitted, window)
+	})
+	var late []LateEvent
+	agg.SetLateEventCallback(func(event LateEvent) {
+		late = append(late, event)
+	})
+
+	agg.ProcessEvent(Event{ID: "evt_1", Type: "pageview", Timestamp: start, UserID: "user_1"})
+	clock.Advance(30 * time.Minute)
+	agg.Flush()
+	if len(emitted) != 1 || emitted[0].Revision != 0 {
+		t.Fatalf("Expected first emission with revision 0, got %d emissions", len(emitted))
+	}
+
+	agg.ProcessEvent(Event{ID: "evt_2", Type: "pageview", Timestamp: start.Add(time.Second), UserID: "user_2"})
+	agg.Flush()
+	if len(emitted) != 2 {
+		t.Fatalf("Expected amended window to be re-emitted, got %d emissions", len(emitted))
+	}
+	if emitted[1] != emitted[0] || emitted[1].Revision != 1 {
+		t.Errorf("Expected same window re-emitted with revision 1, got revision %d", emitted[1].Revision)
+	}
+	if events, _ := emitted[1].Metrics.GetMetricValue("events"); events != 2 {
+		t.Errorf("Expected amended window to count 2 events, got %.0f", events)
+	}
+
+	// Plus vieux que le retard autorisé: sortie annexe et compteur
+	agg.ProcessEvent(Event{ID: "evt_3", Type: "pageview", Timestamp: start.Add(-2 * time.Hour), UserID: "user_3"})
+	if len(late) != 1 || late[0].Event.ID != "evt_3" {
+		t.Fatalf("Expected evt_3 on the late side output, got %d late events", len(late))
+	}
+	lateEvents := agg.GetStats()["late_events"].(map[string]int64)
+	if lateEvents["tumbling_1m"] != 1 {
+		t.Errorf("Expected 1 late event counted, got %d", lateEvents["tumbling_1m"])
+	}
+
+	agg.Flush()
+	if len(emitted) != 2 {
+		t.Errorf("Expected no phantom window emitted, got %d emissions", len(emitted))
+	}
+}
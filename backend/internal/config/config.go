@@ -9,12 +9,14 @@ import (
 
 // config holds all application configuration
 type Config struct {
-	Server     ServerConfig     `mapstructure:"server"`
-	Processing ProcessingConfig `mapstructure:"processing"`
-	Logging    LoggingConfig    `mapstructure:"logging"`
-	Storage    StorageConfig    `mapstructure:"storage"`
-	Window     WindowConfig     `mapstructure:"window"`
-	Monitoring MonitoringConfig `mapstructure:"monitoring"`
+	Server      ServerConfig      `mapstructure:"server"`
+	Processing  ProcessingConfig  `mapstructure:"processing"`
+	Logging     LoggingConfig     `mapstructure:"logging"`
+	Storage     StorageConfig     `mapstructure:"storage"`
+	Window      WindowConfig      `mapstructure:"window"`
+	Aggregation AggregationConfig `mapstructure:"aggregation"`
+	Monitoring  MonitoringConfig  `mapstructure:"monitoring"`
+	Alerting    AlertingConfig    `mapstructure:"alerting"`
 }
 
 // ServerConfig holds HTTP server configuration
@@ -33,22 +35,85 @@ type ProcessingConfig struct {
 	BufferSize    int           `mapstructure:"buffer_size"`
 	BatchSize     int           `mapstructure:"batch_size"`
 	FlushInterval time.Duration `mapstructure:"flush_interval"`
+
+	Dedup DedupConfig `mapstructure:"dedup"`
+}
+
+// DedupConfig controls the deduplication of events by client-supplied ID
+// before they are queued
+type DedupConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+
+	// TTL is how long an accepted ID is remembered after it was last sent
+	TTL time.Duration `mapstructure:"ttl"`
+
+	// Capacity bounds the remembered IDs; the least recently sent are forgotten first
+	Capacity int `mapstructure:"capacity"`
 }
 
 // storageConfig holds storage configuration
 type StorageConfig struct {
 	Postgres PostgresConfig `mapstructure:"postgres"`
 	Redis    RedisConfig    `mapstructure:"redis"`
+
+	Checkpoint CheckpointConfig `mapstructure:"checkpoint"`
+	WAL        WALConfig        `mapstructure:"wal"`
+}
+
+// WALConfig controls the write-ahead log that accepted events are appended
+// to before they are acknowledged, replayed on startup
+type WALConfig struct {
+	Enabled bool   `mapstructure:"enabled"`
+	Dir     string `mapstructure:"dir"`
+
+	// SegmentSize is the size in bytes after which a new segment is started
+	SegmentSize int64 `mapstructure:"segment_size"`
+
+	// Sync is "always" (fsync before the ack), "interval" or "never"
+	Sync         string        `mapstructure:"sync"`
+	SyncInterval time.Duration `mapstructure:"sync_interval"`
+
+	// CommitInterval is how often processed segments are removed when
+	// checkpoints are disabled (with checkpoints, after each checkpoint)
+	CommitInterval time.Duration `mapstructure:"commit_interval"`
+}
+
+// CheckpointConfig controls periodic checkpoints of the aggregator state
+// (global metrics, open windows) restored on startup
+type CheckpointConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+
+	// Backend is "file" (Path) or "postgres" (aggregator_checkpoints table)
+	Backend  string        `mapstructure:"backend"`
+	Path     string        `mapstructure:"path"`
+	Interval time.Duration `mapstructure:"interval"`
 }
 
 // PostgresConfig holds Postgres database configuration
 type PostgresConfig struct {
+	// Enabled turns on persistence of closed 1-minute windows to metrics_1m
+	Enabled        bool   `mapstructure:"enabled"`
 	Host           string `mapstructure:"host"`
 	Port           int    `mapstructure:"port"`
 	Database       string `mapstructure:"database"`
 	User           string `mapstructure:"user"`
 	Password       string `mapstructure:"password"`
 	MaxConnections int    `mapstructure:"max_connections"`
+
+	// Spec names the 1-minute tumbling window spec written to metrics_1m
+	Spec string `mapstructure:"spec"`
+
+	Rollups RollupConfig `mapstructure:"rollups"`
+}
+
+// RollupConfig controls the hourly and daily rollups of metrics_1m into
+// metrics_1h and metrics_1d (only when Postgres persistence is enabled)
+type RollupConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+
+	// Grace is how long a finished hour or day waits for its last (or amended)
+	// windows before being rolled up; 0 uses the allowed lateness plus two flushes
+	Grace time.Duration `mapstructure:"grace"`
 }
 
 // RedisConfig holds Redis database configuration
@@ -63,15 +128,189 @@ type RedisConfig struct {
 type WindowConfig struct {
 	Tumbling []WindowSpec `mapstructure:"tumbling"`
 	Sliding  []WindowSpec `mapstructure:"sliding"`
+	Session  []WindowSpec `mapstructure:"session"`
+
+	// AllowedLateness is how long a closed window still accepts late events
+	AllowedLateness time.Duration `mapstructure:"allowed_lateness"`
 }
 
 // WindowSpec defines a time window specification
 type WindowSpec struct {
 	Size    time.Duration `mapstructure:"size"`
 	Step    time.Duration `mapstructure:"step"`
+	Gap     time.Duration `mapstructure:"gap"`
 	Metrics []string      `mapstructure:"metrics"`
 }
 
+// AggregationConfig holds metric aggregation configuration
+type AggregationConfig struct {
+	Sets SetConfig `mapstructure:"sets"`
+
+	// Rules declares event-derived metrics; the built-in pageview/click/purchase
+	// rules are used when empty
+	Rules []MetricRuleConfig `mapstructure:"rules"`
+
+	Cardinality CardinalityConfig `mapstructure:"cardinality"`
+
+	// TopKCapacity is the number of counters kept by each topk metric
+	TopKCapacity int `mapstructure:"topk_capacity"`
+
+	// Gauges lists numeric event properties (cart_size, queue_length...) tracked
+	// as gauges with min/max/last/avg, globally and per window
+	Gauges []string `mapstructure:"gauges"`
+
+	// AmountProperty is the numeric event property summed and measured by the
+	// sum, avg, p95 and p99 window metrics
+	AmountProperty string `mapstructure:"amount_property"`
+
+	// Funnels declares conversion funnels tracked per user or session
+	Funnels []FunnelConfig `mapstructure:"funnels"`
+
+	Retention RetentionConfig `mapstructure:"retention"`
+
+	Transitions TransitionConfig `mapstructure:"transitions"`
+
+	Anomalies AnomalyConfig `mapstructure:"anomalies"`
+
+	Rolling RollingConfig `mapstructure:"rolling"`
+}
+
+// RollingConfig controls the rolling "last N" aggregates of the global metrics
+type RollingConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+
+	// Spec is the tumbling window spec whose size sets the resolution of the
+	// ring (e.g. tumbling_1m); the smallest tumbling spec when empty
+	Spec string `mapstructure:"spec"`
+
+	// Ranges lists the rolling ranges served by the API
+	Ranges []time.Duration `mapstructure:"ranges"`
+}
+
+// AnomalyConfig controls EWMA z-score anomaly detection on closed windows
+type AnomalyConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+
+	// Alpha weighs the latest window in the moving mean and variance (0 < alpha <= 1)
+	Alpha float64 `mapstructure:"alpha"`
+
+	// Threshold is the absolute z-score above which a window is anomalous
+	Threshold float64 `mapstructure:"threshold"`
+
+	// Warmup is the number of windows observed before a series can be flagged
+	Warmup int `mapstructure:"warmup"`
+
+	// MinDeviation ignores deviations smaller than this absolute value
+	MinDeviation float64 `mapstructure:"min_deviation"`
+
+	// History is the number of anomalies kept for the API
+	History int `mapstructure:"history"`
+}
+
+// TransitionConfig controls page-to-page transition tracking within sessions
+type TransitionConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+
+	// Gap is the inactivity after which a session's last page is forgotten
+	Gap time.Duration `mapstructure:"gap"`
+}
+
+// RetentionConfig controls day-N/week-N cohort retention tracking. Users are
+// kept in memory until the longer of Days and 7*Weeks+6 days has passed since
+// their first day; a user who comes back after that starts a new cohort.
+type RetentionConfig struct {
+	Enabled bool `mapstructure:"enabled"`
+	Days    int  `mapstructure:"days"`  // last day N tracked
+	Weeks   int  `mapstructure:"weeks"` // last week N tracked
+
+	// Backfill rebuilds the cohorts of the last Backfill (e.g. 720h) from the
+	// Postgres events table on startup; 0 disables it
+	Backfill time.Duration `mapstructure:"backfill"`
+}
+
+// FunnelConfig defines an ordered sequence of steps a user or session must
+// complete within the conversion window
+type FunnelConfig struct {
+	Name   string             `mapstructure:"name"`
+	By     string             `mapstructure:"by"` // user (default) or session
+	Window time.Duration      `mapstructure:"window"`
+	Steps  []FunnelStepConfig `mapstructure:"steps"`
+}
+
+// FunnelStepConfig matches the events of one funnel step
+type FunnelStepConfig struct {
+	Name      string                `mapstructure:"name"`
+	EventType string                `mapstructure:"event_type"`
+	Where     []RuleConditionConfig `mapstructure:"where"`
+}
+
+// CardinalityConfig bounds the number of labeled series per metric family;
+// new label values beyond the limit are folded into an __other__ series
+type CardinalityConfig struct {
+	DefaultLimit int            `mapstructure:"default_limit"` // 0 disables the limit
+	Limits       map[string]int `mapstructure:"limits"`        // per-family overrides
+}
+
+// MetricRuleConfig defines a metric computed from matching events
+type MetricRuleConfig struct {
+	Name       string                `mapstructure:"name"`
+	EventType  string                `mapstructure:"event_type"`
+	Where      []RuleConditionConfig `mapstructure:"where"`
+	Property   string                `mapstructure:"property"`
+	Type       string                `mapstructure:"type"`
+	Dimensions []string              `mapstructure:"dimensions"`
+	Scope      string                `mapstructure:"scope"`
+}
+
+// RuleConditionConfig matches events whose property equals a value (or exists when equals is empty)
+type RuleConditionConfig struct {
+	Property string `mapstructure:"property"`
+	Equals   string `mapstructure:"equals"`
+}
+
+// SetConfig holds storage settings for unique-count (set) metrics
+type SetConfig struct {
+	// Mode is "exact", "hll" or "auto" (exact until ExactThreshold, then HyperLogLog)
+	Mode           string `mapstructure:"mode"`
+	Precision      uint8  `mapstructure:"precision"`
+	ExactThreshold int    `mapstructure:"exact_threshold"`
+}
+
+// AlertingConfig holds threshold alert rules evaluated on each closed window
+// and the notifiers receiving their firing/resolved transitions
+type AlertingConfig struct {
+	Rules     []AlertRuleConfig `mapstructure:"rules"`
+	Notifiers []NotifierConfig  `mapstructure:"notifiers"`
+
+	// Timeout bounds each notification delivery
+	Timeout time.Duration `mapstructure:"timeout"`
+
+	// QueueSize bounds the notifications waiting for delivery; beyond it they are dropped
+	QueueSize int `mapstructure:"queue_size"`
+}
+
+// AlertRuleConfig fires when the metric of a window spec (summed over the
+// series matching labels) compares to threshold for `for` consecutive windows
+type AlertRuleConfig struct {
+	Name        string            `mapstructure:"name"`
+	Spec        string            `mapstructure:"spec"` // e.g. tumbling_1m
+	Metric      string            `mapstructure:"metric"`
+	Labels      map[string]string `mapstructure:"labels"`
+	Op          string            `mapstructure:"op"` // <, <=, >, >=, ==, !=
+	Threshold   float64           `mapstructure:"threshold"`
+	For         int               `mapstructure:"for"`
+	Severity    string            `mapstructure:"severity"`
+	Description string            `mapstructure:"description"`
+}
+
+// NotifierConfig declares an alert notifier: log, file (path) or webhook (url)
+type NotifierConfig struct {
+	Type    string            `mapstructure:"type"`
+	Path    string            `mapstructure:"path"`
+	URL     string            `mapstructure:"url"`
+	Headers map[string]string `mapstructure:"headers"`
+}
+
 // LoggingConfig holds logging configuration
 type LoggingConfig struct {
 	Level  string `mapstructure:"level"`
@@ -133,18 +372,61 @@ func setDefaults() {
 	viper.SetDefault("processing.buffer_size", 1000)
 	viper.SetDefault("processing.batch_size", 100)
 	viper.SetDefault("processing.flush_interval", "5s")
+	viper.SetDefault("processing.dedup.enabled", false)
+	viper.SetDefault("processing.dedup.ttl", "10m")
+	viper.SetDefault("processing.dedup.capacity", 1000000)
 
 	//Storage defaults
+	viper.SetDefault("storage.postgres.enabled", false)
 	viper.SetDefault("storage.postgres.host", "localhost")
 	viper.SetDefault("storage.postgres.port", 5432)
 	viper.SetDefault("storage.postgres.database", "analytics")
 	viper.SetDefault("storage.postgres.user", "postgres")
 	viper.SetDefault("storage.postgres.max_connections", 25)
+	viper.SetDefault("storage.postgres.spec", "tumbling_1m")
+	viper.SetDefault("storage.postgres.rollups.enabled", true)
+	viper.SetDefault("storage.checkpoint.enabled", false)
+	viper.SetDefault("storage.checkpoint.backend", "file")
+	viper.SetDefault("storage.checkpoint.path", "data/checkpoint.json")
+	viper.SetDefault("storage.checkpoint.interval", "30s")
+	viper.SetDefault("storage.wal.enabled", false)
+	viper.SetDefault("storage.wal.dir", "data/wal")
+	viper.SetDefault("storage.wal.segment_size", 64<<20)
+	viper.SetDefault("storage.wal.sync", "always")
+	viper.SetDefault("storage.wal.sync_interval", "1s")
+	viper.SetDefault("storage.wal.commit_interval", "10s")
 
 	viper.SetDefault("storage.redis.address", "localhost:6379")
 	viper.SetDefault("storage.redis.db", 0)
 	viper.SetDefault("storage.redis.pool_size", 50)
 
+	//Window defaults
+	viper.SetDefault("window.allowed_lateness", "1m")
+
+	//Aggregation defaults
+	viper.SetDefault("aggregation.sets.mode", "exact")
+	viper.SetDefault("aggregation.sets.precision", 14)
+	viper.SetDefault("aggregation.sets.exact_threshold", 1000)
+	viper.SetDefault("aggregation.cardinality.default_limit", 1000)
+	viper.SetDefault("aggregation.topk_capacity", 100)
+	viper.SetDefault("aggregation.amount_property", "amount")
+	viper.SetDefault("aggregation.retention.enabled", false)
+	viper.SetDefault("aggregation.retention.days", 30)
+	viper.SetDefault("aggregation.retention.weeks", 12)
+	viper.SetDefault("aggregation.transitions.enabled", false)
+	viper.SetDefault("aggregation.transitions.gap", "30m")
+	viper.SetDefault("aggregation.anomalies.enabled", false)
+	viper.SetDefault("aggregation.anomalies.alpha", 0.3)
+	viper.SetDefault("aggregation.anomalies.threshold", 3.0)
+	viper.SetDefault("aggregation.anomalies.warmup", 10)
+	viper.SetDefault("aggregation.anomalies.history", 1000)
+	viper.SetDefault("aggregation.rolling.enabled", true)
+	viper.SetDefault("aggregation.rolling.ranges", []string{"1h", "24h"})
+
+	//Alerting defaults
+	viper.SetDefault("alerting.timeout", "5s")
+	viper.SetDefault("alerting.queue_size", 256)
+
 	//logging defaults
 	viper.SetDefault("logging.level", "info")
 	viper.SetDefault("logging.format", "json")
@@ -170,6 +452,14 @@ func (c *Config) validate() error {
 	if c.Processing.BufferSize < 100 {
 		return fmt.Errorf("buffer size must be at least 100")
 	}
+	if d := c.Processing.Dedup; d.Enabled {
+		if d.TTL <= 0 {
+			return fmt.Errorf("dedup ttl must be positive")
+		}
+		if d.Capacity <= 0 {
+			return fmt.Errorf("dedup capacity must be positive")
+		}
+	}
 
 	//validate storage config
 	if c.Storage.Postgres.Database == "" {
@@ -178,6 +468,190 @@ func (c *Config) validate() error {
 	if c.Storage.Postgres.User == "" {
 		return fmt.Errorf("postgres user is required")
 	}
+	if c.Storage.Postgres.Enabled && c.Storage.Postgres.Spec == "" {
+		return fmt.Errorf("postgres spec is required")
+	}
+	if c.Storage.Postgres.Rollups.Grace < 0 {
+		return fmt.Errorf("rollup grace must not be negative")
+	}
+	if cp := c.Storage.Checkpoint; cp.Enabled {
+		switch cp.Backend {
+		case "file":
+			if cp.Path == "" {
+				return fmt.Errorf("checkpoint path is required for the file backend")
+			}
+		case "postgres":
+			if !c.Storage.Postgres.Enabled {
+				return fmt.Errorf("checkpoint postgres backend requires storage.postgres.enabled")
+			}
+		default:
+			return fmt.Errorf("invalid checkpoint backend: %s", cp.Backend)
+		}
+		if cp.Interval <= 0 {
+			return fmt.Errorf("checkpoint interval must be positive")
+		}
+	}
+	if w := c.Storage.WAL; w.Enabled {
+		if w.Dir == "" {
+			return fmt.Errorf("wal dir is required")
+		}
+		if w.SegmentSize <= 0 {
+			return fmt.Errorf("wal segment size must be positive")
+		}
+		switch w.Sync {
+		case "always", "never":
+		case "interval":
+			if w.SyncInterval <= 0 {
+				return fmt.Errorf("wal sync interval must be positive")
+			}
+		default:
+			return fmt.Errorf("invalid wal sync policy: %s", w.Sync)
+		}
+		if w.CommitInterval <= 0 {
+			return fmt.Errorf("wal commit interval must be positive")
+		}
+	}
+
+	//validate window config
+	if c.Window.AllowedLateness < 0 {
+		return fmt.Errorf("allowed lateness must not be negative")
+	}
+	for i, w := range c.Window.Tumbling {
+		if w.Size <= 0 {
+			return fmt.Errorf("tumbling window %d: size must be positive", i)
+		}
+	}
+	for i, w := range c.Window.Sliding {
+		if w.Size <= 0 || w.Step <= 0 {
+			return fmt.Errorf("sliding window %d: size and step must be positive", i)
+		}
+		if w.Step > w.Size {
+			return fmt.Errorf("sliding window %d: step (%s) must not exceed size (%s)", i, w.Step, w.Size)
+		}
+	}
+	for i, w := range c.Window.Session {
+		if w.Gap <= 0 {
+			return fmt.Errorf("session window %d: gap must be positive", i)
+		}
+	}
+
+	//validate aggregation config
+	switch c.Aggregation.Sets.Mode {
+	case "exact", "hll", "auto":
+	default:
+		return fmt.Errorf("invalid set mode: %s", c.Aggregation.Sets.Mode)
+	}
+	if c.Aggregation.Sets.Precision < 4 || c.Aggregation.Sets.Precision > 18 {
+		return fmt.Errorf("set precision must be between 4 and 18, got %d", c.Aggregation.Sets.Precision)
+	}
+	if c.Aggregation.Sets.ExactThreshold < 0 {
+		return fmt.Errorf("set exact threshold must not be negative")
+	}
+	if c.Aggregation.TopKCapacity <= 0 {
+		return fmt.Errorf("topk capacity must be at least 1")
+	}
+	if c.Aggregation.Cardinality.DefaultLimit < 0 {
+		return fmt.Errorf("cardinality default limit must not be negative")
+	}
+	for family, limit := range c.Aggregation.Cardinality.Limits {
+		if limit < 0 {
+			return fmt.Errorf("cardinality limit for %s must not be negative", family)
+		}
+	}
+	// Metric rules are validated by aggregation.MetricRule.Validate once converted
+	if c.Aggregation.Retention.Days <= 0 || c.Aggregation.Retention.Weeks <= 0 {
+		return fmt.Errorf("retention days and weeks must be positive")
+	}
+	if c.Aggregation.Retention.Backfill < 0 {
+		return fmt.Errorf("retention backfill must not be negative")
+	}
+	if c.Aggregation.Transitions.Gap <= 0 {
+		return fmt.Errorf("transitions gap must be positive")
+	}
+	if a := c.Aggregation.Anomalies; a.Alpha <= 0 || a.Alpha > 1 {
+		return fmt.Errorf("anomalies alpha must be in (0, 1]")
+	}
+	if a := c.Aggregation.Anomalies; a.Threshold <= 0 || a.Warmup <= 0 || a.History <= 0 {
+		return fmt.Errorf("anomalies threshold, warmup and history must be positive")
+	}
+	if c.Aggregation.Anomalies.MinDeviation < 0 {
+		return fmt.Errorf("anomalies min_deviation must not be negative")
+	}
+	for _, rng := range c.Aggregation.Rolling.Ranges {
+		if rng <= 0 {
+			return fmt.Errorf("rolling ranges must be positive")
+		}
+	}
+	funnelNames := make(map[string]bool, len(c.Aggregation.Funnels))
+	for i, f := range c.Aggregation.Funnels {
+		if f.Name == "" {
+			return fmt.Errorf("funnel %d: name is required", i)
+		}
+		if funnelNames[f.Name] {
+			return fmt.Errorf("funnel %s: duplicate name", f.Name)
+		}
+		funnelNames[f.Name] = true
+		switch f.By {
+		case "", "user", "session":
+		default:
+			return fmt.Errorf("funnel %s: invalid key: %s", f.Name, f.By)
+		}
+		if f.Window <= 0 {
+			return fmt.Errorf("funnel %s: window must be positive", f.Name)
+		}
+		if len(f.Steps) < 2 {
+			return fmt.Errorf("funnel %s: at least two steps are required", f.Name)
+		}
+		for j, step := range f.Steps {
+			if step.EventType == "" {
+				return fmt.Errorf("funnel %s: step %d: event_type is required", f.Name, j+1)
+			}
+		}
+	}
+
+	//validate alerting config
+	if c.Alerting.Timeout <= 0 {
+		return fmt.Errorf("alerting timeout must be positive")
+	}
+	if c.Alerting.QueueSize <= 0 {
+		return fmt.Errorf("alerting queue size must be positive")
+	}
+	alertNames := make(map[string]bool, len(c.Alerting.Rules))
+	for i, r := range c.Alerting.Rules {
+		if r.Name == "" {
+			return fmt.Errorf("alert rule %d: name is required", i)
+		}
+		if alertNames[r.Name] {
+			return fmt.Errorf("alert rule %s: duplicate name", r.Name)
+		}
+		alertNames[r.Name] = true
+		if r.Spec == "" || r.Metric == "" {
+			return fmt.Errorf("alert rule %s: spec and metric are required", r.Name)
+		}
+		switch r.Op {
+		case "<", "<=", ">", ">=", "==", "!=":
+		default:
+			return fmt.Errorf("alert rule %s: invalid op: %s", r.Name, r.Op)
+		}
+		if r.For < 0 {
+			return fmt.Errorf("alert rule %s: for must not be negative", r.Name)
+		}
+	}
+	for i, n := range c.Alerting.Notifiers {
+		switch n.Type {
+		case "log":
+		case "file":
+			if n.Path == "" {
+				return fmt.Errorf("notifier %d: file notifier requires a path", i)
+			}
+		case "webhook":
+			if n.URL == "" {
+				return fmt.Errorf("notifier %d: webhook notifier requires a url", i)
+			}
+		default:
+			return fmt.Errorf("notifier %d: invalid type: %s", i, n.Type)
+		}
+	}
 
 	//validate logging config
 	validLevels := map[string]bool{
@@ -0,0 +1,132 @@
+// Package dedup retient les identifiants d'événements déjà acceptés pendant
+// une durée bornée, pour ignorer les renvois d'un même événement (retry d'un
+// SDK après un timeout).
+package dedup
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// Valeurs par défaut du store
+const (
+	DefaultTTL      = 10 * time.Minute
+	DefaultCapacity = 1000000
+)
+
+// Options configure le store
+type Options struct {
+	// TTL est la durée pendant laquelle un identifiant est retenu depuis son
+	// dernier envoi (0: DefaultTTL)
+	TTL time.Duration
+	// Capacity borne le nombre d'identifiants retenus: au-delà, les moins
+	// récemment vus sont oubliés avant leur TTL (0: DefaultCapacity)
+	Capacity int
+	// Now fournit l'heure courante (nil: time.Now)
+	Now func() time.Time
+}
+
+// entry est un identifiant retenu
+type entry struct {
+	id     string
+	seenAt time.Time
+}
+
+// Store est un LRU d'identifiants avec TTL: la liste est ordonnée du moins
+// au plus récemment vu, donc les identifiants expirés sont en tête
+type Store struct {
+	ttl        time.Duration
+	capacity   int
+	now        func() time.Time
+	entries    map[string]*list.Element
+	order      *list.List
+	duplicates uint64
+	mu         sync.Mutex
+}
+
+// New crée un store vide
+func New(opts Options) *Store {
+	if opts.TTL <= 0 {
+		opts.TTL = DefaultTTL
+	}
+	if opts.Capacity <= 0 {
+		opts.Capacity = DefaultCapacity
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &Store{
+		ttl:      opts.TTL,
+		capacity: opts.Capacity,
+		now:      opts.Now,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// Seen indique si id a été vu depuis moins de TTL (un doublon, compté) et le
+// retient sinon. Un doublon prolonge la rétention de l'identifiant.
+func (s *Store) Seen(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := s.now()
+	s.expireLocked(now)
+
+	if element, ok := s.entries[id]; ok {
+		element.Value.(*entry).seenAt = now
+		s.order.MoveToBack(element)
+		s.duplicates++
+		return true
+	}
+
+	s.entries[id] = s.order.PushBack(&entry{id: id, seenAt: now})
+	for s.order.Len() > s.capacity {
+		s.removeLocked(s.order.Front())
+	}
+	return false
+}
+
+// Forget oublie id, pour qu'un événement finalement refusé (file pleine)
+// soit accepté à son prochain envoi
+func (s *Store) Forget(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if element, ok := s.entries[id]; ok {
+		s.removeLocked(element)
+	}
+}
+
+// expireLocked retire les identifiants vus il y a plus de TTL (s.mu tenu)
+func (s *Store) expireLocked(now time.Time) {
+	for element := s.order.Front(); element != nil; element = s.order.Front() {
+		if now.Sub(element.Value.(*entry).seenAt) < s.ttl {
+			return
+		}
+		s.removeLocked(element)
+	}
+}
+
+// removeLocked retire un identifiant (s.mu tenu)
+func (s *Store) removeLocked(element *list.Element) {
+	s.order.Remove(element)
+	delete(s.entries, element.Value.(*entry).id)
+}
+
+// Len retourne le nombre d'identifiants retenus
+func (s *Store) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expireLocked(s.now())
+	return s.order.Len()
+}
+
+// Duplicates retourne le nombre de doublons détectés
+func (s *Store) Duplicates() uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.duplicates
+}
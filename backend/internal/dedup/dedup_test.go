@@ -0,0 +1,43 @@
+package dedup
+
+import (
+	"testing"
+	"time"
+)
+
+// TestStoreTTLAndCapacity teste la détection des doublons, leur expiration
+// après le TTL, l'éviction du moins récemment vu et l'oubli d'un identifiant
+func TestStoreTTLAndCapacity(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := New(Options{TTL: time.Minute, Capacity: 3, Now: func() time.Time { return now }})
+
+	if store.Seen("a") || store.Seen("b") {
+		t.Fatal("Expected first sends not to be duplicates")
+	}
+	if !store.Seen("a") {
+		t.Fatal("Expected a retry to be a duplicate")
+	}
+
+	// Le doublon a prolongé "a": seul "b" expire
+	now = now.Add(30 * time.Second)
+	store.Seen("a")
+	now = now.Add(40 * time.Second)
+	if store.Len() != 1 || store.Seen("b") {
+		t.Errorf("Expected b to expire and a to be kept, got %d ids", store.Len())
+	}
+
+	// Capacité de 3: "a", le moins récemment vu, est évincé par "d"
+	store.Seen("c")
+	store.Seen("d")
+	if store.Seen("a") {
+		t.Error("Expected a to be evicted beyond the capacity")
+	}
+
+	store.Forget("d")
+	if store.Seen("d") {
+		t.Error("Expected a forgotten id to be accepted again")
+	}
+	if store.Duplicates() != 2 {
+		t.Errorf("Expected 2 duplicates, got %d", store.Duplicates())
+	}
+}
@@ -2,11 +2,16 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/Rassimdou/Real-time-Analytics/internal/aggregation"
+	"github.com/Rassimdou/Real-time-Analytics/internal/dedup"
+	"github.com/Rassimdou/Real-time-Analytics/internal/wal"
 	"github.com/gin-gonic/gin"
 	"go.uber.org/zap"
 )
@@ -18,6 +23,9 @@ type Server struct {
 	logger     *zap.Logger
 	eventQueue chan Event
 	aggregator *aggregation.Aggregator
+	alerts     *aggregation.Alerts
+	wal        *wal.WAL
+	dedup      *dedup.Store
 }
 
 // Event represents an analytics event
@@ -28,6 +36,8 @@ type Event struct {
 	UserID     string                 `json:"user_id"`
 	SessionID  string                 `json:"session_id"`
 	Properties map[string]interface{} `json:"properties"`
+	// Seq is the write-ahead log sequence of the event (0 without a log)
+	Seq uint64 `json:"-"`
 }
 
 type ErrorResponse struct {
@@ -66,6 +76,68 @@ func NewServer(addr string, logger *zap.Logger, eventQueue chan Event, aggregato
 	return s
 }
 
+// SetAlerts exposes the states of the alert rules evaluated on closed windows
+// (nil: alerting is not configured)
+func (s *Server) SetAlerts(alerts *aggregation.Alerts) {
+	s.alerts = alerts
+}
+
+// SetWAL appends accepted events to a write-ahead log before they are
+// acknowledged (nil: events are only buffered in memory)
+func (s *Server) SetWAL(log *wal.WAL) {
+	s.wal = log
+}
+
+// SetDedup drops events whose client-supplied ID was already accepted
+// recently (nil: every event is queued)
+func (s *Server) SetDedup(store *dedup.Store) {
+	s.dedup = store
+}
+
+// isDuplicate reports whether an event with a client-supplied ID was already
+// accepted, and remembers the ID otherwise
+func (s *Server) isDuplicate(event *Event) bool {
+	return s.dedup != nil && event.ID != "" && s.dedup.Seen(event.ID)
+}
+
+// forgetEvent lets a rejected event be accepted when it is sent again
+func (s *Server) forgetEvent(event Event) {
+	if s.dedup != nil {
+		s.dedup.Forget(event.ID)
+	}
+}
+
+// logEvents appends events to the write-ahead log and sets their sequences
+func (s *Server) logEvents(events []*Event) error {
+	if s.wal == nil || len(events) == 0 {
+		return nil
+	}
+	payloads := make([][]byte, len(events))
+	for i, event := range events {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+		payloads[i] = payload
+	}
+	seqs, err := s.wal.AppendBatch(payloads)
+	if err != nil {
+		return err
+	}
+	for i, event := range events {
+		event.Seq = seqs[i]
+	}
+	return nil
+}
+
+// discardEvent marks a logged event that could not be queued as handled, so
+// that it is not replayed
+func (s *Server) discardEvent(event Event) {
+	if s.wal != nil && event.Seq != 0 {
+		s.wal.Done(event.Seq)
+	}
+}
+
 // setup middleware
 func (s *Server) setupMiddleware() {
 	//Recover middleware (panic recovery)
@@ -100,6 +172,24 @@ func (s *Server) setupRoutes() {
 		v1.GET("/metrics", s.handleGetAllMetrics)
 		v1.GET("/metrics/:name", s.handleGetMetricByName)
 		v1.GET("/stats", s.handleGetStats)
+
+		//Top-K heavy hitters
+		v1.GET("/topk/:name", s.handleGetTopK)
+
+		//Conversion funnels
+		v1.GET("/funnels", s.handleGetFunnels)
+
+		//Cohort retention
+		v1.GET("/retention", s.handleGetRetention)
+
+		//Page transitions (navigation paths)
+		v1.GET("/transitions", s.handleGetTransitions)
+
+		//Anomalies detected on closed windows
+		v1.GET("/anomalies", s.handleGetAnomalies)
+
+		//Threshold alert states
+		v1.GET("/alerts", s.handleGetAlerts)
 	}
 }
 
@@ -164,10 +254,35 @@ func (s *Server) handleEvent(c *gin.Context) {
 		event.Timestamp = time.Now().UTC()
 	}
 
+	// A retry of an accepted event is acknowledged without being counted again
+	if s.isDuplicate(&event) {
+		s.logger.Debug("duplicate event ignored", zap.String("event_id", event.ID))
+		c.JSON(http.StatusOK, SuccessResponse{
+			Status:  "duplicate",
+			Message: "event already accepted",
+			Data: gin.H{
+				"event_id": event.ID,
+				"type":     event.Type,
+			},
+		})
+		return
+	}
+
 	if event.ID == "" {
 		event.ID = fmt.Sprintf("evt_%d", time.Now().UnixNano())
 	}
 
+	// Persist before acknowledging
+	if err := s.logEvents([]*Event{&event}); err != nil {
+		s.forgetEvent(event)
+		s.logger.Error("failed to append event to the write-ahead log", zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   true,
+			Message: "failed to persist event, try again later",
+		})
+		return
+	}
+
 	// Try to send to queue (non-blocking)
 	select {
 	case s.eventQueue <- event:
@@ -187,6 +302,8 @@ func (s *Server) handleEvent(c *gin.Context) {
 		})
 	default:
 		// Queue is full
+		s.discardEvent(event)
+		s.forgetEvent(event)
 		s.logger.Warn("event queue full, rejecting event",
 			zap.String("event_type", event.Type),
 		)
@@ -230,9 +347,11 @@ func (s *Server) handleBatchEvents(c *gin.Context) {
 
 	accepted := 0
 	rejected := 0
+	duplicates := make([]string, 0)
 	now := time.Now().UTC()
 
-	//Queue all events
+	//Prepare all events
+	valid := make([]*Event, 0, len(events))
 	for i := range events {
 		event := &events[i]
 
@@ -241,24 +360,49 @@ func (s *Server) handleBatchEvents(c *gin.Context) {
 			event.Timestamp = now
 		}
 
+		//Validate event type
+		if event.Type == "" {
+			rejected++
+			continue
+		}
+
+		//Skip retries of accepted events (and repeated IDs within the batch)
+		if s.isDuplicate(event) {
+			duplicates = append(duplicates, event.ID)
+			continue
+		}
+
 		//Generate ID if missing
 		if event.ID == "" {
 			event.ID = fmt.Sprintf("evt_%d_%d", time.Now().UnixNano(), i)
 
 		}
+		valid = append(valid, event)
+	}
 
-		//Validate event type
-		if event.Type == "" {
-			rejected++
-			continue
+	//Persist the whole batch before acknowledging (one fsync)
+	if err := s.logEvents(valid); err != nil {
+		for _, event := range valid {
+			s.forgetEvent(*event)
 		}
+		s.logger.Error("failed to append batch to the write-ahead log", zap.Error(err))
+		c.JSON(http.StatusServiceUnavailable, ErrorResponse{
+			Error:   true,
+			Message: "failed to persist events, try again later",
+		})
+		return
+	}
 
+	//Queue all events
+	for _, event := range valid {
 		//Try to send to queue (non-blocking)
 		select {
 		case s.eventQueue <- *event:
 			accepted++
 		default:
 			//full queue
+			s.discardEvent(*event)
+			s.forgetEvent(*event)
 			rejected++
 		}
 	}
@@ -267,15 +411,18 @@ func (s *Server) handleBatchEvents(c *gin.Context) {
 		zap.Int("total", len(events)),
 		zap.Int("accepted", accepted),
 		zap.Int("rejected", rejected),
+		zap.Int("duplicates", len(duplicates)),
 	)
 
 	c.JSON(http.StatusAccepted, SuccessResponse{
 		Status:  "accepted",
 		Message: fmt.Sprintf("batch processed: %d events", len(events)),
 		Data: gin.H{
-			"total":    len(events),
-			"accepted": accepted,
-			"rejected": rejected,
+			"total":         len(events),
+			"accepted":      accepted,
+			"rejected":      rejected,
+			"duplicates":    len(duplicates),
+			"duplicate_ids": duplicates,
 		},
 	})
 }
@@ -291,32 +438,111 @@ func (s *Server) handleGetAllMetrics(c *gin.Context) {
 		return
 	}
 
-	// Récupérer toutes les métriques depuis l'aggregator
-	metrics := s.aggregator.GetGlobalMetrics()
+	source, ok := s.metricsRange(c)
+	if !ok {
+		return
+	}
+
+	// Totaux depuis le démarrage, ou leur agrégat glissant
+	var metrics map[string]*aggregation.Metric
+	if source.rolling != nil {
+		metrics = source.rolling.Metrics.GetAllMetrics()
+	} else {
+		metrics = s.aggregator.GetGlobalMetrics()
+	}
 
 	if len(metrics) == 0 {
 		c.JSON(http.StatusOK, SuccessResponse{
 			Status:  "success",
 			Message: "no metrics yet",
-			Data: gin.H{
-				"metrics": []string{},
-			},
+			Data:    source.describe(gin.H{"metrics": gin.H{}}),
 		})
 		return
 	}
 
 	s.logger.Debug("returning all metrics",
+		zap.String("range", source.label),
 		zap.Int("count", len(metrics)),
 	)
 
 	c.JSON(http.StatusOK, SuccessResponse{
 		Status:  "success",
-		Message: fmt.Sprintf("retrieved %d metrics", len(metrics)),
-		Data:    metrics,
+		Message: fmt.Sprintf("retrieved %d %s metrics", len(metrics), source.label),
+		Data:    source.describe(gin.H{"metrics": metrics}),
 	})
 }
 
-// handleGetMetricByName retourne une métrique spécifique
+// metricsSource is what the metrics endpoints read: the lifetime totals
+// (since the first start, restored from checkpoints) or a rolling range
+type metricsSource struct {
+	label   string
+	rolling *aggregation.RollingMetrics // nil for the lifetime totals
+}
+
+// describe labels a response with the range it covers
+func (m metricsSource) describe(data gin.H) gin.H {
+	data["range"] = m.label
+	if m.rolling != nil {
+		data["from"] = m.rolling.From
+		data["to"] = m.rolling.To
+		data["windows"] = m.rolling.Windows
+	}
+	return data
+}
+
+// lifetimeRange labels the totals accumulated since the aggregator started
+const lifetimeRange = "lifetime"
+
+// metricsRange reads ?range= (lifetime by default, or a rolling range such
+// as 1h or 24h) and writes the error response when it cannot be served
+func (s *Server) metricsRange(c *gin.Context) (metricsSource, bool) {
+	raw := c.DefaultQuery("range", lifetimeRange)
+	if raw == lifetimeRange {
+		return metricsSource{label: lifetimeRange}, true
+	}
+
+	ranges := s.aggregator.RollingRanges()
+	if ranges == nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   true,
+			Message: "rolling metrics are disabled",
+		})
+		return metricsSource{}, false
+	}
+
+	rng, err := time.ParseDuration(raw)
+	var rolling *aggregation.RollingMetrics
+	ok := err == nil
+	if ok {
+		rolling, ok = s.aggregator.RollingMetrics(rng)
+	}
+	if !ok {
+		available := []string{lifetimeRange}
+		for _, served := range ranges {
+			available = append(available, formatRange(served))
+		}
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   true,
+			Message: fmt.Sprintf("unsupported range '%s' (available: %s)", raw, strings.Join(available, ", ")),
+		})
+		return metricsSource{}, false
+	}
+	return metricsSource{label: formatRange(rng), rolling: rolling}, true
+}
+
+// formatRange formats a rolling range as it is accepted by ?range= (1h, 24h, 90m)
+func formatRange(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dm", d/time.Minute)
+	}
+	return d.String()
+}
+
+// handleGetMetricByName retourne les séries d'une métrique.
+// Filtres: ?label=page=/home (répétable); regroupement: ?group_by=page[,type]
 func (s *Server) handleGetMetricByName(c *gin.Context) {
 	if s.aggregator == nil {
 		c.JSON(http.StatusInternalServerError, ErrorResponse{
@@ -328,12 +554,28 @@ func (s *Server) handleGetMetricByName(c *gin.Context) {
 
 	metricName := c.Param("name")
 
-	// Récupérer toutes les métriques
-	allMetrics := s.aggregator.GetGlobalMetrics()
+	match, err := parseLabelFilters(c.QueryArray("label"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   true,
+			Message: err.Error(),
+		})
+		return
+	}
 
-	// Chercher la métrique demandée
-	metric, exists := allMetrics[metricName]
-	if !exists {
+	source, ok := s.metricsRange(c)
+	if !ok {
+		return
+	}
+
+	// Séries de la métrique demandée
+	var series []*aggregation.Metric
+	if source.rolling != nil {
+		series = source.rolling.Metrics.Query(metricName, match)
+	} else {
+		series = s.aggregator.QueryGlobalMetrics(metricName, match)
+	}
+	if len(series) == 0 {
 		c.JSON(http.StatusNotFound, ErrorResponse{
 			Error:   true,
 			Message: fmt.Sprintf("metric '%s' not found", metricName),
@@ -343,23 +585,475 @@ func (s *Server) handleGetMetricByName(c *gin.Context) {
 
 	s.logger.Debug("returning metric",
 		zap.String("metric_name", metricName),
-		zap.Float64("value", metric.Value),
-		zap.Int64("count", metric.Count),
+		zap.Int("series", len(series)),
 	)
 
+	// Regroupement par labels
+	if groupBy := c.Query("group_by"); groupBy != "" {
+		by := strings.Split(groupBy, ",")
+		var groups []aggregation.MetricGroup
+		if source.rolling != nil {
+			groups = source.rolling.Metrics.GroupBy(metricName, by, match)
+		} else {
+			groups = s.aggregator.GroupGlobalMetrics(metricName, by, match)
+		}
+		c.JSON(http.StatusOK, SuccessResponse{
+			Status:  "success",
+			Message: fmt.Sprintf("metric '%s' grouped by %s", metricName, groupBy),
+			Data: source.describe(gin.H{
+				"name":     metricName,
+				"group_by": by,
+				"groups":   groups,
+			}),
+		})
+		return
+	}
+
+	data := make([]gin.H, 0, len(series))
+	for _, metric := range series {
+		summary := metric.Summary()
+		entry := gin.H{
+			"labels":    metric.Labels,
+			"type":      metric.Type,
+			"value":     summary.Value,
+			"count":     summary.Count,
+			"timestamp": metric.Timestamp,
+		}
+
+		// min/max pour les histogrammes et les gauges, moyenne des gauges
+		if summary.Min != nil {
+			entry["min"] = *summary.Min
+			entry["max"] = *summary.Max
+		}
+		if summary.Avg != nil {
+			entry["avg"] = *summary.Avg
+		}
+
+		// Distribution pour les histogrammes (p50/p90/p95/p99)
+		if summary.Percentiles != nil {
+			entry["percentiles"] = summary.Percentiles
+		}
+
+		// Valeurs les plus fréquentes pour les topk
+		if summary.Top != nil {
+			entry["top"] = summary.Top
+		}
+		data = append(data, entry)
+	}
+
 	c.JSON(http.StatusOK, SuccessResponse{
 		Status:  "success",
 		Message: fmt.Sprintf("metric '%s' found", metricName),
-		Data: gin.H{
-			"name":      metric.Name,
-			"type":      metric.Type,
-			"value":     metric.Value,
-			"count":     metric.Count,
-			"timestamp": metric.Timestamp,
-		},
+		Data: source.describe(gin.H{
+			"name":   metricName,
+			"series": data,
+		}),
+	})
+}
+
+// defaultTopK est le nombre de valeurs retournées sans paramètre k
+const defaultTopK = 10
+
+// handleGetTopK retourne les valeurs les plus fréquentes d'une métrique topk,
+// globalement ou dans la dernière fenêtre d'une spec (?window=tumbling_1m).
+// Le compte réel de chaque valeur est compris entre count-error et count.
+func (s *Server) handleGetTopK(c *gin.Context) {
+	if s.aggregator == nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   true,
+			Message: "aggregator not initialized",
+		})
+		return
+	}
+
+	metricName := c.Param("name")
+
+	k := defaultTopK
+	if raw := c.Query("k"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   true,
+				Message: fmt.Sprintf("invalid k '%s', expected a positive integer", raw),
+			})
+			return
+		}
+		k = parsed
+	}
+
+	match, err := parseLabelFilters(c.QueryArray("label"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   true,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	data := gin.H{
+		"name": metricName,
+		"k":    k,
+	}
+
+	var top []aggregation.TopKEntry
+	if spec := c.Query("window"); spec != "" {
+		window, entries := s.aggregator.WindowTopK(spec, metricName, match, k)
+		if window == nil {
+			c.JSON(http.StatusNotFound, ErrorResponse{
+				Error:   true,
+				Message: fmt.Sprintf("no window for spec '%s'", spec),
+			})
+			return
+		}
+		top = entries
+		data["window"] = gin.H{
+			"spec":       window.Spec,
+			"start_time": window.StartTime,
+			"end_time":   window.EndTime,
+			"closed":     window.Closed,
+		}
+	} else {
+		top = s.aggregator.GlobalTopK(metricName, match, k)
+	}
+
+	if top == nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   true,
+			Message: fmt.Sprintf("top-k metric '%s' not found", metricName),
+		})
+		return
+	}
+	data["top"] = top
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Status:  "success",
+		Message: fmt.Sprintf("top %d of '%s'", len(top), metricName),
+		Data:    data,
+	})
+}
+
+// handleGetFunnels retourne les comptes par étape, les conversions et les
+// délais médians des funnels, globaux ou de la dernière fenêtre d'une spec
+// (?window=tumbling_5m). ?name= limite la réponse à un funnel.
+func (s *Server) handleGetFunnels(c *gin.Context) {
+	if s.aggregator == nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   true,
+			Message: "aggregator not initialized",
+		})
+		return
+	}
+
+	data := gin.H{}
+
+	var funnels []aggregation.FunnelResult
+	if spec := c.Query("window"); spec != "" {
+		window, results := s.aggregator.WindowFunnels(spec)
+		if window == nil {
+			c.JSON(http.StatusNotFound, ErrorResponse{
+				Error:   true,
+				Message: fmt.Sprintf("no window for spec '%s'", spec),
+			})
+			return
+		}
+		funnels = results
+		data["window"] = gin.H{
+			"spec":       window.Spec,
+			"start_time": window.StartTime,
+			"end_time":   window.EndTime,
+			"closed":     window.Closed,
+		}
+	} else {
+		funnels = s.aggregator.GlobalFunnels()
+	}
+
+	if name := c.Query("name"); name != "" {
+		filtered := make([]aggregation.FunnelResult, 0, 1)
+		for _, funnel := range funnels {
+			if funnel.Name == name {
+				filtered = append(filtered, funnel)
+			}
+		}
+		if len(filtered) == 0 {
+			c.JSON(http.StatusNotFound, ErrorResponse{
+				Error:   true,
+				Message: fmt.Sprintf("funnel '%s' not found", name),
+			})
+			return
+		}
+		funnels = filtered
+	}
+	data["funnels"] = funnels
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Status:  "success",
+		Message: fmt.Sprintf("%d funnels", len(funnels)),
+		Data:    data,
 	})
 }
 
+// handleGetRetention retourne la matrice de rétention par cohorte
+// (?period=day|week, day par défaut), éventuellement limitée aux cohortes
+// commençant dans [from, to) (dates RFC 3339 ou YYYY-MM-DD)
+func (s *Server) handleGetRetention(c *gin.Context) {
+	if s.aggregator == nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   true,
+			Message: "aggregator not initialized",
+		})
+		return
+	}
+
+	retention := s.aggregator.Retention()
+	if retention == nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   true,
+			Message: "retention tracking is disabled",
+		})
+		return
+	}
+
+	var bounds [2]time.Time
+	for i, param := range []string{"from", "to"} {
+		raw := c.Query(param)
+		if raw == "" {
+			continue
+		}
+		parsed, err := parseRetentionDate(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   true,
+				Message: fmt.Sprintf("invalid %s '%s', expected RFC 3339 or YYYY-MM-DD", param, raw),
+			})
+			return
+		}
+		bounds[i] = parsed
+	}
+
+	period := aggregation.RetentionPeriod(c.DefaultQuery("period", string(aggregation.RetentionDay)))
+	matrix, err := retention.Matrix(period, bounds[0], bounds[1])
+	if err != nil {
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   true,
+			Message: err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Status:  "success",
+		Message: fmt.Sprintf("%d %s cohorts", len(matrix.Cohorts), matrix.Period),
+		Data:    matrix,
+	})
+}
+
+// defaultTransitionLinks est le nombre de transitions retournées sans paramètre k
+const defaultTransitionLinks = 100
+
+// handleGetTransitions retourne les transitions entre pages des sessions,
+// globales ou de la dernière fenêtre d'une spec (?window=tumbling_5m).
+// Sans ?page=, retourne les k transitions les plus fréquentes (liens d'un
+// diagramme de Sankey); avec ?page=/pricing, les k pages suivantes et
+// précédentes de cette page.
+func (s *Server) handleGetTransitions(c *gin.Context) {
+	if s.aggregator == nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   true,
+			Message: "aggregator not initialized",
+		})
+		return
+	}
+	if !s.aggregator.TransitionsEnabled() {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   true,
+			Message: "page transition tracking is disabled",
+		})
+		return
+	}
+
+	page := c.Query("page")
+	k := defaultTransitionLinks
+	if page != "" {
+		k = defaultTopK
+	}
+	if raw := c.Query("k"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   true,
+				Message: fmt.Sprintf("invalid k '%s', expected a positive integer", raw),
+			})
+			return
+		}
+		k = parsed
+	}
+
+	data := gin.H{"k": k}
+	spec := c.Query("window")
+	query := func(match aggregation.Labels) ([]aggregation.PageTransition, bool) {
+		if spec == "" {
+			return s.aggregator.GlobalTransitions(match), true
+		}
+		window, transitions := s.aggregator.WindowTransitions(spec, match)
+		if window == nil {
+			return nil, false
+		}
+		data["window"] = gin.H{
+			"spec":       window.Spec,
+			"start_time": window.StartTime,
+			"end_time":   window.EndTime,
+			"closed":     window.Closed,
+		}
+		return transitions, true
+	}
+	limit := func(transitions []aggregation.PageTransition) []aggregation.PageTransition {
+		if len(transitions) > k {
+			return transitions[:k]
+		}
+		return transitions
+	}
+
+	var found bool
+	if page == "" {
+		var links []aggregation.PageTransition
+		links, found = query(nil)
+		data["links"] = limit(links)
+	} else {
+		var next, previous []aggregation.PageTransition
+		next, found = query(aggregation.Labels{"from": page})
+		previous, _ = query(aggregation.Labels{"to": page})
+		data["page"] = page
+		data["next"] = limit(next)
+		data["previous"] = limit(previous)
+	}
+	if !found {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   true,
+			Message: fmt.Sprintf("no window for spec '%s'", spec),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, SuccessResponse{
+		Status:  "success",
+		Message: "page transitions",
+		Data:    data,
+	})
+}
+
+// defaultAnomalyLimit est le nombre d'anomalies retournées sans paramètre limit
+const defaultAnomalyLimit = 100
+
+// handleGetAnomalies retourne les anomalies détectées sur les fenêtres
+// fermées, les plus récentes en premier, filtrées par ?spec=, ?series=
+// (clé exacte, ex. events{type="pageview"}) et ?since= (début de fenêtre,
+// RFC 3339 ou YYYY-MM-DD)
+func (s *Server) handleGetAnomalies(c *gin.Context) {
+	if s.aggregator == nil {
+		c.JSON(http.StatusInternalServerError, ErrorResponse{
+			Error:   true,
+			Message: "aggregator not initialized",
+		})
+		return
+	}
+	if !s.aggregator.AnomaliesEnabled() {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   true,
+			Message: "anomaly detection is disabled",
+		})
+		return
+	}
+
+	filter := aggregation.AnomalyFilter{
+		Spec:   c.Query("spec"),
+		Series: c.Query("series"),
+		Limit:  defaultAnomalyLimit,
+	}
+	if raw := c.Query("since"); raw != "" {
+		since, err := parseRetentionDate(raw)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   true,
+				Message: fmt.Sprintf("invalid since '%s', expected RFC 3339 or YYYY-MM-DD", raw),
+			})
+			return
+		}
+		filter.Since = since
+	}
+	if raw := c.Query("limit"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, ErrorResponse{
+				Error:   true,
+				Message: fmt.Sprintf("invalid limit '%s', expected a positive integer", raw),
+			})
+			return
+		}
+		filter.Limit = parsed
+	}
+
+	anomalies := s.aggregator.Anomalies(filter)
+	c.JSON(http.StatusOK, SuccessResponse{
+		Status:  "success",
+		Message: fmt.Sprintf("%d anomalies", len(anomalies)),
+		Data:    anomalies,
+	})
+}
+
+// handleGetAlerts retourne l'état de chaque règle d'alerte, éventuellement
+// filtré par ?state=inactive|pending|firing|resolved
+func (s *Server) handleGetAlerts(c *gin.Context) {
+	if s.alerts == nil {
+		c.JSON(http.StatusNotFound, ErrorResponse{
+			Error:   true,
+			Message: "no alert rules configured",
+		})
+		return
+	}
+
+	state := aggregation.AlertState(c.Query("state"))
+	switch state {
+	case "", aggregation.AlertInactive, aggregation.AlertPending, aggregation.AlertFiring, aggregation.AlertResolved:
+	default:
+		c.JSON(http.StatusBadRequest, ErrorResponse{
+			Error:   true,
+			Message: fmt.Sprintf("invalid state '%s', expected inactive, pending, firing or resolved", state),
+		})
+		return
+	}
+
+	statuses := s.alerts.Statuses(state)
+	c.JSON(http.StatusOK, SuccessResponse{
+		Status:  "success",
+		Message: fmt.Sprintf("%d alerts", len(statuses)),
+		Data:    statuses,
+	})
+}
+
+// parseRetentionDate accepte une date RFC 3339 ou un jour YYYY-MM-DD (UTC)
+func parseRetentionDate(raw string) (time.Time, error) {
+	if day, err := time.Parse("2006-01-02", raw); err == nil {
+		return day, nil
+	}
+	return time.Parse(time.RFC3339, raw)
+}
+
+// parseLabelFilters convertit des filtres "label=valeur" en ensemble de labels
+func parseLabelFilters(filters []string) (aggregation.Labels, error) {
+	if len(filters) == 0 {
+		return nil, nil
+	}
+	labels := make(aggregation.Labels, len(filters))
+	for _, filter := range filters {
+		name, value, ok := strings.Cut(filter, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid label filter '%s', expected label=value", filter)
+		}
+		labels[name] = value
+	}
+	return labels, nil
+}
+
 // handleGetStats retourne les statistiques de l'aggregator
 func (s *Server) handleGetStats(c *gin.Context) {
 	if s.aggregator == nil {
@@ -372,6 +1066,13 @@ func (s *Server) handleGetStats(c *gin.Context) {
 
 	// Récupérer les stats
 	stats := s.aggregator.GetStats()
+	if s.dedup != nil {
+		stats["duplicate_events"] = s.dedup.Duplicates()
+		stats["dedup_ids"] = s.dedup.Len()
+	}
+	if s.alerts != nil {
+		stats["dropped_notifications"] = s.alerts.Dropped()
+	}
 
 	s.logger.Info("returning aggregator stats",
 		zap.Any("stats", stats),
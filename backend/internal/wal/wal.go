@@ -0,0 +1,563 @@
+// Package wal est le journal d'écriture anticipée (write-ahead log) des
+// événements acceptés: chaque événement y est ajouté avant l'accusé de
+// réception, rejoué au démarrage s'il n'a pas été traité, et les segments
+// entièrement traités sont supprimés.
+package wal
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncPolicy choisit quand les ajouts sont écrits sur disque (fsync)
+type SyncPolicy string
+
+const (
+	SyncAlways   SyncPolicy = "always"   // fsync avant chaque accusé de réception
+	SyncInterval SyncPolicy = "interval" // fsync périodique (perte possible de SyncInterval)
+	SyncNever    SyncPolicy = "never"    // laissé au système (perte possible au crash de la machine)
+)
+
+// Valeurs par défaut du journal
+const (
+	DefaultSegmentSize  = 64 << 20
+	DefaultSyncInterval = time.Second
+)
+
+const (
+	segmentExt   = ".wal"
+	positionFile = "position.json"
+	headerSize   = 16       // longueur (4), crc32 (4), séquence (8)
+	maxRecord    = 16 << 20 // borne la taille lue d'un enregistrement corrompu
+)
+
+var crcTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Options configure le journal
+type Options struct {
+	Dir string
+	// SegmentSize est la taille au-delà de laquelle un nouveau segment est
+	// ouvert (0: DefaultSegmentSize)
+	SegmentSize int64
+	// Sync est la politique de fsync (vide: SyncAlways)
+	Sync SyncPolicy
+	// SyncInterval est la période de fsync de SyncInterval (0: DefaultSyncInterval)
+	SyncInterval time.Duration
+}
+
+// Position décrit les enregistrements déjà traités: toutes les séquences
+// inférieures à Next, plus celles de Done (traitées dans le désordre)
+type Position struct {
+	Next uint64   `json:"next"`
+	Done []uint64 `json:"done,omitempty"`
+}
+
+// covers indique si la séquence seq est traitée
+func (p Position) covers(seq uint64) bool {
+	if seq < p.Next {
+		return true
+	}
+	for _, done := range p.Done {
+		if done == seq {
+			return true
+		}
+	}
+	return false
+}
+
+// segment est un fichier du journal, nommé par sa première séquence
+type segment struct {
+	first uint64
+	path  string
+}
+
+// WAL est un journal segmenté. Chaque enregistrement porte une séquence
+// croissante et un CRC: une fin de segment tronquée par un crash est ignorée
+// et coupée à l'ouverture.
+type WAL struct {
+	opts      Options
+	segments  []segment
+	file      *os.File
+	size      int64
+	nextSeq   uint64
+	dirty     bool
+	committed Position
+	mu        sync.Mutex
+
+	// Suivi des séquences traitées (voir Process et Snapshot)
+	processed uint64              // toutes les séquences < processed sont traitées
+	done      map[uint64]struct{} // séquences >= processed traitées
+	trackMu   sync.Mutex
+	gate      sync.RWMutex
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open ouvre (ou crée) le journal du répertoire opts.Dir
+func Open(opts Options) (*WAL, error) {
+	if opts.Dir == "" {
+		return nil, fmt.Errorf("wal directory is required")
+	}
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = DefaultSegmentSize
+	}
+	if opts.Sync == "" {
+		opts.Sync = SyncAlways
+	}
+	switch opts.Sync {
+	case SyncAlways, SyncInterval, SyncNever:
+	default:
+		return nil, fmt.Errorf("invalid wal sync policy: %s", opts.Sync)
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = DefaultSyncInterval
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create wal directory: %w", err)
+	}
+
+	w := &WAL{opts: opts, done: make(map[uint64]struct{}), stop: make(chan struct{})}
+	if err := w.loadPosition(); err != nil {
+		return nil, err
+	}
+	if err := w.loadSegments(); err != nil {
+		return nil, err
+	}
+
+	// Reprendre après le dernier enregistrement valide, ou après la position
+	// enregistrée si tous les segments ont été supprimés
+	w.nextSeq = w.committed.Next
+	if w.nextSeq == 0 {
+		w.nextSeq = 1
+	}
+	if len(w.segments) > 0 {
+		last := w.segments[len(w.segments)-1]
+		lastSeq, size, err := scanSegment(last.path)
+		if err != nil {
+			return nil, err
+		}
+		if err := os.Truncate(last.path, size); err != nil {
+			return nil, fmt.Errorf("failed to truncate wal segment: %w", err)
+		}
+		if lastSeq >= w.nextSeq {
+			w.nextSeq = lastSeq + 1
+		}
+		if last.first > w.nextSeq {
+			w.nextSeq = last.first
+		}
+		file, err := os.OpenFile(last.path, os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open wal segment: %w", err)
+		}
+		w.file, w.size = file, size
+	}
+	// Les enregistrements existants sont rejoués avant tout traitement
+	w.processed = w.nextSeq
+
+	if opts.Sync == SyncInterval {
+		w.wg.Add(1)
+		go w.syncLoop()
+	}
+	return w, nil
+}
+
+// loadPosition lit la dernière position enregistrée par Commit
+func (w *WAL) loadPosition() error {
+	data, err := os.ReadFile(filepath.Join(w.opts.Dir, positionFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read wal position: %w", err)
+	}
+	if err := json.Unmarshal(data, &w.committed); err != nil {
+		return fmt.Errorf("invalid wal position: %w", err)
+	}
+	return nil
+}
+
+// loadSegments liste les segments du répertoire, triés par première séquence
+func (w *WAL) loadSegments() error {
+	entries, err := os.ReadDir(w.opts.Dir)
+	if err != nil {
+		return fmt.Errorf("failed to list wal directory: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 16, 64)
+		if err != nil {
+			continue
+		}
+		w.segments = append(w.segments, segment{first: first, path: filepath.Join(w.opts.Dir, name)})
+	}
+	sort.Slice(w.segments, func(i, j int) bool {
+		return w.segments[i].first < w.segments[j].first
+	})
+	return nil
+}
+
+// Append ajoute un enregistrement et retourne sa séquence. Avec SyncAlways,
+// l'enregistrement est sur disque au retour.
+func (w *WAL) Append(payload []byte) (uint64, error) {
+	seqs, err := w.AppendBatch([][]byte{payload})
+	if err != nil {
+		return 0, err
+	}
+	return seqs[0], nil
+}
+
+// AppendBatch ajoute des enregistrements avec un seul fsync et retourne leurs séquences
+func (w *WAL) AppendBatch(payloads [][]byte) ([]uint64, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil || w.size >= w.opts.SegmentSize {
+		if err := w.rollLocked(); err != nil {
+			return nil, err
+		}
+	}
+
+	buf := make([]byte, 0, headerSize*len(payloads))
+	seqs := make([]uint64, 0, len(payloads))
+	for i, payload := range payloads {
+		seq := w.nextSeq + uint64(i)
+		buf = appendRecord(buf, seq, payload)
+		seqs = append(seqs, seq)
+	}
+	if _, err := w.file.Write(buf); err != nil {
+		return nil, fmt.Errorf("failed to append to wal: %w", err)
+	}
+	w.size += int64(len(buf))
+	w.nextSeq += uint64(len(payloads))
+	w.dirty = true
+
+	if w.opts.Sync == SyncAlways {
+		if err := w.syncLocked(); err != nil {
+			return nil, err
+		}
+	}
+	return seqs, nil
+}
+
+// appendRecord encode un enregistrement à la suite de buf
+func appendRecord(buf []byte, seq uint64, payload []byte) []byte {
+	var header [headerSize]byte
+	binary.LittleEndian.PutUint32(header[0:4], uint32(len(payload)))
+	binary.LittleEndian.PutUint64(header[8:16], seq)
+	crc := crc32.Update(0, crcTable, header[8:16])
+	crc = crc32.Update(crc, crcTable, payload)
+	binary.LittleEndian.PutUint32(header[4:8], crc)
+	buf = append(buf, header[:]...)
+	return append(buf, payload...)
+}
+
+// rollLocked ferme le segment courant et en ouvre un nouveau (w.mu tenu)
+func (w *WAL) rollLocked() error {
+	if w.file != nil {
+		if err := w.syncLocked(); err != nil {
+			return err
+		}
+		if err := w.file.Close(); err != nil {
+			return fmt.Errorf("failed to close wal segment: %w", err)
+		}
+		w.file = nil
+	}
+
+	path := filepath.Join(w.opts.Dir, fmt.Sprintf("%016x%s", w.nextSeq, segmentExt))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to create wal segment: %w", err)
+	}
+	w.file, w.size = file, 0
+	w.segments = append(w.segments, segment{first: w.nextSeq, path: path})
+	return syncDir(w.opts.Dir)
+}
+
+// syncLocked écrit le segment courant sur disque (w.mu tenu)
+func (w *WAL) syncLocked() error {
+	if !w.dirty || w.file == nil {
+		return nil
+	}
+	if err := w.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync wal: %w", err)
+	}
+	w.dirty = false
+	return nil
+}
+
+// Sync écrit les ajouts en attente sur disque
+func (w *WAL) Sync() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.syncLocked()
+}
+
+// syncLoop applique SyncInterval
+func (w *WAL) syncLoop() {
+	defer w.wg.Done()
+	ticker := time.NewTicker(w.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-ticker.C:
+			w.Sync()
+		}
+	}
+}
+
+// Process exécute fn, le traitement de l'enregistrement seq, puis le marque
+// traité. Snapshot attend la fin des traitements en cours.
+func (w *WAL) Process(seq uint64, fn func()) {
+	w.gate.RLock()
+	defer w.gate.RUnlock()
+	fn()
+	w.Done(seq)
+}
+
+// Done marque un enregistrement traité sans traitement (événement refusé
+// après son ajout, par exemple)
+func (w *WAL) Done(seq uint64) {
+	w.trackMu.Lock()
+	defer w.trackMu.Unlock()
+
+	if seq < w.processed {
+		return
+	}
+	w.done[seq] = struct{}{}
+	for {
+		if _, ok := w.done[w.processed]; !ok {
+			break
+		}
+		delete(w.done, w.processed)
+		w.processed++
+	}
+}
+
+// Position retourne les enregistrements traités jusqu'ici
+func (w *WAL) Position() Position {
+	w.trackMu.Lock()
+	defer w.trackMu.Unlock()
+
+	position := Position{Next: w.processed}
+	for seq := range w.done {
+		position.Done = append(position.Done, seq)
+	}
+	sort.Slice(position.Done, func(i, j int) bool { return position.Done[i] < position.Done[j] })
+	return position
+}
+
+// Snapshot exécute fn sans traitement en cours (voir Process) et retourne la
+// position à cet instant: un checkpoint capturé par fn contient exactement
+// les enregistrements de la position
+func (w *WAL) Snapshot(fn func()) Position {
+	w.gate.Lock()
+	defer w.gate.Unlock()
+	fn()
+	return w.Position()
+}
+
+// Committed retourne la dernière position enregistrée par Commit
+func (w *WAL) Committed() Position {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.committed
+}
+
+// Replay appelle fn pour chaque enregistrement non couvert par from, dans
+// l'ordre des séquences, et retourne le nombre d'enregistrements rejoués.
+// À appeler après Open, avant d'ajouter des enregistrements.
+func (w *WAL) Replay(from Position, fn func(seq uint64, payload []byte) error) (int, error) {
+	w.mu.Lock()
+	segments := append([]segment(nil), w.segments...)
+	w.mu.Unlock()
+
+	replayed := 0
+	for _, seg := range segments {
+		err := readSegment(seg.path, func(seq uint64, payload []byte) error {
+			if from.covers(seq) {
+				return nil
+			}
+			replayed++
+			return fn(seq, payload)
+		})
+		if err != nil {
+			return replayed, err
+		}
+	}
+	return replayed, nil
+}
+
+// Commit enregistre position et supprime les segments dont tous les
+// enregistrements sont traités. Le segment courant est toujours conservé.
+func (w *WAL) Commit(position Position) error {
+	data, err := json.Marshal(position)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(w.opts.Dir, positionFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("failed to write wal position: %w", err)
+	}
+	if err := syncFile(tmp); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace wal position: %w", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.committed = position
+
+	// Un segment est couvert si le suivant commence au plus à position.Next
+	keep := 0
+	for i := 0; i < len(w.segments)-1; i++ {
+		if w.segments[i+1].first > position.Next {
+			break
+		}
+		if err := os.Remove(w.segments[i].path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("failed to remove wal segment: %w", err)
+		}
+		keep = i + 1
+	}
+	w.segments = w.segments[keep:]
+	return nil
+}
+
+// Segments retourne le nombre de segments du journal
+func (w *WAL) Segments() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return len(w.segments)
+}
+
+// Close écrit les ajouts en attente et ferme le journal
+func (w *WAL) Close() error {
+	close(w.stop)
+	w.wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	if err := w.syncLocked(); err != nil {
+		return err
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// errTornRecord signale la fin d'un segment: enregistrement incomplet ou corrompu
+var errTornRecord = errors.New("torn wal record")
+
+// readSegment appelle fn pour chaque enregistrement valide d'un segment et
+// s'arrête au premier enregistrement incomplet ou corrompu
+func readSegment(path string, fn func(seq uint64, payload []byte) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open wal segment: %w", err)
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for {
+		seq, payload, err := readRecord(reader)
+		if err == io.EOF || err == errTornRecord {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read wal segment: %w", err)
+		}
+		if err := fn(seq, payload); err != nil {
+			return err
+		}
+	}
+}
+
+// scanSegment retourne la dernière séquence valide d'un segment et la taille
+// de ses enregistrements valides
+func scanSegment(path string) (lastSeq uint64, size int64, err error) {
+	err = readSegment(path, func(seq uint64, payload []byte) error {
+		lastSeq = seq
+		size += int64(headerSize + len(payload))
+		return nil
+	})
+	return lastSeq, size, err
+}
+
+// readRecord lit un enregistrement; errTornRecord si la fin est incomplète ou corrompue
+func readRecord(reader *bufio.Reader) (uint64, []byte, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(reader, header[:]); err != nil {
+		if err == io.EOF {
+			return 0, nil, io.EOF
+		}
+		if err == io.ErrUnexpectedEOF {
+			return 0, nil, errTornRecord
+		}
+		return 0, nil, err
+	}
+	length := binary.LittleEndian.Uint32(header[0:4])
+	if length > maxRecord {
+		return 0, nil, errTornRecord
+	}
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(reader, payload); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return 0, nil, errTornRecord
+		}
+		return 0, nil, err
+	}
+	crc := crc32.Update(0, crcTable, header[8:16])
+	crc = crc32.Update(crc, crcTable, payload)
+	if crc != binary.LittleEndian.Uint32(header[4:8]) {
+		return 0, nil, errTornRecord
+	}
+	return binary.LittleEndian.Uint64(header[8:16]), payload, nil
+}
+
+// syncFile écrit un fichier sur disque
+func syncFile(path string) error {
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer file.Close()
+	if err := file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", path, err)
+	}
+	return nil
+}
+
+// syncDir rend durable la création d'un fichier dans dir
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open wal directory: %w", err)
+	}
+	defer d.Close()
+	// Certains systèmes ne permettent pas de synchroniser un répertoire
+	d.Sync()
+	return nil
+}
@@ -0,0 +1,91 @@
+package wal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestWALReplayAndCommit teste le rejeu après un crash (fin de segment
+// tronquée), le suivi des enregistrements traités dans le désordre et la
+// suppression des segments couverts
+func TestWALReplayAndCommit(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{Dir: dir, SegmentSize: 100, Sync: SyncAlways}
+
+	w, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	for i := 1; i <= 10; i++ {
+		seq, err := w.Append([]byte(fmt.Sprintf("event-%d", i)))
+		if err != nil || seq != uint64(i) {
+			t.Fatalf("Append %d: seq %d, %v", i, seq, err)
+		}
+	}
+	// Plusieurs enregistrements de 23 octets par segment de 100 octets
+	if w.Segments() < 2 {
+		t.Fatalf("Expected several segments, got %d", w.Segments())
+	}
+
+	// 1 à 4 et 6 traités (6 avant 5), 5 en cours
+	for _, seq := range []uint64{1, 2, 3, 4, 6} {
+		w.Process(seq, func() {})
+	}
+	position := w.Snapshot(func() {})
+	if position.Next != 5 || len(position.Done) != 1 || position.Done[0] != 6 {
+		t.Fatalf("Unexpected position %+v", position)
+	}
+	if err := w.Commit(position); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	w.Close()
+
+	// Crash pendant l'écriture d'un enregistrement
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	last := segments[len(segments)-1]
+	file, err := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("open segment: %v", err)
+	}
+	file.Write([]byte{42, 0, 0, 0, 1, 2})
+	file.Close()
+
+	w, err = Open(opts)
+	if err != nil {
+		t.Fatalf("reopen: %v", err)
+	}
+	defer w.Close()
+
+	var replayed []string
+	count, err := w.Replay(w.Committed(), func(seq uint64, payload []byte) error {
+		replayed = append(replayed, string(payload))
+		return nil
+	})
+	if err != nil || count != 5 {
+		t.Fatalf("Expected 5 replayed events, got %d (%v)", count, err)
+	}
+	if replayed[0] != "event-5" || replayed[1] != "event-7" || replayed[4] != "event-10" {
+		t.Errorf("Unexpected replayed events %v", replayed)
+	}
+
+	// La fin tronquée a été coupée: les ajouts reprennent à 11
+	seq, err := w.Append([]byte("event-11"))
+	if err != nil || seq != 11 {
+		t.Fatalf("Expected seq 11 after reopening, got %d (%v)", seq, err)
+	}
+
+	// Un segment n'est supprimé que si tous ses enregistrements sont traités
+	before := w.Segments()
+	if err := w.Commit(Position{Next: 12}); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if w.Segments() != 1 || before <= 1 {
+		t.Errorf("Expected only the current segment to remain, got %d of %d", w.Segments(), before)
+	}
+
+	if _, err := Open(Options{Dir: dir, Sync: "sometimes"}); err == nil {
+		t.Error("Expected an invalid sync policy to be rejected")
+	}
+}
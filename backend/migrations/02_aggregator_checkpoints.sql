@@ -0,0 +1,11 @@
+-- ============================================
+-- Checkpoint de l'état de l'agrégateur
+-- ============================================
+
+-- Une ligne par agrégateur, remplacée à chaque checkpoint (format versionné,
+-- voir aggregation.Checkpoint)
+CREATE TABLE IF NOT EXISTS aggregator_checkpoints (
+    name TEXT PRIMARY KEY,
+    data JSONB NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,17 @@
+-- ============================================
+-- Identifiant unique des événements
+-- ============================================
+
+-- Supprimer les doublons déjà insérés (renvois d'un même événement), en
+-- gardant la première insertion
+DELETE FROM events a
+    USING events b
+    WHERE a.id = b.id
+      AND a.time = b.time
+      AND a.ctid > b.ctid;
+
+-- Un index unique d'une hypertable doit contenir la colonne de partition
+-- (time): un événement est identifié par (id, time), ce qui couvre les renvois
+-- d'un SDK qui fixe le timestamp. Utilisé par ON CONFLICT dans InsertEvent.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_events_id_time
+    ON events (id, time);
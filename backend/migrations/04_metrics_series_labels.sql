@@ -0,0 +1,19 @@
+-- ============================================
+-- Identité des séries des tables de métriques
+-- ============================================
+
+-- Une ligne de metrics_1m, metrics_1h ou metrics_1d est une série (nom et
+-- labels) d'une période: les sauvegardes remplacent une série par ON CONFLICT
+-- au lieu de supprimer toutes les lignes de la période.
+ALTER TABLE metrics_1m ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE metrics_1h ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+ALTER TABLE metrics_1d ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+
+-- Un index unique d'une hypertable doit contenir la colonne de partition
+-- (time). Utilisé par ON CONFLICT dans SaveMetrics1m et SaveRollup.
+CREATE UNIQUE INDEX IF NOT EXISTS idx_metrics_1m_series
+    ON metrics_1m (metric_name, labels, time);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_metrics_1h_series
+    ON metrics_1h (metric_name, labels, time);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_metrics_1d_series
+    ON metrics_1d (metric_name, labels, time);
@@ -0,0 +1,12 @@
+-- ============================================
+-- État fusionnable des séries agrégées
+-- ============================================
+
+-- L'état fusionnable d'une série (valeurs ou registres HLL des sets, sketch
+-- des histogrammes, compteurs topk) ne sert qu'à calculer le niveau suivant:
+-- metrics_1m alimente metrics_1h, qui alimente metrics_1d. Il est rangé dans
+-- une colonne binaire (JSON gzip, voir storage.EncodeState), que les lignes
+-- de metrics_1d n'ont pas besoin de remplir.
+ALTER TABLE metrics_1m ADD COLUMN IF NOT EXISTS state BYTEA;
+ALTER TABLE metrics_1h ADD COLUMN IF NOT EXISTS state BYTEA;
+ALTER TABLE metrics_1d ADD COLUMN IF NOT EXISTS state BYTEA;
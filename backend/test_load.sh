@@ -162,13 +162,14 @@ if command -v jq >/dev/null 2>&1; then
   echo "$response" | jq '
   {
     status: .status,
-    metrics_count: (.data | length),
-    total_events: (.data.total_events.value // 0),
-    pageviews: (.data.pageviews.value // 0),
-    clicks: (.data.clicks.value // 0),
-    purchases: (.data.purchases.value // 0),
-    revenue: (.data.revenue.value // 0),
-    unique_users: (.data.unique_users.count // 0)
+    range: .data.range,
+    metrics_count: (.data.metrics | length),
+    total_events: (.data.metrics.total_events.value // 0),
+    pageviews: (.data.metrics.pageviews.value // 0),
+    clicks: (.data.metrics.clicks.value // 0),
+    purchases: (.data.metrics.purchases.value // 0),
+    revenue: (.data.metrics.revenue.value // 0),
+    unique_users: (.data.metrics.unique_users.count // 0)
   }' 2>/dev/null || echo "Error parsing response"
 else
   echo "$response"
@@ -238,7 +239,7 @@ final_metrics=$(curl -s "$API_URL/api/v1/metrics")
 
 if command -v jq >/dev/null 2>&1; then
   echo "$final_metrics" | jq '
-  .data as $metrics |
+  .data.metrics as $metrics |
   {
     total_events: $metrics.total_events.value,
     pageviews: $metrics.pageviews.value,